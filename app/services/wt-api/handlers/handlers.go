@@ -7,13 +7,31 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"time"
 
 	"github.com/Fiiii/WT/app/services/wt-api/handlers/debug/checkgrp"
+	"github.com/Fiiii/WT/app/services/wt-api/handlers/jwksgrp"
+	"github.com/Fiiii/WT/app/services/wt-api/handlers/v1/accountsGrp"
+	"github.com/Fiiii/WT/app/services/wt-api/handlers/v1/apikeysGrp"
 	"github.com/Fiiii/WT/app/services/wt-api/handlers/v1/productsGrp"
+	"github.com/Fiiii/WT/app/services/wt-api/handlers/v1/salesGrp"
 	"github.com/Fiiii/WT/app/services/wt-api/handlers/v1/usersGrp"
+	"github.com/Fiiii/WT/business/core/account"
+	"github.com/Fiiii/WT/business/core/apikey"
+	"github.com/Fiiii/WT/business/core/lockout"
+	lockoutdb "github.com/Fiiii/WT/business/core/lockout/db"
 	"github.com/Fiiii/WT/business/core/product"
+	"github.com/Fiiii/WT/business/core/sale"
+	"github.com/Fiiii/WT/business/core/session"
+	"github.com/Fiiii/WT/business/core/sso"
 	"github.com/Fiiii/WT/business/core/user"
 	"github.com/Fiiii/WT/business/middleware"
+	"github.com/Fiiii/WT/business/sys/metrics"
+	"github.com/Fiiii/WT/business/sys/ratelimit"
+	"github.com/Fiiii/WT/foundation/keystore"
+	"github.com/Fiiii/WT/foundation/mailer"
+	"github.com/Fiiii/WT/foundation/oidc"
+	"github.com/Fiiii/WT/foundation/trace"
 	"github.com/Fiiii/WT/foundation/web"
 	"go.uber.org/zap"
 )
@@ -24,6 +42,17 @@ type APIMuxConfig struct {
 	Log      *zap.SugaredLogger
 	DB       *sqlx.DB
 	Auth     *auth.Auth
+	Keys     *keystore.KeyStore
+	OIDC     *oidc.Provider
+	Tracer   *trace.Tracer
+	Mailer   mailer.Mailer
+	Lockout  lockout.Config
+
+	// RateLimiter keeps the rate limit buckets, requests are not limited
+	// when it is nil. RateLimit applies to every route and client address,
+	// some routes are limited further.
+	RateLimiter ratelimit.Store
+	RateLimit   ratelimit.Limit
 }
 
 // APIMux constructs a http.Handler with all application routes defined.
@@ -33,41 +62,128 @@ func APIMux(cfg APIMuxConfig) http.Handler {
 	// Middleware are executed in reverse order (Panic closest to handler execution - onion)
 	app := web.NewApp(
 		cfg.Shutdown,
+		cfg.Tracer,
 		middleware.Logger(cfg.Log),
-		middleware.Errors(cfg.Log),
 		middleware.Metrics(),
+		middleware.Errors(cfg.Log),
+		rateLimit(cfg, cfg.RateLimit, middleware.ByIP),
 		middleware.Panics(),
 	)
 
 	// Load routes with previously initiated configuration.
+	wellKnown(app, cfg)
 	v1(app, cfg)
 
 	return app
 }
 
-// v1 aggregates all routes to the single version.
+// rateLimit constructs the rate limiting middleware for the limit, or nil to
+// leave requests unlimited when no store is configured.
+func rateLimit(cfg APIMuxConfig, limit ratelimit.Limit, key middleware.KeyFunc) web.Middleware {
+	if cfg.RateLimiter == nil {
+		return nil
+	}
+	return middleware.RateLimit(cfg.RateLimiter, limit, key)
+}
+
+// wellKnown registers the unversioned discovery routes. The JWKS endpoint is
+// only available when the service holds the signing keys.
+func wellKnown(app *web.App, cfg APIMuxConfig) {
+	if cfg.Keys == nil {
+		return
+	}
+
+	jgh := jwksgrp.Handlers{
+		Keys: cfg.Keys,
+	}
+	app.Handle(http.MethodGet, "", "/.well-known/jwks.json", jgh.JWKS)
+}
+
+// v1 aggregates all routes to the single version. Every route requires an
+// authenticated user unless it is explicitly registered without authen, and
+// the permission the route needs. Ownership is enforced by the cores.
 func v1(app *web.App, cfg APIMuxConfig) {
 	const version = "v1"
 
-	// Register user management endpoints.
+	authen := middleware.Authenticate(cfg.Auth)
+	authenMFA := middleware.AuthenticateMFA(cfg.Auth)
+	can := middleware.AuthorizeAll
+
+	// Guessing credentials and sending mail are limited far more strictly.
+	guard := rateLimit(cfg, ratelimit.Limit{Requests: 10, Period: time.Minute, Burst: 5}, middleware.ByIP)
+	guardSubject := rateLimit(cfg, ratelimit.Limit{Requests: 10, Period: time.Minute, Burst: 5}, middleware.BySubject)
+
+	// Register user management endpoints. Signing in through an OIDC provider
+	// is only available when one is configured.
 	ugh := usersGrp.Handlers{
-		User: user.NewCore(cfg.Log, cfg.DB),
+		User:    user.NewCore(cfg.Log, cfg.DB, cfg.Mailer),
+		Session: session.NewCore(cfg.Log, cfg.DB),
+		Lockout: lockout.NewCore(cfg.Log, lockoutdb.NewStore(cfg.Log, cfg.DB), cfg.Lockout),
+		Auth:    cfg.Auth,
+	}
+	if cfg.OIDC != nil {
+		core := sso.NewCore(cfg.Log, cfg.DB, cfg.OIDC)
+		ugh.SSO = &core
+		app.Handle(http.MethodGet, version, "/auth/oidc/login", ugh.OIDCLogin)
+		app.Handle(http.MethodGet, version, "/auth/oidc/callback", ugh.OIDCCallback)
 	}
-	app.Handle(http.MethodGet, version, "/users", ugh.Query)
-	app.Handle(http.MethodGet, version, "/users/:id", ugh.QueryByID)
-	app.Handle(http.MethodPost, version, "/users", ugh.Create)
-	app.Handle(http.MethodPut, version, "/users/:id", ugh.Update)
-	app.Handle(http.MethodDelete, version, "/users/:id", ugh.Delete)
+	app.Handle(http.MethodGet, version, "/users/token", ugh.Token, guard)
+	app.Handle(http.MethodPost, version, "/users/token/refresh", ugh.Refresh)
+	app.Handle(http.MethodPost, version, "/users/token/mfa", ugh.VerifyMFA, authenMFA, guardSubject)
+	app.Handle(http.MethodPost, version, "/users/password/forgot", ugh.ForgotPassword, guard)
+	app.Handle(http.MethodPost, version, "/users/password/reset", ugh.ResetPassword, guard)
+	app.Handle(http.MethodPost, version, "/users/email/verify", ugh.VerifyEmail, guard)
+	app.Handle(http.MethodPost, version, "/users/logout", ugh.Logout, authen)
+	app.Handle(http.MethodGet, version, "/users", ugh.Query, authen, can(auth.PermUsersRead))
+	app.Handle(http.MethodGet, version, "/users/:id", ugh.QueryByID, authen)
+	app.Handle(http.MethodPost, version, "/users", ugh.Create, authen, can(auth.PermUsersWrite))
+	app.Handle(http.MethodPost, version, "/users/unlock", ugh.Unlock, authen, can(auth.PermUsersWrite))
+	app.Handle(http.MethodPut, version, "/users/:id", ugh.Update, authen)
+	app.Handle(http.MethodDelete, version, "/users/:id", ugh.Delete, authen)
+	app.Handle(http.MethodPost, version, "/users/:id/email/verification", ugh.RequestEmailVerification, authen, guardSubject)
+	app.Handle(http.MethodPost, version, "/users/:id/mfa", ugh.EnrollMFA, authen)
+	app.Handle(http.MethodPost, version, "/users/:id/mfa/confirm", ugh.ConfirmMFA, authen)
+	app.Handle(http.MethodDelete, version, "/users/:id/mfa", ugh.ResetMFA, authen, can(auth.PermUsersWrite))
 
 	// Register product management endpoints.
 	pgh := productsGrp.Handlers{
 		Product: product.NewCore(cfg.Log, cfg.DB),
 	}
-	app.Handle(http.MethodGet, version, "/products", pgh.Query)
-	app.Handle(http.MethodGet, version, "/products/:id", pgh.QueryByID)
-	app.Handle(http.MethodPost, version, "/products", pgh.Create)
-	app.Handle(http.MethodPut, version, "/products/:id", pgh.Update)
-	app.Handle(http.MethodDelete, version, "/products/:id", pgh.Delete)
+	app.Handle(http.MethodGet, version, "/products", pgh.Query, authen, can(auth.PermProductsRead))
+	app.Handle(http.MethodGet, version, "/products/:id", pgh.QueryByID, authen, can(auth.PermProductsRead))
+	app.Handle(http.MethodPost, version, "/products", pgh.Create, authen, can(auth.PermProductsWrite))
+	app.Handle(http.MethodPut, version, "/products/:id", pgh.Update, authen, can(auth.PermProductsWrite))
+	app.Handle(http.MethodDelete, version, "/products/:id", pgh.Delete, authen, can(auth.PermProductsWrite))
+	app.Handle(http.MethodPost, version, "/products/:id/purchase", pgh.Purchase, authen, can(auth.PermProductsPurchase))
+	app.Handle(http.MethodPost, version, "/products/:id/reservations", pgh.Reserve, authen, can(auth.PermProductsPurchase))
+	app.Handle(http.MethodPost, version, "/products/reservations/:id/purchase", pgh.PurchaseReservation, authen, can(auth.PermProductsPurchase))
+	app.Handle(http.MethodDelete, version, "/products/reservations/:id", pgh.Release, authen, can(auth.PermProductsPurchase))
+
+	// Register account endpoints. Opening an account adds a user and their
+	// first product at once, deleting one archives the products of the user.
+	acgh := accountsGrp.Handlers{
+		Account: account.NewCore(cfg.Log, cfg.DB, cfg.Mailer),
+	}
+	app.Handle(http.MethodPost, version, "/accounts", acgh.Create, authen, can(auth.PermUsersWrite, auth.PermProductsWrite))
+	app.Handle(http.MethodDelete, version, "/accounts/:id", acgh.Delete, authen, can(auth.PermUsersWrite, auth.PermProductsWrite))
+
+	// Register sale management endpoints.
+	sgh := salesGrp.Handlers{
+		Sale: sale.NewCore(cfg.Log, cfg.DB),
+	}
+	app.Handle(http.MethodGet, version, "/sales", sgh.Query, authen)
+	app.Handle(http.MethodGet, version, "/sales/:id", sgh.QueryByID, authen)
+	app.Handle(http.MethodPost, version, "/sales", sgh.Create, authen, can(auth.PermSalesWrite))
+	app.Handle(http.MethodPost, version, "/sales/:id/void", sgh.Void, authen, can(auth.PermSalesVoid))
+
+	// Register API key management endpoints.
+	agh := apikeysGrp.Handlers{
+		APIKey: apikey.NewCore(cfg.Log, cfg.DB),
+	}
+	app.Handle(http.MethodGet, version, "/apikeys/user/:id", agh.QueryByUserID, authen)
+	app.Handle(http.MethodPost, version, "/apikeys", agh.Create, authen, can(auth.PermAPIKeysWrite))
+	app.Handle(http.MethodPut, version, "/apikeys/:id/scopes", agh.UpdateScopes, authen, can(auth.PermAPIKeysWrite))
+	app.Handle(http.MethodDelete, version, "/apikeys/:id", agh.Revoke, authen, can(auth.PermAPIKeysWrite))
 }
 
 // DebugMux registers all the debug standard library routes and then custom
@@ -86,6 +202,7 @@ func DebugMux(build string, log *zap.SugaredLogger, db *sqlx.DB) http.Handler {
 
 	mux.HandleFunc("/debug/readiness", cgh.Readiness)
 	mux.HandleFunc("/debug/liveness", cgh.Liveness)
+	mux.Handle("/debug/metrics", metrics.Handler(db))
 
 	return mux
 }
@@ -0,0 +1,23 @@
+// Package jwksgrp maintains the group of handlers for publishing the public
+// keys used to verify tokens.
+package jwksgrp
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Fiiii/WT/foundation/keystore"
+	"github.com/Fiiii/WT/foundation/web"
+)
+
+// Handlers manages the set of JWKS endpoints.
+type Handlers struct {
+	Keys *keystore.KeyStore
+}
+
+// JWKS returns the public keys of the key store as a JSON Web Key Set, so
+// other services can verify tokens without access to the private keys.
+func (h Handlers) JWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	return web.Respond(ctx, w, h.Keys.JWKS(), http.StatusOK)
+}
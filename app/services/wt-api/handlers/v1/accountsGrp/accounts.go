@@ -0,0 +1,61 @@
+// Package accountsGrp - Package accounts group contains all account related handlers.
+package accountsGrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Fiiii/WT/business/core/account"
+	"github.com/Fiiii/WT/business/core/product"
+	"github.com/Fiiii/WT/business/core/user"
+	"github.com/Fiiii/WT/business/sys/problem"
+	"github.com/Fiiii/WT/foundation/web"
+)
+
+// Handlers manages the set of account endpoints.
+type Handlers struct {
+	Account account.Core
+}
+
+// Create adds a new user together with their first product.
+func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var na account.NewAccount
+	if err := web.Decode(r, &na); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	acc, err := h.Account.Create(ctx, na, v.Now)
+	if err != nil {
+		return fmt.Errorf("creating new account, email[%s]: %w", na.User.Email, err)
+	}
+
+	return web.Respond(ctx, w, acc, http.StatusCreated)
+}
+
+// Delete removes a user and archives their products.
+func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	userID := web.Param(r, "id")
+
+	if err := h.Account.Delete(ctx, userID, v.Now); err != nil {
+		switch {
+		case errors.Is(err, product.ErrInvalidID), errors.Is(err, user.ErrInvalidID):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("ID[%s]: %w", userID, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
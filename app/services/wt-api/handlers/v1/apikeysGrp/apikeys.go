@@ -0,0 +1,113 @@
+// Package apikeysGrp - Package API keys group contains all API key related handlers.
+package apikeysGrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Fiiii/WT/business/core/apikey"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/problem"
+	"github.com/Fiiii/WT/foundation/web"
+)
+
+type Handlers struct {
+	APIKey apikey.Core
+}
+
+// Create generates a new API key. The key is owned by the authenticated user
+// unless another user is specified.
+func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return problem.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	var nak apikey.NewAPIKey
+	if err := web.Decode(r, &nak); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	if nak.UserID == "" {
+		nak.UserID = claims.Subject
+	}
+
+	key, err := h.APIKey.Create(ctx, nak, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, apikey.ErrInvalidID), errors.Is(err, apikey.ErrInvalidScope):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, apikey.ErrOwnerNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("creating new api key, userID[%s]: %w", nak.UserID, err)
+		}
+	}
+
+	return web.Respond(ctx, w, key, http.StatusCreated)
+}
+
+// QueryByUserID returns the API keys owned by a user.
+func (h Handlers) QueryByUserID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	keys, err := h.APIKey.QueryByUserID(ctx, id)
+	if err != nil {
+		if errors.Is(err, apikey.ErrInvalidID) {
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		}
+		return fmt.Errorf("userID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, keys, http.StatusOK)
+}
+
+// UpdateScopes replaces the scopes of an API key.
+func (h Handlers) UpdateScopes(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var us apikey.UpdateScopes
+	if err := web.Decode(r, &us); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	if err := h.APIKey.UpdateScopes(ctx, id, us); err != nil {
+		switch {
+		case errors.Is(err, apikey.ErrInvalidID), errors.Is(err, apikey.ErrInvalidScope):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, apikey.ErrNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Revoke revokes an API key.
+func (h Handlers) Revoke(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	id := web.Param(r, "id")
+	if err := h.APIKey.Revoke(ctx, id, v.Now); err != nil {
+		switch {
+		case errors.Is(err, apikey.ErrInvalidID):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, apikey.ErrNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
@@ -3,12 +3,16 @@ package productsGrp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Fiiii/WT/business/core/product"
+	"github.com/Fiiii/WT/business/sys/paging"
+	"github.com/Fiiii/WT/business/sys/problem"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
-	weberrors "github.com/Fiiii/WT/business/web"
 	"github.com/Fiiii/WT/foundation/web"
 )
 
@@ -16,25 +20,70 @@ type Handlers struct {
 	Product product.Core
 }
 
-// Query returns a list of products.
+// Query returns a page of products. The page, its order and the filters are
+// read from the query string.
 func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	page := web.Param(r, "page")
-	pageNumber, err := strconv.Atoi(page)
+	qs := r.URL.Query()
+
+	req, err := paging.Parse(qs, product.OrderByFields, product.DefaultOrderBy)
 	if err != nil {
-		return weberrors.NewRequestError(fmt.Errorf("invalid page format, page[%s]", page), http.StatusBadRequest)
+		return problem.NewRequestError(err, http.StatusBadRequest)
 	}
-	rows := web.Param(r, "rows")
-	rowsPerPage, err := strconv.Atoi(rows)
+
+	filter, err := parseFilter(qs)
 	if err != nil {
-		return weberrors.NewRequestError(fmt.Errorf("invalid rows format, rows[%s]", rows), http.StatusBadRequest)
+		return problem.NewRequestError(err, http.StatusBadRequest)
 	}
 
-	products, err := h.Product.Query(ctx, pageNumber, rowsPerPage)
+	products, page, err := h.Product.Query(ctx, filter, req)
 	if err != nil {
 		return fmt.Errorf("unable to query for products: %w", err)
 	}
 
-	return web.Respond(ctx, w, products, http.StatusOK)
+	return web.Respond(ctx, w, paging.NewResponse(r.URL, products, page), http.StatusOK)
+}
+
+// parseFilter reads the filters of a product query from the query string.
+// The costs are in cents and the dates in RFC3339 format.
+func parseFilter(qs url.Values) (product.QueryFilter, error) {
+	var filter product.QueryFilter
+
+	if v := qs.Get("name"); v != "" {
+		filter.Name = &v
+	}
+	if v := qs.Get("min_cost"); v != "" {
+		cost, err := strconv.Atoi(v)
+		if err != nil {
+			return product.QueryFilter{}, fmt.Errorf("invalid min_cost format [%s]", v)
+		}
+		filter.MinCost = &cost
+	}
+	if v := qs.Get("max_cost"); v != "" {
+		cost, err := strconv.Atoi(v)
+		if err != nil {
+			return product.QueryFilter{}, fmt.Errorf("invalid max_cost format [%s]", v)
+		}
+		filter.MaxCost = &cost
+	}
+	if v := qs.Get("user_id"); v != "" {
+		filter.UserID = &v
+	}
+	if v := qs.Get("start_created_date"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return product.QueryFilter{}, fmt.Errorf("invalid start_created_date format [%s]", v)
+		}
+		filter.StartCreatedDate = &t
+	}
+	if v := qs.Get("end_created_date"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return product.QueryFilter{}, fmt.Errorf("invalid end_created_date format [%s]", v)
+		}
+		filter.EndCreatedDate = &t
+	}
+
+	return filter, nil
 }
 
 // QueryByID returns a product by its ID.
@@ -97,3 +146,106 @@ func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
+
+// Purchase buys a quantity of a product, failing when it is out of stock.
+func (h Handlers) Purchase(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var np product.NewPurchase
+	if err := web.Decode(r, &np); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	pur, err := h.Product.Purchase(ctx, id, np, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrInvalidID):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, product.ErrNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s] Purchase[%+v]: %w", id, np, err)
+		}
+	}
+
+	return web.Respond(ctx, w, pur, http.StatusCreated)
+}
+
+// Reserve holds a quantity of a product for a cart for a limited time.
+func (h Handlers) Reserve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var nr product.NewReservation
+	if err := web.Decode(r, &nr); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	res, err := h.Product.Reserve(ctx, id, nr, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrInvalidID):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, product.ErrNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s] Reservation[%+v]: %w", id, nr, err)
+		}
+	}
+
+	return web.Respond(ctx, w, res, http.StatusCreated)
+}
+
+// PurchaseReservation buys the items held by a reservation.
+func (h Handlers) PurchaseReservation(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var nrp product.NewReservationPurchase
+	if err := web.Decode(r, &nrp); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	pur, err := h.Product.PurchaseReservation(ctx, id, nrp, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrInvalidID):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, product.ErrReservationNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, product.ErrReservationExpired):
+			return problem.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("reservationID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, pur, http.StatusCreated)
+}
+
+// Release cancels a reservation, making the held items available again.
+func (h Handlers) Release(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	if err := h.Product.Release(ctx, id); err != nil {
+		switch {
+		case errors.Is(err, product.ErrInvalidID):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, product.ErrReservationNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("reservationID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
@@ -0,0 +1,142 @@
+// Package salesGrp - Package sales group contains all sale related handlers.
+package salesGrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/sale"
+	"github.com/Fiiii/WT/business/sys/paging"
+	"github.com/Fiiii/WT/business/sys/problem"
+	"github.com/Fiiii/WT/foundation/web"
+)
+
+type Handlers struct {
+	Sale sale.Core
+}
+
+// Query returns a page of sales. The page, its order and the filters are
+// read from the query string. Users only see their own sales by filtering on
+// their user_id.
+func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	qs := r.URL.Query()
+
+	req, err := paging.Parse(qs, sale.OrderByFields, sale.DefaultOrderBy)
+	if err != nil {
+		return problem.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	filter, err := parseFilter(qs)
+	if err != nil {
+		return problem.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	sales, page, err := h.Sale.Query(ctx, filter, req)
+	if err != nil {
+		if errors.Is(err, sale.ErrInvalidDateRange) {
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		}
+		return fmt.Errorf("unable to query for sales: %w", err)
+	}
+
+	return web.Respond(ctx, w, paging.NewResponse(r.URL, sales, page), http.StatusOK)
+}
+
+// parseFilter reads the filters of a sale query from the query string. The
+// dates are in RFC3339 format.
+func parseFilter(qs url.Values) (sale.QueryFilter, error) {
+	var filter sale.QueryFilter
+
+	if v := qs.Get("product_id"); v != "" {
+		filter.ProductID = &v
+	}
+	if v := qs.Get("user_id"); v != "" {
+		filter.UserID = &v
+	}
+	if v := qs.Get("start_created_date"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return sale.QueryFilter{}, fmt.Errorf("invalid start_created_date format [%s]", v)
+		}
+		filter.StartCreatedDate = &t
+	}
+	if v := qs.Get("end_created_date"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return sale.QueryFilter{}, fmt.Errorf("invalid end_created_date format [%s]", v)
+		}
+		filter.EndCreatedDate = &t
+	}
+
+	return filter, nil
+}
+
+// QueryByID returns a sale by its ID.
+func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	sl, err := h.Sale.QueryByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sale.ErrInvalidID):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, sale.ErrNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, sl, http.StatusOK)
+}
+
+// Create records a new sale in the system on behalf of a user. Users buy
+// through the products endpoints instead.
+func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var ns sale.NewSale
+	if err := web.Decode(r, &ns); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	sl, err := h.Sale.Create(ctx, ns, v.Now)
+	if err != nil {
+		if errors.Is(err, sale.ErrInvalidID) {
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		}
+		return fmt.Errorf("creating new sale, ns[%+v]: %w", ns, err)
+	}
+
+	return web.Respond(ctx, w, sl, http.StatusCreated)
+}
+
+// Void refunds a sale in the system.
+func (h Handlers) Void(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	id := web.Param(r, "id")
+	if err := h.Sale.Void(ctx, id, v.Now); err != nil {
+		switch {
+		case errors.Is(err, sale.ErrInvalidID):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, sale.ErrNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, sale.ErrAlreadyVoided):
+			return problem.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
@@ -6,37 +6,117 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Fiiii/WT/business/sys/auth"
-	weberrors "github.com/Fiiii/WT/business/web"
+	"github.com/Fiiii/WT/business/sys/paging"
+	"github.com/Fiiii/WT/business/sys/problem"
+	"github.com/Fiiii/WT/business/sys/validate"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
+	"github.com/Fiiii/WT/business/core/lockout"
+	"github.com/Fiiii/WT/business/core/session"
+	"github.com/Fiiii/WT/business/core/sso"
 	"github.com/Fiiii/WT/business/core/user"
 	"github.com/Fiiii/WT/foundation/web"
 )
 
 type Handlers struct {
-	User user.Core
+	User    user.Core
+	Session session.Core
+	Lockout lockout.Core
+	SSO     *sso.Core
+	Auth    *auth.Auth
 }
 
-// Query returns a list of users with paging.
+// stateCookie binds an OIDC login to the browser which started it.
+const stateCookie = "oidc_state"
+
+// tokenResponse is the document returned whenever tokens are issued. When
+// MFARequired is set the token is only good for entering the second factor.
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	MFARequired  bool   `json:"mfa_required,omitempty"`
+}
+
+// forgotRequest is the document expected when asking for a password reset.
+type forgotRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// unlockRequest is the document expected when unlocking an email.
+type unlockRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// verifyRequest is the document expected when verifying an email.
+type verifyRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// mfaRequest is the document expected when entering a second factor, either
+// a TOTP code or a recovery code.
+type mfaRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// refreshRequest is the document expected when presenting a refresh token.
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Query returns a page of users. The page, its order and the filters are
+// read from the query string.
 func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	page := web.Param(r, "page")
-	pageNumber, err := strconv.Atoi(page)
+	qs := r.URL.Query()
+
+	req, err := paging.Parse(qs, user.OrderByFields, user.DefaultOrderBy)
 	if err != nil {
-		return weberrors.NewRequestError(fmt.Errorf("invalid page format [%s]", page), http.StatusBadRequest)
+		return problem.NewRequestError(err, http.StatusBadRequest)
 	}
-	rows := web.Param(r, "rows")
-	rowsPerPage, err := strconv.Atoi(rows)
+
+	filter, err := parseFilter(qs)
 	if err != nil {
-		return weberrors.NewRequestError(fmt.Errorf("invalid rows format [%s]", rows), http.StatusBadRequest)
+		return problem.NewRequestError(err, http.StatusBadRequest)
 	}
 
-	users, err := h.User.Query(ctx, pageNumber, rowsPerPage)
+	users, page, err := h.User.Query(ctx, filter, req)
 	if err != nil {
 		return fmt.Errorf("unable to query for users: %w", err)
 	}
 
-	return web.Respond(ctx, w, users, http.StatusOK)
+	return web.Respond(ctx, w, paging.NewResponse(r.URL, users, page), http.StatusOK)
+}
+
+// parseFilter reads the filters of a user query from the query string. The
+// dates are in RFC3339 format.
+func parseFilter(qs url.Values) (user.QueryFilter, error) {
+	var filter user.QueryFilter
+
+	if v := qs.Get("name"); v != "" {
+		filter.Name = &v
+	}
+	if v := qs.Get("email"); v != "" {
+		filter.Email = &v
+	}
+	if v := qs.Get("start_created_date"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return user.QueryFilter{}, fmt.Errorf("invalid start_created_date format [%s]", v)
+		}
+		filter.StartCreatedDate = &t
+	}
+	if v := qs.Get("end_created_date"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return user.QueryFilter{}, fmt.Errorf("invalid end_created_date format [%s]", v)
+		}
+		filter.EndCreatedDate = &t
+	}
+
+	return filter, nil
 }
 
 // QueryByID returns a user by its ID.
@@ -92,24 +172,206 @@ func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 // Delete removes a user from the system.
 func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	claims, err := auth.GetClaims(ctx)
+	userID := web.Param(r, "id")
+
+	if err := h.User.Delete(ctx, userID); err != nil {
+		switch {
+		case errors.Is(err, user.ErrInvalidID):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, user.ErrNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", userID, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Token provides an API token and a refresh token for the authenticated user.
+// The credentials are expected as Basic auth: email and password.
+func (h Handlers) Token(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	email, pass, ok := r.BasicAuth()
+	if !ok {
+		err := errors.New("must provide email and password in Basic auth")
+		return problem.NewRequestError(err, http.StatusUnauthorized)
+	}
+
+	// Failed logins are counted against the email and the client address.
+	emailKey, ipKey := lockout.EmailKey(email), lockout.IPKey(web.RemoteIP(r))
+	if retry, err := h.Lockout.Check(ctx, v.Now, emailKey, ipKey); err != nil {
+		switch {
+		case errors.Is(err, lockout.ErrLocked), errors.Is(err, lockout.ErrThrottled):
+			return throttled(w, retry, v.Now, err)
+		default:
+			return fmt.Errorf("checking lockout: %w", err)
+		}
+	}
+
+	claims, err := h.User.Authenticate(ctx, v.Now, email, pass)
 	if err != nil {
-		return weberrors.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+		switch {
+		case errors.Is(err, user.ErrAuthenticationFailure):
+			if err := h.Lockout.Fail(ctx, v.Now, emailKey, ipKey); err != nil {
+				return fmt.Errorf("counting failure: %w", err)
+			}
+			return problem.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return fmt.Errorf("authenticating: %w", err)
+		}
 	}
 
+	if err := h.Lockout.Succeed(ctx, emailKey); err != nil {
+		return fmt.Errorf("resetting failures: %w", err)
+	}
+
+	return h.login(ctx, w, claims, v.Now)
+}
+
+// VerifyMFA exchanges the token issued after the password was accepted and
+// a second factor for an API token and a refresh token.
+func (h Handlers) VerifyMFA(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	pending, err := auth.GetClaims(ctx)
+	if err != nil {
+		return problem.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	var req mfaRequest
+	if err := web.Decode(r, &req); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	// Six digit codes are easily guessed without a limit on the attempts.
+	mfaKey := lockout.MFAKey(pending.Subject)
+	if retry, err := h.Lockout.Check(ctx, v.Now, mfaKey); err != nil {
+		switch {
+		case errors.Is(err, lockout.ErrLocked), errors.Is(err, lockout.ErrThrottled):
+			return throttled(w, retry, v.Now, err)
+		default:
+			return fmt.Errorf("checking lockout: %w", err)
+		}
+	}
+
+	claims, err := h.User.VerifyMFA(ctx, pending.Subject, req.Code, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrInvalidMFACode):
+			if err := h.Lockout.Fail(ctx, v.Now, mfaKey); err != nil {
+				return fmt.Errorf("counting failure: %w", err)
+			}
+			return problem.NewRequestError(err, http.StatusUnauthorized)
+		case errors.Is(err, user.ErrMFANotEnrolled):
+			return problem.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return fmt.Errorf("verifying second factor: %w", err)
+		}
+	}
+
+	if err := h.Lockout.Succeed(ctx, mfaKey); err != nil {
+		return fmt.Errorf("resetting failures: %w", err)
+	}
+
+	// The pending token has served its purpose.
+	if pending.ID != "" && pending.ExpiresAt != nil {
+		if err := h.Session.RevokeAccess(ctx, pending.ID, pending.ExpiresAt.Time); err != nil {
+			return fmt.Errorf("revoking pending token: %w", err)
+		}
+	}
+
+	tkn, err := h.issue(ctx, claims, v.Now)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// EnrollMFA generates a TOTP secret for the user to add to an authenticator.
+func (h Handlers) EnrollMFA(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	userID := web.Param(r, "id")
 
-	// If you are not an admin and looking to delete someone other than yourself.
-	if !claims.Authorized(auth.RoleAdmin) && claims.Subject != userID {
-		return weberrors.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	enr, err := h.User.EnrollMFA(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrInvalidID):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, user.ErrNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, user.ErrMFAEnabled):
+			return problem.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", userID, err)
+		}
 	}
 
-	if err := h.User.Delete(ctx, userID); err != nil {
+	return web.Respond(ctx, w, enr, http.StatusOK)
+}
+
+// ConfirmMFA enables multi-factor authentication with a code from the
+// authenticator and returns the recovery codes of the user.
+func (h Handlers) ConfirmMFA(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var req mfaRequest
+	if err := web.Decode(r, &req); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	userID := web.Param(r, "id")
+	codes, err := h.User.ConfirmMFA(ctx, userID, req.Code, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrInvalidID), errors.Is(err, user.ErrInvalidMFACode):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, user.ErrNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, user.ErrMFAEnabled), errors.Is(err, user.ErrMFANotEnrolled):
+			return problem.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", userID, err)
+		}
+	}
+
+	resp := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// ResetMFA disables multi-factor authentication for a user.
+func (h Handlers) ResetMFA(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := web.Param(r, "id")
+
+	if err := h.User.ResetMFA(ctx, userID); err != nil {
 		switch {
 		case errors.Is(err, user.ErrInvalidID):
-			return weberrors.NewRequestError(err, http.StatusBadRequest)
+			return problem.NewRequestError(err, http.StatusBadRequest)
 		case errors.Is(err, user.ErrNotFound):
-			return weberrors.NewRequestError(err, http.StatusNotFound)
+			return problem.NewRequestError(err, http.StatusNotFound)
 		default:
 			return fmt.Errorf("ID[%s]: %w", userID, err)
 		}
@@ -117,3 +379,333 @@ func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
+
+// Unlock lifts the lockout of an email after too many failed logins.
+func (h Handlers) Unlock(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var req unlockRequest
+	if err := web.Decode(r, &req); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := h.Lockout.Unlock(ctx, req.Email, v.Now); err != nil {
+		return fmt.Errorf("unlocking: %w", err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ForgotPassword mails a password reset token. It accepts every well formed
+// email so the response does not reveal which emails have an account.
+func (h Handlers) ForgotPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var req forgotRequest
+	if err := web.Decode(r, &req); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	h.User.RequestPasswordReset(ctx, req.Email, v.Now)
+
+	return web.Respond(ctx, w, nil, http.StatusAccepted)
+}
+
+// ResetPassword sets a new password with a mailed reset token. Every session
+// of the user is signed out.
+func (h Handlers) ResetPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var rp user.ResetPassword
+	if err := web.Decode(r, &rp); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	userID, err := h.User.ResetPassword(ctx, rp, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrInvalidToken):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("resetting password: %w", err)
+		}
+	}
+
+	if err := h.Session.RevokeUser(ctx, userID, v.Now); err != nil {
+		return fmt.Errorf("revoking sessions: %w", err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// RequestEmailVerification mails an email verification token to the user.
+func (h Handlers) RequestEmailVerification(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	userID := web.Param(r, "id")
+	if err := h.User.RequestEmailVerification(ctx, userID, v.Now); err != nil {
+		switch {
+		case errors.Is(err, user.ErrInvalidID):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, user.ErrNotFound):
+			return problem.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, user.ErrEmailVerified):
+			return problem.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", userID, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusAccepted)
+}
+
+// VerifyEmail marks an email as verified with a mailed verification token.
+func (h Handlers) VerifyEmail(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var req verifyRequest
+	if err := web.Decode(r, &req); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := h.User.VerifyEmail(ctx, req.Token, v.Now); err != nil {
+		switch {
+		case errors.Is(err, user.ErrInvalidToken):
+			return problem.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("verifying email: %w", err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// OIDCLogin sends the user agent to the OIDC provider to sign in.
+func (h Handlers) OIDCLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	authURL, state, err := h.SSO.Begin(ctx, v.Now)
+	if err != nil {
+		return fmt.Errorf("beginning login: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/v1/auth/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+
+	return nil
+}
+
+// OIDCCallback completes the login when the OIDC provider sends the user
+// agent back and issues tokens for the linked user.
+func (h Handlers) OIDCCallback(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return problem.NewRequestError(fmt.Errorf("login failed at the provider: %s", e), http.StatusUnauthorized)
+	}
+
+	state := q.Get("state")
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil || cookie.Value != state {
+		return problem.NewRequestError(sso.ErrInvalidState, http.StatusUnauthorized)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   stateCookie,
+		Path:   "/v1/auth/oidc",
+		MaxAge: -1,
+	})
+
+	userID, err := h.SSO.Complete(ctx, state, q.Get("code"), v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, sso.ErrInvalidState), errors.Is(err, sso.ErrNotLinked):
+			return problem.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return fmt.Errorf("completing login: %w", err)
+		}
+	}
+
+	claims, err := h.User.LoginClaims(ctx, userID, v.Now)
+	if err != nil {
+		return fmt.Errorf("userID[%s]: %w", userID, err)
+	}
+
+	return h.login(ctx, w, claims, v.Now)
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token pair.
+// Every refresh token can be exchanged only once.
+func (h Handlers) Refresh(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var req refreshRequest
+	if err := web.Decode(r, &req); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	ref, err := h.Session.Rotate(ctx, req.RefreshToken, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, session.ErrInvalidToken), errors.Is(err, session.ErrTokenReused):
+			return problem.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return fmt.Errorf("rotating refresh token: %w", err)
+		}
+	}
+
+	claims, err := h.User.Claims(ctx, ref.UserID, v.Now)
+	if err != nil {
+		return fmt.Errorf("userID[%s]: %w", ref.UserID, err)
+	}
+
+	tkn := tokenResponse{
+		RefreshToken: ref.Token,
+	}
+	tkn.Token, err = h.Auth.GenerateToken(claims)
+	if err != nil {
+		return fmt.Errorf("generating token: %w", err)
+	}
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// Logout revokes the provided refresh token family and the access token used
+// to authenticate the request.
+func (h Handlers) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return problem.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	var req refreshRequest
+	if err := web.Decode(r, &req); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := h.Session.Revoke(ctx, claims.Subject, req.RefreshToken, v.Now); err != nil {
+		switch {
+		case errors.Is(err, session.ErrInvalidToken):
+			return problem.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return fmt.Errorf("revoking refresh token: %w", err)
+		}
+	}
+
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		if err := h.Session.RevokeAccess(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+			return fmt.Errorf("revoking access token: %w", err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// issue generates an access token for the claims and starts a new refresh
+// token family for the user.
+func (h Handlers) issue(ctx context.Context, claims auth.Claims, now time.Time) (tokenResponse, error) {
+	ref, err := h.Session.Issue(ctx, claims.Subject, now)
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("issuing refresh token: %w", err)
+	}
+
+	tkn := tokenResponse{
+		RefreshToken: ref.Token,
+	}
+	tkn.Token, err = h.Auth.GenerateToken(claims)
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("generating token: %w", err)
+	}
+
+	return tkn, nil
+}
+
+// login responds to a successful sign in. Users with multi-factor
+// authentication only get a token to enter the second factor with.
+func (h Handlers) login(ctx context.Context, w http.ResponseWriter, claims auth.Claims, now time.Time) error {
+	if claims.MFAPending {
+		tkn := tokenResponse{
+			MFARequired: true,
+		}
+
+		var err error
+		tkn.Token, err = h.Auth.GenerateToken(claims)
+		if err != nil {
+			return fmt.Errorf("generating token: %w", err)
+		}
+
+		return web.Respond(ctx, w, tkn, http.StatusOK)
+	}
+
+	tkn, err := h.issue(ctx, claims, now)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// throttled tells the client when to retry after too many failed attempts.
+func throttled(w http.ResponseWriter, retry time.Time, now time.Time, err error) error {
+	secs := int(math.Ceil(retry.Sub(now).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	return problem.NewRequestError(err, http.StatusTooManyRequests)
+}
@@ -9,12 +9,22 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/Fiiii/WT/app/services/wt-api/handlers"
+	"github.com/Fiiii/WT/business/core/apikey"
+	"github.com/Fiiii/WT/business/core/lockout"
+	"github.com/Fiiii/WT/business/core/session"
 	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/password"
+	"github.com/Fiiii/WT/business/sys/ratelimit"
+	"github.com/Fiiii/WT/business/sys/validate"
 	"github.com/Fiiii/WT/foundation/keystore"
 	"github.com/Fiiii/WT/foundation/logger"
+	"github.com/Fiiii/WT/foundation/mailer"
+	"github.com/Fiiii/WT/foundation/oidc"
+	"github.com/Fiiii/WT/foundation/trace"
 	"github.com/ardanlabs/conf/v2"
 	"go.uber.org/automaxprocs/maxprocs"
 	"go.uber.org/zap"
@@ -64,8 +74,52 @@ func run(log *zap.SugaredLogger) error {
 			ShutdownTimeout time.Duration `conf:"default:20s,mask"`
 		}
 		Auth struct {
-			KeysFolder string `conf:"default:zarf/keys/"`
-			ActiveKID  string `conf:"default:54bb2165-71e1-41a6-af3e-7da4a0e1e2c1"`
+			KeysFolder     string        `conf:"default:zarf/keys/"`
+			ActiveKID      string        `conf:"default:54bb2165-71e1-41a6-af3e-7da4a0e1e2c1"`
+			JWKSURL        string        `conf:"help:verify tokens with the keys of this JWKS document instead of local keys"`
+			JWKSMinRefetch time.Duration `conf:"default:1m"`
+			ActiveKIDFile  string        `conf:"help:file holding the active KID, re-read on every key reload"`
+			ReloadInterval time.Duration `conf:"default:30s,help:how often the keys or the JWKS document are loaded again"`
+			KeyGracePeriod time.Duration `conf:"default:1h"`
+		}
+		Tracing struct {
+			Exporter string `conf:"default:none,help:where finished spans go: none, stdout or file"`
+			File     string `conf:"default:traces.jsonl,help:OTLP JSON lines file for the file exporter"`
+			Service  string `conf:"default:wt-api"`
+		}
+		OIDC struct {
+			Issuer       string `conf:"help:enables signing in through this OpenID Connect provider"`
+			ClientID     string
+			ClientSecret string `conf:"mask"`
+			RedirectURL  string `conf:"default:http://localhost:3000/v1/auth/oidc/callback"`
+		}
+		Password struct {
+			MinLength      int    `conf:"default:8"`
+			MaxLength      int    `conf:"default:72"`
+			MinClasses     int    `conf:"default:2,help:how many of lower case; upper case; digits and symbols are required"`
+			Algorithm      string `conf:"default:bcrypt,help:bcrypt or argon2id; hashes in another algorithm are upgraded on login"`
+			BcryptCost     int    `conf:"default:10,help:hashes with a lower cost are upgraded on login"`
+			Argon2idPasses int    `conf:"default:3,help:hashes with fewer passes are upgraded on login"`
+		}
+		Lockout struct {
+			MaxFailures   int           `conf:"default:5"`
+			IPMaxFailures int           `conf:"default:50"`
+			LockDuration  time.Duration `conf:"default:15m"`
+			BaseDelay     time.Duration `conf:"default:1s"`
+			MaxDelay      time.Duration `conf:"default:30s"`
+			Window        time.Duration `conf:"default:15m"`
+		}
+		RateLimit struct {
+			Requests int           `conf:"default:100,help:requests per period and client address on every route, 0 disables limiting"`
+			Period   time.Duration `conf:"default:1m"`
+			Burst    int
+		}
+		SMTP struct {
+			Host     string `conf:"help:delivers mail through this relay, mail is dropped when empty"`
+			Port     int    `conf:"default:587"`
+			Username string
+			Password string `conf:"mask"`
+			From     string `conf:"default:WT <noreply@localhost>"`
 		}
 		DB struct {
 			User         string `conf:"default:postgres"`
@@ -113,21 +167,80 @@ func run(log *zap.SugaredLogger) error {
 	log.Infow("startup", "config", strConf)
 	expvar.NewString("build").Set(build)
 
+	// =========================================================================
+	// Initialize password support
+
+	err = validate.SetPasswordPolicy(validate.PasswordPolicy{
+		MinLength:  cfg.Password.MinLength,
+		MaxLength:  cfg.Password.MaxLength,
+		MinClasses: cfg.Password.MinClasses,
+	})
+	if err != nil {
+		return fmt.Errorf("setting password policy: %w", err)
+	}
+
+	target := password.Target{Algorithm: cfg.Password.Algorithm, Cost: cfg.Password.BcryptCost}
+	if target.Algorithm == password.AlgArgon2id {
+		target.Cost = cfg.Password.Argon2idPasses
+	}
+	if err := password.SetTarget(target); err != nil {
+		return fmt.Errorf("setting password hash target: %w", err)
+	}
+
 	// =========================================================================
 	// Initialize authentication support
 
 	log.Infow("startup", "status", "initializing authentication support")
 
-	// Construct a key store based on the key files stored in
-	// the specified directory.
-	ks, err := keystore.NewFS(os.DirFS(cfg.Auth.KeysFolder))
-	if err != nil {
-		return fmt.Errorf("reading keys: %w", err)
-	}
+	// Construct a key store based on the key files stored in the specified
+	// directory. A verifier only deployment uses the JWKS document of the
+	// issuing service instead and never needs the private keys.
+	var ks *keystore.KeyStore
+	var authn *auth.Auth
+	switch cfg.Auth.JWKSURL {
+	case "":
+		ks, err = keystore.NewFS(os.DirFS(cfg.Auth.KeysFolder))
+		if err != nil {
+			return fmt.Errorf("reading keys: %w", err)
+		}
 
-	auth, err := auth.New(cfg.Auth.ActiveKID, ks)
-	if err != nil {
-		return fmt.Errorf("constructing auth: %w", err)
+		authn, err = auth.New(cfg.Auth.ActiveKID, ks)
+		if err != nil {
+			return fmt.Errorf("constructing auth: %w", err)
+		}
+
+		// Pick up rotated keys without a restart. Removed keys keep verifying
+		// tokens for the grace period, which should outlive the access tokens
+		// they signed.
+		keysCtx, cancelKeys := context.WithCancel(context.Background())
+		defer cancelKeys()
+
+		go ks.Watch(keysCtx, os.DirFS(cfg.Auth.KeysFolder), cfg.Auth.ReloadInterval, cfg.Auth.KeyGracePeriod, func(err error) {
+			if err != nil {
+				log.Errorw("keystore", "status", "reloading keys", "ERROR", err)
+				return
+			}
+			switchActiveKID(log, authn, cfg.Auth.ActiveKIDFile)
+		})
+
+	default:
+		remote := keystore.NewRemote(cfg.Auth.JWKSURL, nil, cfg.Auth.JWKSMinRefetch)
+
+		authn, err = auth.NewVerifier(remote)
+		if err != nil {
+			return fmt.Errorf("constructing auth: %w", err)
+		}
+
+		// Fetch the keys again now and then, otherwise keys removed by the
+		// issuing service would be trusted for as long as this one runs.
+		keysCtx, cancelKeys := context.WithCancel(context.Background())
+		defer cancelKeys()
+
+		go remote.Watch(keysCtx, cfg.Auth.ReloadInterval, func(err error) {
+			if err != nil {
+				log.Errorw("keystore", "status", "fetching jwks", "ERROR", err)
+			}
+		})
 	}
 
 	// =========================================================================
@@ -168,6 +281,84 @@ func run(log *zap.SugaredLogger) error {
 		db.Close()
 	}()
 
+	// Consult the revoked tokens of logged out sessions when validating tokens.
+	authn.SetRevocationList(session.NewCore(log, db))
+
+	// Let machine clients authenticate with the API keys of their owners.
+	authn.SetAPIKeyValidator(apikey.NewCore(log, db))
+
+	// =========================================================================
+	// Initialize tracing support
+
+	log.Infow("startup", "status", "initializing tracing support", "exporter", cfg.Tracing.Exporter)
+
+	var exporter trace.Exporter
+	switch cfg.Tracing.Exporter {
+	case "none":
+	case "stdout":
+		exporter = trace.NewOTLPWriter(os.Stdout, cfg.Tracing.Service)
+	case "file":
+		f, err := os.OpenFile(cfg.Tracing.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("opening trace file: %w", err)
+		}
+		defer f.Close()
+		exporter = trace.NewOTLPWriter(f, cfg.Tracing.Service)
+	default:
+		return fmt.Errorf("unknown trace exporter %q", cfg.Tracing.Exporter)
+	}
+
+	tracer := trace.New(exporter, func(err error) {
+		log.Errorw("tracing", "status", "exporting spans", "ERROR", err)
+	})
+
+	// =========================================================================
+	// Initialize OIDC support
+
+	var provider *oidc.Provider
+	if cfg.OIDC.Issuer != "" {
+		log.Infow("startup", "status", "initializing oidc support", "issuer", cfg.OIDC.Issuer)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		provider, err = oidc.NewProvider(ctx, oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Client: &http.Client{
+				Transport: trace.Transport{},
+				Timeout:   5 * time.Second,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("constructing oidc provider: %w", err)
+		}
+	}
+
+	// =========================================================================
+	// Initialize mail support
+
+	var mail mailer.Mailer = mailer.Discard{}
+	switch cfg.SMTP.Host {
+	case "":
+		log.Infow("startup", "status", "mail delivery disabled, no smtp host configured")
+	default:
+		log.Infow("startup", "status", "initializing mail support", "host", cfg.SMTP.Host)
+
+		mail, err = mailer.NewSMTP(mailer.SMTPConfig{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+		})
+		if err != nil {
+			return fmt.Errorf("constructing smtp mailer: %w", err)
+		}
+	}
+
 	// =========================================================================
 	// Start Debug Service
 
@@ -199,11 +390,35 @@ func run(log *zap.SugaredLogger) error {
 	// Signal to relay incoming signals
 	//signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 
+	// Rate limit buckets are kept in memory, so every replica limits on its own.
+	var limiter ratelimit.Store
+	if cfg.RateLimit.Requests > 0 {
+		limiter = ratelimit.NewMemory()
+	}
+
 	apiMuxConf := handlers.APIMuxConfig{
 		Shutdown: shutdown,
 		Log:      log,
 		DB:       db,
-		Auth:     auth,
+		Auth:     authn,
+		Keys:     ks,
+		OIDC:     provider,
+		Tracer:   tracer,
+		Mailer:   mail,
+		Lockout: lockout.Config{
+			MaxFailures:   cfg.Lockout.MaxFailures,
+			IPMaxFailures: cfg.Lockout.IPMaxFailures,
+			LockDuration:  cfg.Lockout.LockDuration,
+			BaseDelay:     cfg.Lockout.BaseDelay,
+			MaxDelay:      cfg.Lockout.MaxDelay,
+			Window:        cfg.Lockout.Window,
+		},
+		RateLimiter: limiter,
+		RateLimit: ratelimit.Limit{
+			Requests: cfg.RateLimit.Requests,
+			Period:   cfg.RateLimit.Period,
+			Burst:    cfg.RateLimit.Burst,
+		},
 	}
 
 	apiMux := handlers.APIMux(apiMuxConf)
@@ -247,3 +462,29 @@ func run(log *zap.SugaredLogger) error {
 
 	return nil
 }
+
+// switchActiveKID reads the active KID from the file, if configured, and makes
+// it the signing key when it changed.
+func switchActiveKID(log *zap.SugaredLogger, authn *auth.Auth, fileName string) {
+	if fileName == "" {
+		return
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		log.Errorw("keystore", "status", "reading active kid", "ERROR", err)
+		return
+	}
+
+	kid := strings.TrimSpace(string(data))
+	if kid == "" || kid == authn.ActiveKID() {
+		return
+	}
+
+	if err := authn.SetActiveKID(kid); err != nil {
+		log.Errorw("keystore", "status", "switching active kid", "kid", kid, "ERROR", err)
+		return
+	}
+
+	log.Infow("keystore", "status", "active kid switched", "kid", kid)
+}
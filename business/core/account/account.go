@@ -0,0 +1,102 @@
+// Package account provides business operations spanning the user and product
+// cores. Each operation is a unit of work: the cores run against the same
+// transaction, so either all of their changes are committed or none are.
+package account
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/product"
+	"github.com/Fiiii/WT/business/core/user"
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/foundation/mailer"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Core manages the set of APIs for account access.
+type Core struct {
+	log      *zap.SugaredLogger
+	db       *sqlx.DB
+	users    user.Core
+	products product.Core
+}
+
+// NewCore constructs a core for account api access.
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB, mail mailer.Mailer) Core {
+	return Core{
+		log:      log,
+		db:       sqlxDB,
+		users:    user.NewCore(log, sqlxDB, mail),
+		products: product.NewCore(log, sqlxDB),
+	}
+}
+
+// WithinTran runs passed function with the user and product cores bound to
+// one transaction, and does commit/rollback at the end. Transactions the
+// cores start themselves run within savepoints of it.
+func (c Core) WithinTran(ctx context.Context, fn func(users user.Core, products product.Core) error, opts ...database.TranOption) error {
+	tran := func(tx sqlx.ExtContext) error {
+		return fn(c.users.Tran(tx), c.products.Tran(tx))
+	}
+	return database.WithinTran(ctx, c.log, c.db, tran, opts...)
+}
+
+// Create adds a new user together with their first product. Neither is
+// added when the other fails.
+func (c Core) Create(ctx context.Context, na NewAccount, now time.Time) (Account, error) {
+	var acc Account
+	tran := func(users user.Core, products product.Core) error {
+		usr, err := users.Create(ctx, na.User, now)
+		if err != nil {
+			return fmt.Errorf("create user: %w", err)
+		}
+
+		np := product.NewProduct{
+			Name:     na.Product.Name,
+			Cost:     na.Product.Cost,
+			Quantity: na.Product.Quantity,
+			UserID:   usr.ID,
+		}
+		prd, err := products.Create(ctx, np, now)
+		if err != nil {
+			return fmt.Errorf("create product: %w", err)
+		}
+
+		acc = Account{
+			User:    usr,
+			Product: prd,
+		}
+		return nil
+	}
+
+	if err := c.WithinTran(ctx, tran); err != nil {
+		return Account{}, fmt.Errorf("tran: %w", err)
+	}
+
+	return acc, nil
+}
+
+// Delete removes a user after moving their products into the archive. The
+// user is kept when the products cannot be archived.
+func (c Core) Delete(ctx context.Context, userID string, now time.Time) error {
+	tran := func(users user.Core, products product.Core) error {
+		if err := products.ArchiveByUserID(ctx, userID, now); err != nil {
+			return fmt.Errorf("archive products: %w", err)
+		}
+
+		if err := users.Delete(ctx, userID); err != nil {
+			return fmt.Errorf("delete user: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	return nil
+}
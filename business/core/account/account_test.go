@@ -0,0 +1,189 @@
+package account_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/account"
+	"github.com/Fiiii/WT/business/core/product"
+	"github.com/Fiiii/WT/business/core/user"
+	"github.com/Fiiii/WT/business/data/dbtest"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/foundation/docker"
+	"github.com/Fiiii/WT/foundation/mailer"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+func TestAccount(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testaccount")
+	t.Cleanup(teardown)
+
+	core := account.NewCore(log, db, mailer.NewMemory())
+	users := user.NewCore(log, db, mailer.NewMemory())
+	products := product.NewCore(log, db)
+
+	t.Log("Given the need to open accounts as one unit of work.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen opening an account.", testID)
+		{
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "5cf37266-3473-4006-984f-9325122678b7"},
+				Roles:            []string{auth.RoleAdmin},
+			})
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			na := account.NewAccount{
+				User: user.NewUser{
+					Name:            "Seller",
+					Email:           "seller@example.com",
+					Roles:           []string{auth.RoleUser},
+					Password:        "Gophers#2021",
+					PasswordConfirm: "Gophers#2021",
+				},
+				Product: account.NewProduct{
+					Name:     "Comic Books",
+					Cost:     50,
+					Quantity: 42,
+				},
+			}
+
+			acc, err := core.Create(ctx, na, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to open an account : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to open an account.", dbtest.Success, testID)
+
+			prds, err := products.QueryByUserID(ctx, acc.User.ID)
+			if err != nil || len(prds) != 1 || prds[0].ID != acc.Product.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould find the product of the new user : %+v %v.", dbtest.Failed, testID, prds, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould find the product of the new user.", dbtest.Success, testID)
+
+			na.User.Email = "other@example.com"
+			na.Product.Quantity = 0
+			if _, err := core.Create(ctx, na, now); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to open an account with an invalid product.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to open an account with an invalid product.", dbtest.Success, testID)
+
+			if _, err := users.QueryByEmail(ctx, na.User.Email); !errors.Is(err, user.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT keep the user of the failed account : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT keep the user of the failed account.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen an operation of a core fails within the unit of work.", testID)
+		{
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "5cf37266-3473-4006-984f-9325122678b7"},
+				Roles:            []string{auth.RoleAdmin},
+			})
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			nu := user.NewUser{
+				Name:            "Buyer",
+				Email:           "buyer@example.com",
+				Roles:           []string{auth.RoleUser},
+				Password:        "Gophers#2021",
+				PasswordConfirm: "Gophers#2021",
+			}
+
+			fn := func(users user.Core, products product.Core) error {
+				if _, err := users.Create(ctx, nu, now); err != nil {
+					return err
+				}
+
+				// The second user fails on the unique email within its own
+				// savepoint, so the transaction can go on.
+				if _, err := users.Create(ctx, nu, now); !errors.Is(err, user.ErrEmailTaken) {
+					return fmt.Errorf("should not create a user with the same email: %v", err)
+				}
+				return nil
+			}
+
+			if err := core.WithinTran(ctx, fn); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to handle the error and commit : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to handle the error and commit.", dbtest.Success, testID)
+
+			if _, err := users.QueryByEmail(ctx, nu.Email); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the first user : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the first user.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen deleting an account.", testID)
+		{
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "5cf37266-3473-4006-984f-9325122678b7"},
+				Roles:            []string{auth.RoleAdmin},
+			})
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			na := account.NewAccount{
+				User: user.NewUser{
+					Name:            "Leaver",
+					Email:           "leaver@example.com",
+					Roles:           []string{auth.RoleUser},
+					Password:        "Gophers#2021",
+					PasswordConfirm: "Gophers#2021",
+				},
+				Product: account.NewProduct{
+					Name:     "Records",
+					Cost:     25,
+					Quantity: 10,
+				},
+			}
+
+			acc, err := core.Create(ctx, na, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to open an account : %s.", dbtest.Failed, testID, err)
+			}
+
+			np := product.NewPurchase{UserID: "5cf37266-3473-4006-984f-9325122678b7", Quantity: 2}
+			if _, err := products.Purchase(ctx, acc.Product.ID, np, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to buy the product : %s.", dbtest.Failed, testID, err)
+			}
+
+			if err := core.Delete(ctx, acc.User.ID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete the account : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete the account.", dbtest.Success, testID)
+
+			if _, err := users.QueryByID(ctx, acc.User.ID); !errors.Is(err, user.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT find the deleted user : %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := products.QueryByID(ctx, acc.Product.ID); !errors.Is(err, product.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT find the product of the deleted user : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould remove the user and their product.", dbtest.Success, testID)
+
+			var sold, revenue int
+			const q = `SELECT sold, revenue FROM archived_products WHERE product_id = $1`
+			if err := db.QueryRowContext(ctx, q, acc.Product.ID).Scan(&sold, &revenue); err != nil || sold != 2 || revenue != 50 {
+				t.Fatalf("\t%s\tTest %d:\tShould archive the product with its sales : sold[%d] revenue[%d] %v.", dbtest.Failed, testID, sold, revenue, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould archive the product with its sales.", dbtest.Success, testID)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package account
+
+import (
+	"github.com/Fiiii/WT/business/core/product"
+	"github.com/Fiiii/WT/business/core/user"
+)
+
+// Account represents a user together with their first product.
+type Account struct {
+	User    user.User       `json:"user"`
+	Product product.Product `json:"product"`
+}
+
+// NewAccount is what we require from clients when opening an Account.
+type NewAccount struct {
+	User    user.NewUser `json:"user"`
+	Product NewProduct   `json:"product"`
+}
+
+// NewProduct is what we require from clients about the first product. It is
+// owned by the new user, so there is no user ID to provide.
+type NewProduct struct {
+	Name     string `json:"name"`
+	Cost     int    `json:"cost"`
+	Quantity int    `json:"quantity"`
+}
@@ -0,0 +1,236 @@
+// Package apikey provides a core business API for long-lived API keys used
+// by machine-to-machine clients. A key acts on behalf of the user owning it,
+// limited to the permissions in its scopes.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/apikey/db"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/validate"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Set of error variables for API key operations.
+var (
+	ErrNotFound      = errors.New("api key not found")
+	ErrOwnerNotFound = errors.New("owner of the api key not found")
+	ErrInvalidID     = errors.New("ID is not in its proper form")
+	ErrInvalidKey    = errors.New("api key is not valid")
+	ErrInvalidScope  = errors.New("scope is not a permission of the owner")
+)
+
+// keyPrefix marks the keys issued by the service, which makes them easy to
+// spot for secret scanners.
+const keyPrefix = "wt_"
+
+// Core manages the set of APIs for API key access.
+type Core struct {
+	store db.Store
+}
+
+// NewCore constructs a core for API key api access.
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB) Core {
+	return Core{
+		store: db.NewStore(log, sqlxDB),
+	}
+}
+
+// Create generates a new API key for a user. The returned key value is never
+// stored and cannot be recovered later.
+func (c Core) Create(ctx context.Context, nak NewAPIKey, now time.Time) (Created, error) {
+	if err := validate.Check(nak); err != nil {
+		return Created{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := validate.CheckID(nak.UserID); err != nil {
+		return Created{}, ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermAPIKeysWrite, nak.UserID); err != nil {
+		return Created{}, err
+	}
+
+	if err := c.checkScopes(ctx, nak.UserID, nak.Scopes); err != nil {
+		return Created{}, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return Created{}, fmt.Errorf("generating key: %w", err)
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	dbKey := db.APIKey{
+		ID:          validate.GenerateID(),
+		UserID:      nak.UserID,
+		Name:        nak.Name,
+		Prefix:      key[:len(keyPrefix)+8],
+		KeyHash:     hash(key),
+		Scopes:      nak.Scopes,
+		DateCreated: now,
+	}
+
+	if err := c.store.Create(ctx, dbKey); err != nil {
+		return Created{}, fmt.Errorf("create: %w", err)
+	}
+
+	created := Created{
+		APIKey: toAPIKey(dbKey),
+		Key:    key,
+	}
+
+	return created, nil
+}
+
+// UpdateScopes replaces the scopes of the API key identified by a given ID.
+func (c Core) UpdateScopes(ctx context.Context, keyID string, us UpdateScopes) error {
+	if err := validate.CheckID(keyID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := validate.Check(us); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	dbKey, err := c.queryOwned(ctx, keyID)
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkScopes(ctx, dbKey.UserID, us.Scopes); err != nil {
+		return err
+	}
+
+	if err := c.store.UpdateScopes(ctx, dbKey.ID, us.Scopes); err != nil {
+		return fmt.Errorf("update scopes: %w", err)
+	}
+
+	return nil
+}
+
+// Revoke revokes the API key identified by a given ID. Revoking a key which
+// is already revoked is a no-op.
+func (c Core) Revoke(ctx context.Context, keyID string, now time.Time) error {
+	if err := validate.CheckID(keyID); err != nil {
+		return ErrInvalidID
+	}
+
+	dbKey, err := c.queryOwned(ctx, keyID)
+	if err != nil {
+		return err
+	}
+
+	if dbKey.DateRevoked != nil {
+		return nil
+	}
+
+	if err := c.store.Revoke(ctx, dbKey.ID, now); err != nil {
+		return fmt.Errorf("revoke: %w", err)
+	}
+
+	return nil
+}
+
+// QueryByUserID finds the API keys owned by the user identified by a given ID.
+func (c Core) QueryByUserID(ctx context.Context, userID string) ([]APIKey, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermAPIKeysWrite, userID); err != nil {
+		return nil, err
+	}
+
+	dbKeys, err := c.store.QueryByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	return toAPIKeySlice(dbKeys), nil
+}
+
+// ValidateAPIKey recreates the claims of the user owning an API key, limited
+// to the scopes of the key. It implements the auth.APIKeyValidator interface.
+func (c Core) ValidateAPIKey(ctx context.Context, key string) (auth.Claims, error) {
+	owner, err := c.store.QueryOwnerByHash(ctx, hash(key))
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return auth.Claims{}, ErrInvalidKey
+		}
+		return auth.Claims{}, fmt.Errorf("query: %w", err)
+	}
+
+	if owner.DateRevoked != nil {
+		return auth.Claims{}, ErrInvalidKey
+	}
+
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: owner.UserID,
+			Issuer:  "service project",
+		},
+		Roles:  owner.Roles,
+		Scopes: owner.Scopes,
+	}
+
+	return claims, nil
+}
+
+// =============================================================================
+
+// queryOwned finds the API key identified by a given ID and checks the caller
+// may manage it.
+func (c Core) queryOwned(ctx context.Context, keyID string) (db.APIKey, error) {
+	dbKey, err := c.store.QueryByID(ctx, keyID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return db.APIKey{}, ErrNotFound
+		}
+		return db.APIKey{}, fmt.Errorf("query: %w", err)
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermAPIKeysWrite, dbKey.UserID); err != nil {
+		return db.APIKey{}, err
+	}
+
+	return dbKey, nil
+}
+
+// checkScopes verifies every scope names a permission granted by the roles
+// of the owner, so the scopes of a key never claim more than it can do.
+func (c Core) checkScopes(ctx context.Context, ownerID string, scopes []string) error {
+	roles, err := c.store.QueryRoles(ctx, ownerID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return ErrOwnerNotFound
+		}
+		return fmt.Errorf("query roles: %w", err)
+	}
+
+	granted := auth.Permissions(roles...)
+	for _, scope := range scopes {
+		if _, ok := granted[scope]; !ok {
+			return fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	return nil
+}
+
+// hash returns the hex encoded SHA-256 of a key. API keys carry enough
+// entropy that a fast hash is sufficient.
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
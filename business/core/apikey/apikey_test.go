@@ -0,0 +1,123 @@
+package apikey_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/apikey"
+	"github.com/Fiiii/WT/business/data/dbtest"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/foundation/docker"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+func TestAPIKey(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testapikey")
+	t.Cleanup(teardown)
+
+	core := apikey.NewCore(log, db)
+
+	t.Log("Given the need to work with API keys.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling a single API key.", testID)
+		{
+			const userID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: userID},
+				Roles:            []string{auth.RoleUser},
+			})
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			nak := apikey.NewAPIKey{
+				UserID: userID,
+				Name:   "batch",
+				Scopes: []string{"reports:read"},
+			}
+
+			if _, err := core.Create(ctx, nak, now); !errors.Is(err, apikey.ErrInvalidScope) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to create a key with an unknown scope : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to create a key with an unknown scope.", dbtest.Success, testID)
+
+			nak.Scopes = []string{auth.PermSalesVoid}
+			if _, err := core.Create(ctx, nak, now); !errors.Is(err, apikey.ErrInvalidScope) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to create a key with a scope the owner lacks : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to create a key with a scope the owner lacks.", dbtest.Success, testID)
+
+			nak.Scopes = []string{auth.PermProductsRead}
+			key, err := core.Create(ctx, nak, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an API key : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create an API key.", dbtest.Success, testID)
+
+			claims, err := core.ValidateAPIKey(context.Background(), key.Key)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to validate the API key : %s.", dbtest.Failed, testID, err)
+			}
+			if claims.Subject != userID || !claims.HasAllPermissions(auth.PermProductsRead) {
+				t.Fatalf("\t%s\tTest %d:\tShould get the claims of the owner : %+v.", dbtest.Failed, testID, claims)
+			}
+			if claims.HasAnyPermission(auth.PermProductsWrite) {
+				t.Fatalf("\t%s\tTest %d:\tShould be limited to the scopes of the key : %+v.", dbtest.Failed, testID, claims)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the claims of the owner limited to the scopes.", dbtest.Success, testID)
+
+			us := apikey.UpdateScopes{
+				Scopes: []string{auth.PermProductsRead, auth.PermProductsWrite},
+			}
+			if err := core.UpdateScopes(ctx, key.ID, us); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update the scopes : %s.", dbtest.Failed, testID, err)
+			}
+
+			claims, err = core.ValidateAPIKey(context.Background(), key.Key)
+			if err != nil || !claims.HasAllPermissions(auth.PermProductsWrite) {
+				t.Fatalf("\t%s\tTest %d:\tShould see the new scopes : %+v %v.", dbtest.Failed, testID, claims, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to update the scopes.", dbtest.Success, testID)
+
+			keys, err := core.QueryByUserID(ctx, userID)
+			if err != nil || len(keys) != 1 || keys[0].Prefix != key.Prefix {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to list the keys of the owner : %+v %v.", dbtest.Failed, testID, keys, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to list the keys of the owner.", dbtest.Success, testID)
+
+			other := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "5cf37266-3473-4006-984f-9325122678b7"},
+				Roles:            []string{auth.RoleUser},
+			})
+			if err := core.Revoke(other, key.ID, now); !errors.Is(err, auth.ErrForbidden) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to revoke the key of another user : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to revoke the key of another user.", dbtest.Success, testID)
+
+			if err := core.Revoke(ctx, key.ID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to revoke the API key : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.ValidateAPIKey(context.Background(), key.Key); !errors.Is(err, apikey.ErrInvalidKey) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to validate a revoked key : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to revoke the API key.", dbtest.Success, testID)
+		}
+	}
+}
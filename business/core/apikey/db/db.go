@@ -0,0 +1,220 @@
+// Package db contains API key related CRUD functionality.
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for API key access.
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+// NewStore constructs a data for api access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs passed function and do commit/rollback at the end. When
+// the store is already within a transaction, fn runs within a savepoint of
+// it instead and the options are ignored.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error, opts ...database.TranOption) error {
+	if s.isWithinTran {
+		return database.WithinSavepoint(ctx, s.log, s.db, fn)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn, opts...)
+}
+
+// Tran return new Store with transaction in it.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// Create adds an APIKey to the database.
+func (s Store) Create(ctx context.Context, key APIKey) error {
+	const q = `
+	INSERT INTO api_keys
+		(key_id, user_id, name, prefix, key_hash, scopes, date_created)
+	VALUES
+		(:key_id, :user_id, :name, :prefix, :key_hash, :scopes, :date_created)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, key); err != nil {
+		return fmt.Errorf("inserting api key: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateScopes replaces the scopes of the API key identified by a given ID.
+func (s Store) UpdateScopes(ctx context.Context, keyID string, scopes []string) error {
+	data := struct {
+		KeyID  string         `db:"key_id"`
+		Scopes pq.StringArray `db:"scopes"`
+	}{
+		KeyID:  keyID,
+		Scopes: scopes,
+	}
+
+	const q = `
+	UPDATE
+		api_keys
+	SET
+		"scopes" = :scopes
+	WHERE
+		key_id = :key_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("updating api key keyID[%s]: %w", keyID, err)
+	}
+
+	return nil
+}
+
+// Revoke marks the API key identified by a given ID as revoked.
+func (s Store) Revoke(ctx context.Context, keyID string, now time.Time) error {
+	data := struct {
+		KeyID       string    `db:"key_id"`
+		DateRevoked time.Time `db:"date_revoked"`
+	}{
+		KeyID:       keyID,
+		DateRevoked: now,
+	}
+
+	const q = `
+	UPDATE
+		api_keys
+	SET
+		"date_revoked" = :date_revoked
+	WHERE
+		key_id = :key_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("revoking api key keyID[%s]: %w", keyID, err)
+	}
+
+	return nil
+}
+
+// QueryByID finds the API key identified by a given ID.
+func (s Store) QueryByID(ctx context.Context, keyID string) (APIKey, error) {
+	data := struct {
+		KeyID string `db:"key_id"`
+	}{
+		KeyID: keyID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		api_keys
+	WHERE
+		key_id = :key_id`
+
+	var key APIKey
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &key); err != nil {
+		return APIKey{}, fmt.Errorf("selecting api key keyID[%q]: %w", keyID, err)
+	}
+
+	return key, nil
+}
+
+// QueryByUserID finds the API keys owned by the user identified by a given ID.
+func (s Store) QueryByUserID(ctx context.Context, userID string) ([]APIKey, error) {
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		api_keys
+	WHERE
+		user_id = :user_id
+	ORDER BY
+		date_created`
+
+	var keys []APIKey
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &keys); err != nil {
+		return nil, fmt.Errorf("selecting api keys userID[%s]: %w", userID, err)
+	}
+
+	return keys, nil
+}
+
+// QueryOwnerByHash finds the API key with the given hash along with the
+// roles of the user owning it.
+func (s Store) QueryOwnerByHash(ctx context.Context, keyHash string) (Owner, error) {
+	data := struct {
+		KeyHash string `db:"key_hash"`
+	}{
+		KeyHash: keyHash,
+	}
+
+	const q = `
+	SELECT
+		k.*,
+		u.roles
+	FROM
+		api_keys AS k
+	JOIN
+		users AS u ON u.user_id = k.user_id
+	WHERE
+		k.key_hash = :key_hash`
+
+	var owner Owner
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &owner); err != nil {
+		return Owner{}, fmt.Errorf("selecting api key by hash: %w", err)
+	}
+
+	return owner, nil
+}
+
+// QueryRoles finds the roles of the user identified by a given ID, who owns
+// or is about to own API keys.
+func (s Store) QueryRoles(ctx context.Context, userID string) ([]string, error) {
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		roles
+	FROM
+		users
+	WHERE
+		user_id = :user_id`
+
+	var usr struct {
+		Roles pq.StringArray `db:"roles"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &usr); err != nil {
+		return nil, fmt.Errorf("selecting roles userID[%s]: %w", userID, err)
+	}
+
+	return usr.Roles, nil
+}
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey represents a persisted API key. Only the hash of the key is stored,
+// the prefix is kept to let owners tell their keys apart.
+type APIKey struct {
+	ID          string         `db:"key_id"`
+	UserID      string         `db:"user_id"`
+	Name        string         `db:"name"`
+	Prefix      string         `db:"prefix"`
+	KeyHash     string         `db:"key_hash"`
+	Scopes      pq.StringArray `db:"scopes"`
+	DateCreated time.Time      `db:"date_created"`
+	DateRevoked *time.Time     `db:"date_revoked"`
+}
+
+// Owner represents an API key together with the roles of the user owning it.
+type Owner struct {
+	APIKey
+	Roles pq.StringArray `db:"roles"`
+}
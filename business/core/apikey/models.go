@@ -0,0 +1,60 @@
+package apikey
+
+import (
+	"time"
+
+	"github.com/Fiiii/WT/business/core/apikey/db"
+)
+
+// APIKey represents an individual API key. The key itself is only known at
+// creation time, the prefix identifies it afterwards.
+type APIKey struct {
+	ID          string     `json:"id"`                     // Unique identifier.
+	UserID      string     `json:"user_id"`                // ID of the user owning the key.
+	Name        string     `json:"name"`                   // Display name given by the owner.
+	Prefix      string     `json:"prefix"`                 // First characters of the key.
+	Scopes      []string   `json:"scopes"`                 // Permissions the key is limited to.
+	DateCreated time.Time  `json:"date_created"`           // When the key was created.
+	DateRevoked *time.Time `json:"date_revoked,omitempty"` // When the key was revoked.
+}
+
+// Created represents a freshly created API key along with its value.
+type Created struct {
+	APIKey
+	Key string `json:"key"` // Opaque key value handed to the client.
+}
+
+// NewAPIKey is what we require from clients when creating an API key.
+type NewAPIKey struct {
+	UserID string   `json:"user_id" validate:"required"`
+	Name   string   `json:"name" validate:"required"`
+	Scopes []string `json:"scopes" validate:"required,min=1"`
+}
+
+// UpdateScopes defines what information may be provided to change the
+// scopes of an API key.
+type UpdateScopes struct {
+	Scopes []string `json:"scopes" validate:"required,min=1"`
+}
+
+// =============================================================================
+
+func toAPIKey(dbKey db.APIKey) APIKey {
+	return APIKey{
+		ID:          dbKey.ID,
+		UserID:      dbKey.UserID,
+		Name:        dbKey.Name,
+		Prefix:      dbKey.Prefix,
+		Scopes:      dbKey.Scopes,
+		DateCreated: dbKey.DateCreated,
+		DateRevoked: dbKey.DateRevoked,
+	}
+}
+
+func toAPIKeySlice(dbKeys []db.APIKey) []APIKey {
+	keys := make([]APIKey, len(dbKeys))
+	for i, dbKey := range dbKeys {
+		keys[i] = toAPIKey(dbKey)
+	}
+	return keys
+}
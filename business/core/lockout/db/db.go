@@ -0,0 +1,173 @@
+// Package db contains failed attempt counter related CRUD functionality for
+// Postgres.
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for failed attempt access.
+type Store struct {
+	log *zap.SugaredLogger
+	db  sqlx.ExtContext
+}
+
+// NewStore constructs a data for api access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		db:  db,
+	}
+}
+
+// AddFailure counts a failed attempt against the key and returns the counter.
+// Failures from before windowStart are forgotten.
+func (s Store) AddFailure(ctx context.Context, key string, now time.Time, windowStart time.Time) (Counter, error) {
+	data := struct {
+		Key         string    `db:"counter_key"`
+		Now         time.Time `db:"date_last_failure"`
+		WindowStart time.Time `db:"window_start"`
+	}{
+		Key:         key,
+		Now:         now,
+		WindowStart: windowStart,
+	}
+
+	const q = `
+	INSERT INTO login_failures AS lf
+		(counter_key, failures, date_last_failure)
+	VALUES
+		(:counter_key, 1, :date_last_failure)
+	ON CONFLICT (counter_key) DO UPDATE SET
+		"failures" = CASE
+			WHEN lf.date_last_failure < :window_start THEN 1
+			ELSE lf.failures + 1
+		END,
+		"date_last_failure" = :date_last_failure
+	RETURNING
+		*`
+
+	var ctr Counter
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &ctr); err != nil {
+		return Counter{}, fmt.Errorf("adding failure key[%s]: %w", key, err)
+	}
+
+	return ctr, nil
+}
+
+// Lock locks the key until the specified time.
+func (s Store) Lock(ctx context.Context, key string, until time.Time) error {
+	data := struct {
+		Key   string    `db:"counter_key"`
+		Until time.Time `db:"date_locked_until"`
+	}{
+		Key:   key,
+		Until: until,
+	}
+
+	const q = `
+	UPDATE
+		login_failures
+	SET
+		"date_locked_until" = :date_locked_until
+	WHERE
+		counter_key = :counter_key`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("locking key[%s]: %w", key, err)
+	}
+
+	return nil
+}
+
+// QueryByKey gets the counter of the key from the database.
+func (s Store) QueryByKey(ctx context.Context, key string) (Counter, error) {
+	data := struct {
+		Key string `db:"counter_key"`
+	}{
+		Key: key,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		login_failures
+	WHERE
+		counter_key = :counter_key`
+
+	var ctr Counter
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &ctr); err != nil {
+		return Counter{}, fmt.Errorf("selecting key[%s]: %w", key, err)
+	}
+
+	return ctr, nil
+}
+
+// Delete removes the counter of the key from the database.
+func (s Store) Delete(ctx context.Context, key string) error {
+	data := struct {
+		Key string `db:"counter_key"`
+	}{
+		Key: key,
+	}
+
+	const q = `
+	DELETE FROM
+		login_failures
+	WHERE
+		counter_key = :counter_key`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("deleting key[%s]: %w", key, err)
+	}
+
+	return nil
+}
+
+// CreateAudit adds an audit entry to the database.
+func (s Store) CreateAudit(ctx context.Context, a Audit) error {
+	const q = `
+	INSERT INTO lockout_audit
+		(audit_id, counter_key, event, actor_id, date_locked_until, date_created)
+	VALUES
+		(:audit_id, :counter_key, :event, :actor_id, :date_locked_until, :date_created)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, a); err != nil {
+		return fmt.Errorf("inserting audit key[%s]: %w", a.Key, err)
+	}
+
+	return nil
+}
+
+// QueryAuditByKey gets the audit entries of the key, oldest first.
+func (s Store) QueryAuditByKey(ctx context.Context, key string) ([]Audit, error) {
+	data := struct {
+		Key string `db:"counter_key"`
+	}{
+		Key: key,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		lockout_audit
+	WHERE
+		counter_key = :counter_key
+	ORDER BY
+		date_created`
+
+	var audits []Audit
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &audits); err != nil {
+		return nil, fmt.Errorf("selecting audit key[%s]: %w", key, err)
+	}
+
+	return audits, nil
+}
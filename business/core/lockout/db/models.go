@@ -0,0 +1,23 @@
+package db
+
+import "time"
+
+// Counter represents the failed attempts counted against a key, such as an
+// email or an IP address.
+type Counter struct {
+	Key             string     `db:"counter_key"`
+	Failures        int        `db:"failures"`
+	DateLastFailure time.Time  `db:"date_last_failure"`
+	DateLockedUntil *time.Time `db:"date_locked_until"`
+}
+
+// Audit represents a lockout related event. ActorID is the user who caused
+// the event, empty for lockouts caused by failed attempts.
+type Audit struct {
+	ID              string     `db:"audit_id"`
+	Key             string     `db:"counter_key"`
+	Event           string     `db:"event"`
+	ActorID         string     `db:"actor_id"`
+	DateLockedUntil *time.Time `db:"date_locked_until"`
+	DateCreated     time.Time  `db:"date_created"`
+}
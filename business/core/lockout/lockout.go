@@ -0,0 +1,252 @@
+// Package lockout provides a core business API for throttling failed login
+// attempts. Failures are counted per key, such as an email or an IP address,
+// slowing down further attempts exponentially and locking the key for a while
+// once too many failed.
+package lockout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/lockout/db"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/validate"
+	"go.uber.org/zap"
+)
+
+// Set of error variables for failed attempt tracking.
+var (
+	ErrLocked    = errors.New("too many failed attempts, locked temporarily")
+	ErrThrottled = errors.New("too many failed attempts, try again later")
+)
+
+// Audit events.
+const (
+	EventLocked   = "locked"
+	EventUnlocked = "unlocked"
+)
+
+// Storer is the behavior required to keep the failed attempt counters. The
+// db package provides a Postgres implementation, the memory package an
+// in-memory one.
+type Storer interface {
+	AddFailure(ctx context.Context, key string, now time.Time, windowStart time.Time) (db.Counter, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	QueryByKey(ctx context.Context, key string) (db.Counter, error)
+	Delete(ctx context.Context, key string) error
+	CreateAudit(ctx context.Context, a db.Audit) error
+	QueryAuditByKey(ctx context.Context, key string) ([]db.Audit, error)
+}
+
+// Config represents the limits applied to failed attempts. Every failure
+// doubles the time before the next attempt, starting at BaseDelay and capped
+// at MaxDelay. A key is locked for LockDuration once it reaches its maximum
+// failures within Window. IP addresses are shared by many users, so they get
+// a higher maximum.
+type Config struct {
+	MaxFailures   int
+	IPMaxFailures int
+	LockDuration  time.Duration
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	Window        time.Duration
+}
+
+// Core manages the set of API's for failed attempt tracking.
+type Core struct {
+	log   *zap.SugaredLogger
+	store Storer
+	cfg   Config
+}
+
+// NewCore constructs a core for failed attempt tracking. Zero values in the
+// config are replaced by defaults.
+func NewCore(log *zap.SugaredLogger, store Storer, cfg Config) Core {
+	if cfg.MaxFailures == 0 {
+		cfg.MaxFailures = 5
+	}
+	if cfg.IPMaxFailures == 0 {
+		cfg.IPMaxFailures = 50
+	}
+	if cfg.LockDuration == 0 {
+		cfg.LockDuration = 15 * time.Minute
+	}
+	if cfg.BaseDelay == 0 {
+		cfg.BaseDelay = time.Second
+	}
+	if cfg.MaxDelay == 0 {
+		cfg.MaxDelay = 30 * time.Second
+	}
+	if cfg.Window == 0 {
+		cfg.Window = 15 * time.Minute
+	}
+
+	return Core{
+		log:   log,
+		store: store,
+		cfg:   cfg,
+	}
+}
+
+// EmailKey returns the key failed logins for an email are counted against.
+func EmailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPKey returns the key failed logins from an IP address are counted against.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// MFAKey returns the key failed second factors of a user are counted against.
+func MFAKey(userID string) string {
+	return "mfa:" + userID
+}
+
+// Check reports if another attempt is allowed for every key. Otherwise it
+// returns ErrLocked or ErrThrottled and when the next attempt is allowed.
+func (c Core) Check(ctx context.Context, now time.Time, keys ...string) (time.Time, error) {
+	var retry time.Time
+	var retryErr error
+
+	for _, key := range keys {
+		ctr, err := c.store.QueryByKey(ctx, key)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				continue
+			}
+			return time.Time{}, fmt.Errorf("query: %w", err)
+		}
+
+		// A lock can outlast the window, so it is checked before the
+		// failures are considered stale.
+		if ctr.DateLockedUntil != nil && now.Before(*ctr.DateLockedUntil) {
+			if ctr.DateLockedUntil.After(retry) {
+				retry, retryErr = *ctr.DateLockedUntil, ErrLocked
+			}
+			continue
+		}
+
+		if ctr.DateLastFailure.Before(now.Add(-c.cfg.Window)) {
+			continue
+		}
+
+		if next := ctr.DateLastFailure.Add(c.delay(ctr.Failures)); now.Before(next) && next.After(retry) {
+			retry, retryErr = next, ErrThrottled
+		}
+	}
+
+	return retry, retryErr
+}
+
+// Fail counts a failed attempt against every key and locks the keys which
+// reached their maximum.
+func (c Core) Fail(ctx context.Context, now time.Time, keys ...string) error {
+	for _, key := range keys {
+		ctr, err := c.store.AddFailure(ctx, key, now, now.Add(-c.cfg.Window))
+		if err != nil {
+			return fmt.Errorf("add failure: %w", err)
+		}
+
+		if ctr.Failures < c.maxFailures(key) {
+			continue
+		}
+
+		until := now.Add(c.cfg.LockDuration)
+		if err := c.store.Lock(ctx, key, until); err != nil {
+			return fmt.Errorf("lock: %w", err)
+		}
+
+		if err := c.audit(ctx, key, EventLocked, "", &until, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Succeed forgets the failed attempts counted against the keys.
+func (c Core) Succeed(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := c.store.Delete(ctx, key); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Unlock forgets the failed logins of the email and lifts its lock. Only
+// admins may unlock.
+func (c Core) Unlock(ctx context.Context, email string, now time.Time) error {
+	if err := auth.Enforce(ctx, auth.AdminOnly, auth.PermUsersWrite, ""); err != nil {
+		return err
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return auth.ErrForbidden
+	}
+
+	key := EmailKey(email)
+	if err := c.store.Delete(ctx, key); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+
+	return c.audit(ctx, key, EventUnlocked, claims.Subject, nil, now)
+}
+
+// QueryAudit returns the audit entries for the key, oldest first.
+func (c Core) QueryAudit(ctx context.Context, key string) ([]Audit, error) {
+	dbAudits, err := c.store.QueryAuditByKey(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	return toAuditSlice(dbAudits), nil
+}
+
+// =============================================================================
+
+// delay returns how long to wait after the given number of failures.
+func (c Core) delay(failures int) time.Duration {
+	d := c.cfg.BaseDelay
+	for i := 1; i < failures && d < c.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > c.cfg.MaxDelay {
+		d = c.cfg.MaxDelay
+	}
+	return d
+}
+
+// maxFailures returns how many failures lock the key.
+func (c Core) maxFailures(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return c.cfg.IPMaxFailures
+	}
+	return c.cfg.MaxFailures
+}
+
+// audit records a lockout related event in the store and the log.
+func (c Core) audit(ctx context.Context, key string, event string, actorID string, until *time.Time, now time.Time) error {
+	a := db.Audit{
+		ID:              validate.GenerateID(),
+		Key:             key,
+		Event:           event,
+		ActorID:         actorID,
+		DateLockedUntil: until,
+		DateCreated:     now,
+	}
+
+	if err := c.store.CreateAudit(ctx, a); err != nil {
+		return fmt.Errorf("create audit: %w", err)
+	}
+
+	c.log.Infow("lockout audit", "key", key, "event", event, "actor", actorID, "until", until)
+	return nil
+}
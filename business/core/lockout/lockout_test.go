@@ -0,0 +1,157 @@
+package lockout_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/lockout"
+	"github.com/Fiiii/WT/business/core/lockout/memory"
+	"github.com/Fiiii/WT/business/data/dbtest"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+func TestLockout(t *testing.T) {
+	core := lockout.NewCore(zap.NewNop().Sugar(), memory.NewStore(), lockout.Config{
+		MaxFailures:   3,
+		IPMaxFailures: 10,
+		LockDuration:  time.Minute,
+		BaseDelay:     time.Second,
+		MaxDelay:      4 * time.Second,
+		Window:        time.Hour,
+	})
+
+	t.Log("Given the need to throttle failed logins.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a single email keeps failing.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+			email, ip := lockout.EmailKey("Fii@Example.com"), lockout.IPKey("10.0.0.1")
+
+			if _, err := core.Check(ctx, now, email, ip); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould allow the first attempt : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould allow the first attempt.", dbtest.Success, testID)
+
+			if err := core.Fail(ctx, now, email, ip); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to count a failure : %s.", dbtest.Failed, testID, err)
+			}
+
+			retry, err := core.Check(ctx, now, email, ip)
+			if !errors.Is(err, lockout.ErrThrottled) || !retry.Equal(now.Add(time.Second)) {
+				t.Fatalf("\t%s\tTest %d:\tShould throttle for a second : %v %v.", dbtest.Failed, testID, retry, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould throttle for a second.", dbtest.Success, testID)
+
+			now = now.Add(time.Second)
+			if err := core.Fail(ctx, now, email, ip); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to count a failure : %s.", dbtest.Failed, testID, err)
+			}
+
+			retry, err = core.Check(ctx, now, lockout.EmailKey("fii@example.com"))
+			if !errors.Is(err, lockout.ErrThrottled) || !retry.Equal(now.Add(2*time.Second)) {
+				t.Fatalf("\t%s\tTest %d:\tShould double the delay, ignoring case : %v %v.", dbtest.Failed, testID, retry, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould double the delay, ignoring case.", dbtest.Success, testID)
+
+			now = now.Add(2 * time.Second)
+			if err := core.Fail(ctx, now, email, ip); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to count a failure : %s.", dbtest.Failed, testID, err)
+			}
+
+			retry, err = core.Check(ctx, now.Add(10*time.Second), email, ip)
+			if !errors.Is(err, lockout.ErrLocked) || !retry.Equal(now.Add(time.Minute)) {
+				t.Fatalf("\t%s\tTest %d:\tShould lock the email : %v %v.", dbtest.Failed, testID, retry, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould lock the email.", dbtest.Success, testID)
+
+			if _, err := core.Check(ctx, now.Add(10*time.Second), ip); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT lock the IP yet : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT lock the IP yet.", dbtest.Success, testID)
+
+			audits, err := core.QueryAudit(ctx, email)
+			if err != nil || len(audits) != 1 || audits[0].Event != lockout.EventLocked {
+				t.Fatalf("\t%s\tTest %d:\tShould audit the lockout : %+v %v.", dbtest.Failed, testID, audits, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould audit the lockout.", dbtest.Success, testID)
+
+			if _, err := core.Check(ctx, now.Add(time.Minute), email); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould allow attempts once the lock expires : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould allow attempts once the lock expires.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen an admin unlocks an email.", testID)
+		{
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+			email := lockout.EmailKey("gopher@example.com")
+
+			for i := 0; i < 3; i++ {
+				if err := core.Fail(context.Background(), now, email); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to count a failure : %s.", dbtest.Failed, testID, err)
+				}
+			}
+
+			userCtx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"},
+				Roles:            []string{auth.RoleUser},
+			})
+			if err := core.Unlock(userCtx, "gopher@example.com", now); !errors.Is(err, auth.ErrForbidden) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT allow users to unlock : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT allow users to unlock.", dbtest.Success, testID)
+
+			adminCtx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "5cf37266-3473-4006-984f-9325122678b7"},
+				Roles:            []string{auth.RoleAdmin},
+			})
+			if err := core.Unlock(adminCtx, "gopher@example.com", now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould allow admins to unlock : %s.", dbtest.Failed, testID, err)
+			}
+
+			if _, err := core.Check(adminCtx, now, email); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould allow attempts after the unlock : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould allow admins to unlock.", dbtest.Success, testID)
+
+			audits, err := core.QueryAudit(adminCtx, email)
+			if err != nil || len(audits) != 2 || audits[1].Event != lockout.EventUnlocked || audits[1].ActorID == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould audit the unlock with its actor : %+v %v.", dbtest.Failed, testID, audits, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould audit the unlock with its actor.", dbtest.Success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen the lock outlasts the window.", testID)
+		{
+			core := lockout.NewCore(zap.NewNop().Sugar(), memory.NewStore(), lockout.Config{
+				MaxFailures:   1,
+				IPMaxFailures: 10,
+				LockDuration:  2 * time.Hour,
+				BaseDelay:     time.Second,
+				MaxDelay:      4 * time.Second,
+				Window:        time.Hour,
+			})
+			ctx := context.Background()
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+			email := lockout.EmailKey("gopher@example.com")
+
+			if err := core.Fail(ctx, now, email); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to count a failure : %s.", dbtest.Failed, testID, err)
+			}
+
+			retry, err := core.Check(ctx, now.Add(90*time.Minute), email)
+			if !errors.Is(err, lockout.ErrLocked) || !retry.Equal(now.Add(2*time.Hour)) {
+				t.Fatalf("\t%s\tTest %d:\tShould stay locked after the window : %v %v.", dbtest.Failed, testID, retry, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould stay locked after the window.", dbtest.Success, testID)
+		}
+	}
+}
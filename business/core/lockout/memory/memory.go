@@ -0,0 +1,110 @@
+// Package memory contains an in-memory implementation of the failed attempt
+// counters, for tests and single instance deployments.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/lockout/db"
+	"github.com/Fiiii/WT/business/sys/database"
+)
+
+// Store keeps the counters and audit entries in memory.
+type Store struct {
+	mu       sync.Mutex
+	counters map[string]db.Counter
+	audits   []db.Audit
+}
+
+// NewStore constructs an empty in-memory store.
+func NewStore() *Store {
+	return &Store{
+		counters: make(map[string]db.Counter),
+	}
+}
+
+// AddFailure counts a failed attempt against the key and returns the counter.
+// Failures from before windowStart are forgotten.
+func (s *Store) AddFailure(ctx context.Context, key string, now time.Time, windowStart time.Time) (db.Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctr, exists := s.counters[key]
+	switch {
+	case !exists:
+		ctr = db.Counter{Key: key, Failures: 1}
+	case ctr.DateLastFailure.Before(windowStart):
+		ctr.Failures = 1
+	default:
+		ctr.Failures++
+	}
+	ctr.DateLastFailure = now
+
+	s.counters[key] = ctr
+	return ctr, nil
+}
+
+// Lock locks the key until the specified time.
+func (s *Store) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctr, exists := s.counters[key]
+	if !exists {
+		return nil
+	}
+
+	ctr.DateLockedUntil = &until
+	s.counters[key] = ctr
+	return nil
+}
+
+// QueryByKey gets the counter of the key. It returns database.ErrDBNotFound
+// when nothing was counted against the key.
+func (s *Store) QueryByKey(ctx context.Context, key string) (db.Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctr, exists := s.counters[key]
+	if !exists {
+		return db.Counter{}, fmt.Errorf("selecting key[%s]: %w", key, database.ErrDBNotFound)
+	}
+
+	return ctr, nil
+}
+
+// Delete removes the counter of the key.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.counters, key)
+	return nil
+}
+
+// CreateAudit adds an audit entry.
+func (s *Store) CreateAudit(ctx context.Context, a db.Audit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.audits = append(s.audits, a)
+	return nil
+}
+
+// QueryAuditByKey gets the audit entries of the key, oldest first.
+func (s *Store) QueryAuditByKey(ctx context.Context, key string) ([]db.Audit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var audits []db.Audit
+	for _, a := range s.audits {
+		if a.Key == key {
+			audits = append(audits, a)
+		}
+	}
+
+	return audits, nil
+}
@@ -0,0 +1,33 @@
+package lockout
+
+import (
+	"time"
+	"unsafe"
+
+	"github.com/Fiiii/WT/business/core/lockout/db"
+)
+
+// Audit represents a lockout related event.
+type Audit struct {
+	ID              string     `json:"id"`
+	Key             string     `json:"key"`
+	Event           string     `json:"event"`
+	ActorID         string     `json:"actor_id,omitempty"`
+	DateLockedUntil *time.Time `json:"date_locked_until,omitempty"`
+	DateCreated     time.Time  `json:"date_created"`
+}
+
+// =============================================================================
+
+func toAudit(dbAudit db.Audit) Audit {
+	pa := (*Audit)(unsafe.Pointer(&dbAudit))
+	return *pa
+}
+
+func toAuditSlice(dbAudits []db.Audit) []Audit {
+	audits := make([]Audit, len(dbAudits))
+	for i, dbAudit := range dbAudits {
+		audits[i] = toAudit(dbAudit)
+	}
+	return audits
+}
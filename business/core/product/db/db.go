@@ -4,8 +4,10 @@ package db
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/paging"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 )
@@ -27,12 +29,14 @@ func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
 	}
 }
 
-// WithinTran runs passed function and do commit/rollback at the end.
-func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+// WithinTran runs passed function and do commit/rollback at the end. When
+// the store is already within a transaction, fn runs within a savepoint of
+// it instead and the options are ignored.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error, opts ...database.TranOption) error {
 	if s.isWithinTran {
-		fn(s.db)
+		return database.WithinSavepoint(ctx, s.log, s.db, fn)
 	}
-	return database.WithinTran(ctx, s.log, s.tr, fn)
+	return database.WithinTran(ctx, s.log, s.tr, fn, opts...)
 }
 
 // Tran return new Store with transaction in it.
@@ -103,37 +107,117 @@ func (s Store) Delete(ctx context.Context, productID string) error {
 	return nil
 }
 
-// Query gets all Products from the database.
-func (s Store) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]Product, error) {
+// ArchiveByUserID copies the products of a user, with what they sold, into
+// the archive. The products themselves are left in place.
+func (s Store) ArchiveByUserID(ctx context.Context, userID string, now time.Time) error {
 	data := struct {
-		Offset      int `db:"offset"`
-		RowsPerPage int `db:"rows_per_page"`
+		UserID       string    `db:"user_id"`
+		DateArchived time.Time `db:"date_archived"`
 	}{
-		Offset:      (pageNumber - 1) * rowsPerPage,
-		RowsPerPage: rowsPerPage,
+		UserID:       userID,
+		DateArchived: now,
 	}
 
 	const q = `
+	INSERT INTO archived_products
+		(product_id, user_id, name, cost, quantity, sold, revenue, date_created, date_updated, date_archived)
 	SELECT
-		p.*,
-		COALESCE(SUM(s.quantity) ,0) AS sold,
-		COALESCE(SUM(s.paid), 0) AS revenue
+		p.product_id, p.user_id, p.name, p.cost, p.quantity,
+		COALESCE(SUM(s.quantity), 0),
+		COALESCE(SUM(s.paid), 0),
+		p.date_created, p.date_updated, :date_archived
 	FROM
 		products AS p
 	LEFT JOIN
-		sales AS s ON p.product_id = s.product_id
+		sales AS s ON p.product_id = s.product_id AND s.date_voided IS NULL
+	WHERE
+		p.user_id = :user_id
 	GROUP BY
-		p.product_id
-	ORDER BY
-		user_id
-	OFFSET :offset ROWS FETCH NEXT :rows_per_page ROWS ONLY`
+		p.product_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("archiving products userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// DeleteByUserID removes the products of a user from the database.
+func (s Store) DeleteByUserID(ctx context.Context, userID string) error {
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	DELETE FROM
+		products
+	WHERE
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("deleting products userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// orderByFields maps the fields products can be ordered by to their column.
+var orderByFields = map[string]string{
+	"product_id":   "p.product_id",
+	"name":         "p.name",
+	"cost":         "p.cost",
+	"quantity":     "p.quantity",
+	"date_created": "p.date_created",
+}
+
+// Query retrieves a page of products matching the filter, in the order of
+// the request. It also reports whether there are more products past the page
+// in the direction it was fetched.
+func (s Store) Query(ctx context.Context, filter QueryFilter, req paging.Request) ([]Product, bool, error) {
+	column, ok := orderByFields[req.Order.Field]
+	if !ok {
+		return nil, false, fmt.Errorf("field %q can't be ordered by", req.Order.Field)
+	}
+
+	q, data, err := database.Select("p.*", "COALESCE(SUM(s.quantity), 0) AS sold", "COALESCE(SUM(s.paid), 0) AS revenue").
+		From("products AS p").
+		Join("LEFT JOIN sales AS s ON p.product_id = s.product_id AND s.date_voided IS NULL").
+		Where(database.Filter(filter)...).
+		GroupBy("p.product_id").
+		Page(req, column, "p.product_id").
+		Build()
+	if err != nil {
+		return nil, false, fmt.Errorf("building query: %w", err)
+	}
 
 	var prds []Product
 	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &prds); err != nil {
-		return nil, fmt.Errorf("selecting products: %w", err)
+		return nil, false, fmt.Errorf("selecting products: %w", err)
 	}
 
-	return prds, nil
+	return prds, req.Trim(&prds), nil
+}
+
+// Count returns the number of products matching the filter.
+func (s Store) Count(ctx context.Context, filter QueryFilter) (int, error) {
+	q, data, err := database.Select("count(*) AS count").
+		From("products AS p").
+		Where(database.Filter(filter)...).
+		Build()
+	if err != nil {
+		return 0, fmt.Errorf("building query: %w", err)
+	}
+
+	var count struct {
+		Count int `db:"count"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &count); err != nil {
+		return 0, fmt.Errorf("counting products: %w", err)
+	}
+
+	return count.Count, nil
 }
 
 // QueryByID finds the product identified by a given ID.
@@ -152,7 +236,7 @@ func (s Store) QueryByID(ctx context.Context, productID string) (Product, error)
 	FROM
 		products AS p
 	LEFT JOIN
-		sales AS s ON p.product_id = s.product_id
+		sales AS s ON p.product_id = s.product_id AND s.date_voided IS NULL
 	WHERE
 		p.product_id = :product_id
 	GROUP BY
@@ -182,7 +266,7 @@ func (s Store) QueryByUserID(ctx context.Context, userID string) ([]Product, err
 	FROM
 		products AS p
 	LEFT JOIN
-		sales AS s ON p.product_id = s.product_id
+		sales AS s ON p.product_id = s.product_id AND s.date_voided IS NULL
 	WHERE
 		p.user_id = :user_id
 	GROUP BY
@@ -195,3 +279,148 @@ func (s Store) QueryByUserID(ctx context.Context, userID string) ([]Product, err
 
 	return prds, nil
 }
+
+// LockByID locks the product identified by a given ID for the remainder of the
+// current transaction, so concurrent purchases of it are serialized.
+func (s Store) LockByID(ctx context.Context, productID string) error {
+	data := struct {
+		ProductID string `db:"product_id"`
+	}{
+		ProductID: productID,
+	}
+
+	const q = `
+	SELECT
+		product_id
+	FROM
+		products
+	WHERE
+		product_id = :product_id
+	FOR UPDATE`
+
+	var lock struct {
+		ProductID string `db:"product_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &lock); err != nil {
+		return fmt.Errorf("locking product productID[%q]: %w", productID, err)
+	}
+
+	return nil
+}
+
+// QueryReserved returns the number of items of the product identified by a
+// given ID that are held by reservations which have not expired yet.
+func (s Store) QueryReserved(ctx context.Context, productID string, now time.Time) (int, error) {
+	data := struct {
+		ProductID string    `db:"product_id"`
+		Now       time.Time `db:"now"`
+	}{
+		ProductID: productID,
+		Now:       now,
+	}
+
+	const q = `
+	SELECT
+		COALESCE(SUM(quantity), 0) AS reserved
+	FROM
+		reservations
+	WHERE
+		product_id = :product_id AND date_expires > :now`
+
+	var res struct {
+		Reserved int `db:"reserved"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &res); err != nil {
+		return 0, fmt.Errorf("selecting reserved productID[%q]: %w", productID, err)
+	}
+
+	return res.Reserved, nil
+}
+
+// CreateReservation adds a Reservation to the database.
+func (s Store) CreateReservation(ctx context.Context, res Reservation) error {
+	const q = `
+	INSERT INTO reservations
+		(reservation_id, product_id, cart_id, user_id, quantity, date_created, date_expires)
+	VALUES
+		(:reservation_id, :product_id, :cart_id, :user_id, :quantity, :date_created, :date_expires)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, res); err != nil {
+		return fmt.Errorf("inserting reservation: %w", err)
+	}
+
+	return nil
+}
+
+// QueryReservationByID finds the reservation identified by a given ID.
+func (s Store) QueryReservationByID(ctx context.Context, reservationID string) (Reservation, error) {
+	data := struct {
+		ReservationID string `db:"reservation_id"`
+	}{
+		ReservationID: reservationID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		reservations
+	WHERE
+		reservation_id = :reservation_id`
+
+	var res Reservation
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &res); err != nil {
+		return Reservation{}, fmt.Errorf("selecting reservation reservationID[%q]: %w", reservationID, err)
+	}
+
+	return res, nil
+}
+
+// DeleteReservation removes the reservation identified by a given ID and
+// returns it. Concurrent calls for the same reservation wait on each other,
+// only the first one finds the reservation, the others get
+// database.ErrDBNotFound.
+func (s Store) DeleteReservation(ctx context.Context, reservationID string) (Reservation, error) {
+	data := struct {
+		ReservationID string `db:"reservation_id"`
+	}{
+		ReservationID: reservationID,
+	}
+
+	const q = `
+	DELETE FROM
+		reservations
+	WHERE
+		reservation_id = :reservation_id
+	RETURNING
+		*`
+
+	var res Reservation
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &res); err != nil {
+		return Reservation{}, fmt.Errorf("deleting reservation reservationID[%s]: %w", reservationID, err)
+	}
+
+	return res, nil
+}
+
+// DeleteExpiredReservations removes all reservations that expired at or
+// before the given time.
+func (s Store) DeleteExpiredReservations(ctx context.Context, now time.Time) error {
+	data := struct {
+		Now time.Time `db:"now"`
+	}{
+		Now: now,
+	}
+
+	const q = `
+	DELETE FROM
+		reservations
+	WHERE
+		date_expires <= :now`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("deleting expired reservations: %w", err)
+	}
+
+	return nil
+}
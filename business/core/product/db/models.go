@@ -14,3 +14,25 @@ type Product struct {
 	DateCreated time.Time `db:"date_created"` // When the product was added.
 	DateUpdated time.Time `db:"date_updated"` // When the product record was last modified.
 }
+
+// QueryFilter holds the fields products can be filtered on. Nil fields are
+// not filtered on.
+type QueryFilter struct {
+	Name             *string    `filter:"p.name,contains"`
+	MinCost          *int       `filter:"p.cost,gte"`
+	MaxCost          *int       `filter:"p.cost,lte"`
+	UserID           *string    `filter:"p.user_id,eq"`
+	StartCreatedDate *time.Time `filter:"p.date_created,gte"`
+	EndCreatedDate   *time.Time `filter:"p.date_created,lte"`
+}
+
+// Reservation represents a time-limited hold of product items for a cart.
+type Reservation struct {
+	ID          string    `db:"reservation_id"` // Unique identifier.
+	ProductID   string    `db:"product_id"`     // ID of the product being held.
+	CartID      string    `db:"cart_id"`        // ID of the cart holding the items.
+	UserID      string    `db:"user_id"`        // ID of the user holding the items.
+	Quantity    int       `db:"quantity"`       // Number of items being held.
+	DateCreated time.Time `db:"date_created"`   // When the reservation was made.
+	DateExpires time.Time `db:"date_expires"`   // When the held items are released.
+}
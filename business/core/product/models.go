@@ -1,10 +1,12 @@
 package product
 
 import (
+	"strconv"
 	"time"
 	"unsafe"
 
 	"github.com/Fiiii/WT/business/core/product/db"
+	"github.com/Fiiii/WT/business/sys/paging"
 )
 
 // Product represents an individual product.
@@ -40,6 +42,73 @@ type UpdateProduct struct {
 	Quantity *int    `json:"quantity" validate:"omitempty,gte=1"`
 }
 
+// QueryFilter holds the fields products can be filtered on. Name matches any
+// part of the name, the costs and dates bound the products included.
+type QueryFilter struct {
+	Name             *string    `json:"name" validate:"omitempty,min=1"`
+	MinCost          *int       `json:"min_cost" validate:"omitempty,gte=0"`
+	MaxCost          *int       `json:"max_cost" validate:"omitempty,gte=0"`
+	UserID           *string    `json:"user_id" validate:"omitempty,uuid4"`
+	StartCreatedDate *time.Time `json:"start_created_date"`
+	EndCreatedDate   *time.Time `json:"end_created_date"`
+}
+
+// Set of fields products can be ordered by.
+const (
+	OrderByID          = "product_id"
+	OrderByName        = "name"
+	OrderByCost        = "cost"
+	OrderByQuantity    = "quantity"
+	OrderByDateCreated = "date_created"
+)
+
+// OrderByFields lists the fields products can be ordered by.
+var OrderByFields = []string{OrderByID, OrderByName, OrderByCost, OrderByQuantity, OrderByDateCreated}
+
+// DefaultOrderBy is the order of products when none is requested.
+var DefaultOrderBy = paging.Order{Field: OrderByID}
+
+// NewPurchase is what we require from clients when buying a Product.
+type NewPurchase struct {
+	UserID   string `json:"user_id" validate:"required"`
+	Quantity int    `json:"quantity" validate:"gte=1"`
+}
+
+// Purchase represents a completed purchase of a Product.
+type Purchase struct {
+	SaleID      string    `json:"sale_id"`      // ID of the recorded sale.
+	ProductID   string    `json:"product_id"`   // ID of the product that was bought.
+	UserID      string    `json:"user_id"`      // ID of the user who made the purchase.
+	Quantity    int       `json:"quantity"`     // Number of items bought.
+	Paid        int       `json:"paid"`         // Total amount paid in cents.
+	DateCreated time.Time `json:"date_created"` // When the purchase was made.
+}
+
+// Reservation represents a time-limited hold of Product items for a cart.
+type Reservation struct {
+	ID          string    `json:"id"`           // Unique identifier.
+	ProductID   string    `json:"product_id"`   // ID of the product being held.
+	CartID      string    `json:"cart_id"`      // ID of the cart holding the items.
+	UserID      string    `json:"user_id"`      // ID of the user holding the items.
+	Quantity    int       `json:"quantity"`     // Number of items being held.
+	DateCreated time.Time `json:"date_created"` // When the reservation was made.
+	DateExpires time.Time `json:"date_expires"` // When the held items are released.
+}
+
+// NewReservation is what we require from clients when holding Product items.
+type NewReservation struct {
+	UserID   string `json:"user_id" validate:"required"`
+	CartID   string `json:"cart_id" validate:"required"`
+	Quantity int    `json:"quantity" validate:"gte=1"`
+	Minutes  int    `json:"minutes" validate:"gte=1,lte=60"`
+}
+
+// NewReservationPurchase is what we require from clients when converting a
+// Reservation into a Purchase. The user must be the one holding it.
+type NewReservationPurchase struct {
+	UserID string `json:"user_id" validate:"required"`
+}
+
 // =============================================================================
 
 func toProduct(dbPrd db.Product) Product {
@@ -47,6 +116,24 @@ func toProduct(dbPrd db.Product) Product {
 	return *pu
 }
 
+// cursor returns the position of the product in a list sorted by the order.
+func cursor(prd Product, order paging.Order) paging.Cursor {
+	var value string
+	switch order.Field {
+	case OrderByName:
+		value = prd.Name
+	case OrderByCost:
+		value = strconv.Itoa(prd.Cost)
+	case OrderByQuantity:
+		value = strconv.Itoa(prd.Quantity)
+	case OrderByDateCreated:
+		value = prd.DateCreated.Format(time.RFC3339Nano)
+	default:
+		value = prd.ID
+	}
+	return paging.NewCursor(order, value, prd.ID)
+}
+
 func toProductSlice(dbPrds []db.Product) []Product {
 	prds := make([]Product, len(dbPrds))
 	for i, dbPrd := range dbPrds {
@@ -54,3 +141,8 @@ func toProductSlice(dbPrds []db.Product) []Product {
 	}
 	return prds
 }
+
+func toReservation(dbRes db.Reservation) Reservation {
+	pr := (*Reservation)(unsafe.Pointer(&dbRes))
+	return *pr
+}
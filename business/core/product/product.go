@@ -7,33 +7,55 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Fiiii/WT/business/sys/auth"
 	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/paging"
 	"github.com/Fiiii/WT/business/sys/validate"
 	"time"
 
 	"github.com/Fiiii/WT/business/core/product/db"
+	saleDB "github.com/Fiiii/WT/business/core/sale/db"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 )
 
 // Set of error variables for CRUD operations.
 var (
-	ErrNotFound  = errors.New("product not found")
-	ErrInvalidID = errors.New("ID is not in its proper form")
+	ErrNotFound    = errors.New("product not found")
+	ErrInvalidID   = errors.New("ID is not in its proper form")
+	ErrUnknownUser = errors.New("product owner does not exist")
+)
+
+// Set of error variables for inventory operations.
+var (
+	ErrInsufficientStock   = errors.New("insufficient stock")
+	ErrReservationNotFound = errors.New("reservation not found")
+	ErrReservationExpired  = errors.New("reservation expired")
 )
 
 // Core manages the set of APIs for product access.
 type Core struct {
 	store db.Store
+	sales saleDB.Store
 }
 
 // NewCore constructs a core for product api access.
 func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB) Core {
 	return Core{
 		store: db.NewStore(log, sqlxDB),
+		sales: saleDB.NewStore(log, sqlxDB),
 	}
 }
 
+// Tran returns a core running against the transaction, so it can take part
+// in a business operation spanning several cores. Transactions the core
+// starts itself run within savepoints of it.
+func (c Core) Tran(tx sqlx.ExtContext) Core {
+	c.store = c.store.Tran(tx)
+	c.sales = c.sales.Tran(tx)
+	return c
+}
+
 // Create adds a Product to the database. It returns the created Product with
 // fields like ID and DateCreated populated.
 func (c Core) Create(ctx context.Context, np NewProduct, now time.Time) (Product, error) {
@@ -41,6 +63,10 @@ func (c Core) Create(ctx context.Context, np NewProduct, now time.Time) (Product
 		return Product{}, fmt.Errorf("validating data: %w", err)
 	}
 
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermProductsWrite, np.UserID); err != nil {
+		return Product{}, err
+	}
+
 	dbPrd := db.Product{
 		ID:          validate.GenerateID(),
 		Name:        np.Name,
@@ -52,6 +78,9 @@ func (c Core) Create(ctx context.Context, np NewProduct, now time.Time) (Product
 	}
 
 	if err := c.store.Create(ctx, dbPrd); err != nil {
+		if errors.Is(err, database.ErrDBForeignKey) {
+			return Product{}, ErrUnknownUser
+		}
 		return Product{}, fmt.Errorf("create: %w", err)
 	}
 
@@ -59,7 +88,8 @@ func (c Core) Create(ctx context.Context, np NewProduct, now time.Time) (Product
 }
 
 // Update modifies data about a Product. It will error if the specified ID is
-// invalid or does not reference an existing Product.
+// invalid or does not reference an existing Product. Only the owner of the
+// Product or an admin may modify it.
 func (c Core) Update(ctx context.Context, productID string, up UpdateProduct, now time.Time) error {
 	if err := validate.CheckID(productID); err != nil {
 		return ErrInvalidID
@@ -77,6 +107,10 @@ func (c Core) Update(ctx context.Context, productID string, up UpdateProduct, no
 		return fmt.Errorf("updating product productID[%s]: %w", productID, err)
 	}
 
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermProductsWrite, dbPrd.UserID); err != nil {
+		return err
+	}
+
 	if up.Name != nil {
 		dbPrd.Name = *up.Name
 	}
@@ -95,12 +129,25 @@ func (c Core) Update(ctx context.Context, productID string, up UpdateProduct, no
 	return nil
 }
 
-// Delete removes the product identified by a given ID.
+// Delete removes the product identified by a given ID. Only the owner of the
+// product or an admin may remove it.
 func (c Core) Delete(ctx context.Context, productID string) error {
 	if err := validate.CheckID(productID); err != nil {
 		return ErrInvalidID
 	}
 
+	dbPrd, err := c.store.QueryByID(ctx, productID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("deleting product productID[%s]: %w", productID, err)
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermProductsWrite, dbPrd.UserID); err != nil {
+		return err
+	}
+
 	if err := c.store.Delete(ctx, productID); err != nil {
 		return fmt.Errorf("delete: %w", err)
 	}
@@ -108,14 +155,65 @@ func (c Core) Delete(ctx context.Context, productID string) error {
 	return nil
 }
 
-// Query gets all Products from the database.
-func (c Core) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]Product, error) {
-	dbPrds, err := c.store.Query(ctx, pageNumber, rowsPerPage)
+// ArchiveByUserID moves the products of a user, with what they sold, into the
+// archive. Their sales and reservations are removed with them.
+func (c Core) ArchiveByUserID(ctx context.Context, userID string, now time.Time) error {
+	if err := validate.CheckID(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermProductsWrite, userID); err != nil {
+		return err
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		if err := store.ArchiveByUserID(ctx, userID, now); err != nil {
+			return fmt.Errorf("archive: %w", err)
+		}
+
+		if err := store.DeleteByUserID(ctx, userID); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	return nil
+}
+
+// Query retrieves a page of products matching the filter. The total number
+// of matching products is only counted when the request asks for it.
+func (c Core) Query(ctx context.Context, filter QueryFilter, req paging.Request) ([]Product, paging.Page, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, paging.Page{}, fmt.Errorf("validating filter: %w", err)
+	}
+
+	dbPrds, more, err := c.store.Query(ctx, db.QueryFilter(filter), req)
 	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
+		return nil, paging.Page{}, fmt.Errorf("query: %w", err)
 	}
+	prds := toProductSlice(dbPrds)
 
-	return toProductSlice(dbPrds), nil
+	var page paging.Page
+	if n := len(prds); n > 0 {
+		page = paging.NewPage(req, cursor(prds[0], req.Order), cursor(prds[n-1], req.Order), more)
+	}
+
+	if req.Total {
+		total, err := c.store.Count(ctx, db.QueryFilter(filter))
+		if err != nil {
+			return nil, paging.Page{}, fmt.Errorf("count: %w", err)
+		}
+		page.Total = &total
+	}
+
+	return prds, page, nil
 }
 
 // QueryByID finds the product identified by a given ID.
@@ -148,3 +246,254 @@ func (c Core) QueryByUserID(ctx context.Context, userID string) ([]Product, erro
 
 	return toProductSlice(dbPrds), nil
 }
+
+// Purchase buys the specified quantity of the product identified by a given ID.
+// The product row is locked for the duration of the transaction so concurrent
+// purchases cannot oversell it. ErrInsufficientStock is returned when the
+// quantity is not available.
+func (c Core) Purchase(ctx context.Context, productID string, np NewPurchase, now time.Time) (Purchase, error) {
+	if err := validate.CheckID(productID); err != nil {
+		return Purchase{}, ErrInvalidID
+	}
+
+	if err := validate.Check(np); err != nil {
+		return Purchase{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := validate.CheckID(np.UserID); err != nil {
+		return Purchase{}, ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermProductsPurchase, np.UserID); err != nil {
+		return Purchase{}, err
+	}
+
+	var pur Purchase
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbPrd, available, err := c.available(ctx, store, productID, now)
+		if err != nil {
+			return err
+		}
+
+		if np.Quantity > available {
+			return fmt.Errorf("%w: productID[%s] requested[%d] available[%d]", ErrInsufficientStock, productID, np.Quantity, available)
+		}
+
+		pur, err = c.recordSale(ctx, tx, dbPrd, np.UserID, np.Quantity, now)
+		return err
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return Purchase{}, fmt.Errorf("tran: %w", err)
+	}
+
+	return pur, nil
+}
+
+// Reserve holds the specified quantity of the product identified by a given ID
+// for a cart. The held items are not available to other purchases until the
+// reservation is released, bought or expires.
+func (c Core) Reserve(ctx context.Context, productID string, nr NewReservation, now time.Time) (Reservation, error) {
+	if err := validate.CheckID(productID); err != nil {
+		return Reservation{}, ErrInvalidID
+	}
+
+	if err := validate.Check(nr); err != nil {
+		return Reservation{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := validate.CheckID(nr.UserID); err != nil {
+		return Reservation{}, ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermProductsPurchase, nr.UserID); err != nil {
+		return Reservation{}, err
+	}
+
+	// Expired reservations no longer hold any items, they are cleaned up on
+	// the way so the table stays small.
+	if err := c.store.DeleteExpiredReservations(ctx, now); err != nil {
+		return Reservation{}, fmt.Errorf("delete expired reservations: %w", err)
+	}
+
+	dbRes := db.Reservation{
+		ID:          validate.GenerateID(),
+		ProductID:   productID,
+		CartID:      nr.CartID,
+		UserID:      nr.UserID,
+		Quantity:    nr.Quantity,
+		DateCreated: now,
+		DateExpires: now.Add(time.Duration(nr.Minutes) * time.Minute),
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		_, available, err := c.available(ctx, store, productID, now)
+		if err != nil {
+			return err
+		}
+
+		if nr.Quantity > available {
+			return fmt.Errorf("%w: productID[%s] requested[%d] available[%d]", ErrInsufficientStock, productID, nr.Quantity, available)
+		}
+
+		if err := store.CreateReservation(ctx, dbRes); err != nil {
+			return fmt.Errorf("create reservation: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return Reservation{}, fmt.Errorf("tran: %w", err)
+	}
+
+	return toReservation(dbRes), nil
+}
+
+// PurchaseReservation converts the reservation identified by a given ID into
+// a purchase of the held items. Only the user holding the reservation can buy
+// it. ErrReservationExpired is returned when the reservation is no longer
+// holding the items.
+func (c Core) PurchaseReservation(ctx context.Context, reservationID string, nrp NewReservationPurchase, now time.Time) (Purchase, error) {
+	if err := validate.CheckID(reservationID); err != nil {
+		return Purchase{}, ErrInvalidID
+	}
+
+	if err := validate.Check(nrp); err != nil {
+		return Purchase{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := validate.CheckID(nrp.UserID); err != nil {
+		return Purchase{}, ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermProductsPurchase, nrp.UserID); err != nil {
+		return Purchase{}, err
+	}
+
+	var pur Purchase
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		// Deleting the reservation first locks it, so a concurrent purchase of
+		// the same reservation waits and then finds nothing to buy. The
+		// deletion is rolled back if the reservation expired.
+		dbRes, err := store.DeleteReservation(ctx, reservationID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrReservationNotFound
+			}
+			return fmt.Errorf("delete reservation: %w", err)
+		}
+
+		if dbRes.UserID != nrp.UserID {
+			return fmt.Errorf("%w: reservation held by another user", auth.ErrForbidden)
+		}
+
+		if !now.Before(dbRes.DateExpires) {
+			return ErrReservationExpired
+		}
+
+		// The held items are already excluded from the available stock, so
+		// only the product lock is needed to keep the aggregates consistent.
+		dbPrd, _, err := c.available(ctx, store, dbRes.ProductID, now)
+		if err != nil {
+			return err
+		}
+
+		pur, err = c.recordSale(ctx, tx, dbPrd, nrp.UserID, dbRes.Quantity, now)
+		return err
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return Purchase{}, fmt.Errorf("tran: %w", err)
+	}
+
+	return pur, nil
+}
+
+// Release cancels the reservation identified by a given ID, making the held
+// items available again. Only the user holding the reservation or an admin
+// may release it.
+func (c Core) Release(ctx context.Context, reservationID string) error {
+	if err := validate.CheckID(reservationID); err != nil {
+		return ErrInvalidID
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		// The deletion is rolled back when the caller may not release it.
+		dbRes, err := store.DeleteReservation(ctx, reservationID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrReservationNotFound
+			}
+			return fmt.Errorf("delete reservation: %w", err)
+		}
+
+		return auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermProductsPurchase, dbRes.UserID)
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	return nil
+}
+
+// available locks the product identified by a given ID and returns it together
+// with the number of items that can still be bought or reserved. It must be
+// called with a store bound to a transaction.
+func (c Core) available(ctx context.Context, store db.Store, productID string, now time.Time) (db.Product, int, error) {
+	if err := store.LockByID(ctx, productID); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return db.Product{}, 0, ErrNotFound
+		}
+		return db.Product{}, 0, fmt.Errorf("lock: %w", err)
+	}
+
+	dbPrd, err := store.QueryByID(ctx, productID)
+	if err != nil {
+		return db.Product{}, 0, fmt.Errorf("query: %w", err)
+	}
+
+	reserved, err := store.QueryReserved(ctx, productID, now)
+	if err != nil {
+		return db.Product{}, 0, fmt.Errorf("query reserved: %w", err)
+	}
+
+	return dbPrd, dbPrd.Quantity - dbPrd.Sold - reserved, nil
+}
+
+// recordSale inserts the sale of the given quantity of a product within the
+// provided transaction.
+func (c Core) recordSale(ctx context.Context, tx sqlx.ExtContext, dbPrd db.Product, userID string, quantity int, now time.Time) (Purchase, error) {
+	dbSale := saleDB.Sale{
+		ID:          validate.GenerateID(),
+		UserID:      userID,
+		ProductID:   dbPrd.ID,
+		Quantity:    quantity,
+		Paid:        dbPrd.Cost * quantity,
+		DateCreated: now,
+	}
+
+	if err := c.sales.Tran(tx).Create(ctx, dbSale); err != nil {
+		return Purchase{}, fmt.Errorf("create sale: %w", err)
+	}
+
+	pur := Purchase{
+		SaleID:      dbSale.ID,
+		ProductID:   dbSale.ProductID,
+		UserID:      dbSale.UserID,
+		Quantity:    dbSale.Quantity,
+		Paid:        dbSale.Paid,
+		DateCreated: dbSale.DateCreated,
+	}
+
+	return pur, nil
+}
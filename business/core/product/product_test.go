@@ -8,6 +8,11 @@ import (
 	"time"
 
 	"github.com/Fiiii/WT/business/core/product"
+	"github.com/Fiiii/WT/business/data/dbtest"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/paging"
+	"github.com/Fiiii/WT/foundation/docker"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -36,7 +41,10 @@ func TestProduct(t *testing.T) {
 		testID := 0
 		t.Logf("\tTest %d:\tWhen handling a single Product.", testID)
 		{
-			ctx := context.Background()
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "5cf37266-3473-4006-984f-9325122678b7"},
+				Roles:            []string{auth.RoleAdmin},
+			})
 			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
 
 			np := product.NewProduct{
@@ -75,7 +83,7 @@ func TestProduct(t *testing.T) {
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to update product.", dbtest.Success, testID)
 
-			products, err := core.Query(ctx, 1, 3)
+			products, _, err := core.Query(ctx, product.QueryFilter{}, paging.Request{Order: product.DefaultOrderBy, Limit: 3})
 			if err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve updated product : %s.", dbtest.Failed, testID, err)
 			}
@@ -134,3 +142,286 @@ func TestProduct(t *testing.T) {
 		}
 	}
 }
+
+func TestPurchase(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testpurchase")
+	t.Cleanup(teardown)
+
+	core := product.NewCore(log, db)
+
+	t.Log("Given the need to buy and reserve Product items.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling a limited stock Product.", testID)
+		{
+			const userID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: userID},
+				Roles:            []string{auth.RoleUser},
+			})
+			now := time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC)
+
+			np := product.NewProduct{
+				Name:     "Limited Edition",
+				Cost:     100,
+				Quantity: 5,
+				UserID:   userID,
+			}
+
+			prd, err := core.Create(ctx, np, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a product : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create a product.", dbtest.Success, testID)
+
+			pur, err := core.Purchase(ctx, prd.ID, product.NewPurchase{UserID: userID, Quantity: 2}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to buy a product : %s.", dbtest.Failed, testID, err)
+			}
+			if pur.Paid != 2*np.Cost {
+				t.Fatalf("\t%s\tTest %d:\tShould pay for the bought items : got %d want %d.", dbtest.Failed, testID, pur.Paid, 2*np.Cost)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to buy a product.", dbtest.Success, testID)
+
+			res, err := core.Reserve(ctx, prd.ID, product.NewReservation{UserID: userID, CartID: "cart-1", Quantity: 2, Minutes: 10}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reserve a product : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to reserve a product.", dbtest.Success, testID)
+
+			_, err = core.Purchase(ctx, prd.ID, product.NewPurchase{UserID: userID, Quantity: 2}, now)
+			if !errors.Is(err, product.ErrInsufficientStock) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to buy reserved items : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to buy reserved items.", dbtest.Success, testID)
+
+			later := now.Add(11 * time.Minute)
+			if _, err := core.Purchase(ctx, prd.ID, product.NewPurchase{UserID: userID, Quantity: 2}, later); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to buy items of an expired reservation : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to buy items of an expired reservation.", dbtest.Success, testID)
+
+			_, err = core.PurchaseReservation(ctx, res.ID, product.NewReservationPurchase{UserID: userID}, later)
+			if !errors.Is(err, product.ErrReservationExpired) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to buy an expired reservation : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to buy an expired reservation.", dbtest.Success, testID)
+
+			res, err = core.Reserve(ctx, prd.ID, product.NewReservation{UserID: userID, CartID: "cart-2", Quantity: 1, Minutes: 10}, later)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reserve the last item : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to reserve the last item.", dbtest.Success, testID)
+
+			if _, err := core.PurchaseReservation(ctx, res.ID, product.NewReservationPurchase{UserID: userID}, later); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to buy a reservation : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to buy a reservation.", dbtest.Success, testID)
+
+			saved, err := core.QueryByID(ctx, prd.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve product by ID: %s.", dbtest.Failed, testID, err)
+			}
+			if saved.Sold != saved.Quantity {
+				t.Fatalf("\t%s\tTest %d:\tShould have sold the whole stock : got %d want %d.", dbtest.Failed, testID, saved.Sold, saved.Quantity)
+			}
+			t.Logf("\t%s\tTest %d:\tShould have sold the whole stock.", dbtest.Success, testID)
+
+			_, err = core.Purchase(ctx, prd.ID, product.NewPurchase{UserID: userID, Quantity: 1}, later)
+			if !errors.Is(err, product.ErrInsufficientStock) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to oversell a product : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to oversell a product.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen a reservation is bought twice at the same time.", testID)
+		{
+			const userID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: userID},
+				Roles:            []string{auth.RoleUser},
+			})
+			now := time.Date(2019, time.January, 3, 0, 0, 0, 0, time.UTC)
+
+			np := product.NewProduct{
+				Name:     "Last One",
+				Cost:     100,
+				Quantity: 1,
+				UserID:   userID,
+			}
+
+			prd, err := core.Create(ctx, np, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a product : %s.", dbtest.Failed, testID, err)
+			}
+
+			res, err := core.Reserve(ctx, prd.ID, product.NewReservation{UserID: userID, CartID: "cart-3", Quantity: 1, Minutes: 10}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reserve a product : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to reserve a product.", dbtest.Success, testID)
+
+			errs := make(chan error, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					_, err := core.PurchaseReservation(ctx, res.ID, product.NewReservationPurchase{UserID: userID}, now)
+					errs <- err
+				}()
+			}
+
+			var bought, notFound int
+			for i := 0; i < 2; i++ {
+				switch err := <-errs; {
+				case err == nil:
+					bought++
+				case errors.Is(err, product.ErrReservationNotFound):
+					notFound++
+				default:
+					t.Fatalf("\t%s\tTest %d:\tShould be able to buy the reservation : %s.", dbtest.Failed, testID, err)
+				}
+			}
+			if bought != 1 || notFound != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould buy the reservation only once : bought[%d] notFound[%d].", dbtest.Failed, testID, bought, notFound)
+			}
+			t.Logf("\t%s\tTest %d:\tShould buy the reservation only once.", dbtest.Success, testID)
+
+			saved, err := core.QueryByID(ctx, prd.ID)
+			if err != nil || saved.Sold != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould have sold a single item : %+v %v.", dbtest.Failed, testID, saved, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould have sold a single item.", dbtest.Success, testID)
+
+			if err := core.Release(ctx, res.ID); !errors.Is(err, product.ErrReservationNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to release a bought reservation : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to release a bought reservation.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen a reservation is held by another user.", testID)
+		{
+			const (
+				userID  = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+				otherID = "5cf37266-3473-4006-984f-9325122678b7"
+			)
+
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: userID},
+				Roles:            []string{auth.RoleUser},
+			})
+			otherCtx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: otherID},
+				Roles:            []string{auth.RoleUser},
+			})
+			now := time.Date(2019, time.January, 4, 0, 0, 0, 0, time.UTC)
+
+			np := product.NewProduct{
+				Name:     "Held",
+				Cost:     100,
+				Quantity: 1,
+				UserID:   userID,
+			}
+
+			prd, err := core.Create(ctx, np, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a product : %s.", dbtest.Failed, testID, err)
+			}
+
+			nr := product.NewReservation{UserID: userID, CartID: "cart-4", Quantity: 1, Minutes: 10}
+			if _, err := core.Reserve(otherCtx, prd.ID, nr, now); !errors.Is(err, auth.ErrForbidden) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to reserve for another user : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to reserve for another user.", dbtest.Success, testID)
+
+			res, err := core.Reserve(ctx, prd.ID, nr, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reserve a product : %s.", dbtest.Failed, testID, err)
+			}
+
+			if err := core.Release(otherCtx, res.ID); !errors.Is(err, auth.ErrForbidden) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to release the reservation of another user : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to release the reservation of another user.", dbtest.Success, testID)
+
+			if _, err := core.PurchaseReservation(otherCtx, res.ID, product.NewReservationPurchase{UserID: otherID}, now); !errors.Is(err, auth.ErrForbidden) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to buy the reservation of another user : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to buy the reservation of another user.", dbtest.Success, testID)
+
+			if err := core.Release(ctx, res.ID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to release its own reservation : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to release its own reservation.", dbtest.Success, testID)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testquery")
+	t.Cleanup(teardown)
+
+	core := product.NewCore(log, db)
+
+	t.Log("Given the need to page through filtered Products.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen paging by cost with a name filter.", testID)
+		{
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "5cf37266-3473-4006-984f-9325122678b7"},
+				Roles:            []string{auth.RoleAdmin},
+			})
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			for i, cost := range []int{30, 10, 20} {
+				np := product.NewProduct{
+					Name:     fmt.Sprintf("Puzzle 100%% #%d", i),
+					Cost:     cost,
+					Quantity: 1,
+					UserID:   "5cf37266-3473-4006-984f-9325122678b7",
+				}
+				if _, err := core.Create(ctx, np, now); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create a product : %s.", dbtest.Failed, testID, err)
+				}
+			}
+
+			name := "100%"
+			filter := product.QueryFilter{Name: &name}
+			order := paging.Order{Field: product.OrderByCost}
+
+			prds, page, err := core.Query(ctx, filter, paging.Request{Order: order, Limit: 2, Total: true})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query products : %s.", dbtest.Failed, testID, err)
+			}
+			if len(prds) != 2 || prds[0].Cost != 10 || prds[1].Cost != 20 || page.Total == nil || *page.Total != 3 {
+				t.Fatalf("\t%s\tTest %d:\tShould get the cheapest matching products first : %+v %+v.", dbtest.Failed, testID, prds, page)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the cheapest matching products first.", dbtest.Success, testID)
+
+			cursor, err := paging.ParseCursor(page.Next, order)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to parse the next cursor : %s.", dbtest.Failed, testID, err)
+			}
+
+			prds, page, err = core.Query(ctx, filter, paging.Request{Order: order, Cursor: cursor, Limit: 2})
+			if err != nil || len(prds) != 1 || prds[0].Cost != 30 || page.Next != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get the last product on the next page : %+v %+v %v.", dbtest.Failed, testID, prds, page, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the last product on the next page.", dbtest.Success, testID)
+
+			cursor, err = paging.ParseCursor(page.Prev, order)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to parse the previous cursor : %s.", dbtest.Failed, testID, err)
+			}
+
+			prds, _, err = core.Query(ctx, filter, paging.Request{Order: order, Cursor: cursor, Limit: 2})
+			if err != nil || len(prds) != 2 || prds[0].Cost != 10 || prds[1].Cost != 20 {
+				t.Fatalf("\t%s\tTest %d:\tShould get the first page back in order : %+v %v.", dbtest.Failed, testID, prds, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the first page back in order.", dbtest.Success, testID)
+		}
+	}
+}
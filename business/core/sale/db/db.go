@@ -0,0 +1,160 @@
+// Package db contains sale related CRUD functionality.
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/paging"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for sale access.
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+// NewStore constructs a data for api access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs passed function and do commit/rollback at the end. When
+// the store is already within a transaction, fn runs within a savepoint of
+// it instead and the options are ignored.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error, opts ...database.TranOption) error {
+	if s.isWithinTran {
+		return database.WithinSavepoint(ctx, s.log, s.db, fn)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn, opts...)
+}
+
+// Tran return new Store with transaction in it.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// Create adds a Sale to the database.
+func (s Store) Create(ctx context.Context, sl Sale) error {
+	q, data, err := database.Insert("sales", sl, "sale_id", "user_id", "product_id", "quantity", "paid", "date_created").Build()
+	if err != nil {
+		return fmt.Errorf("building query: %w", err)
+	}
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("inserting sale: %w", err)
+	}
+
+	return nil
+}
+
+// Void marks the sale identified by a given ID as refunded/voided. Voided
+// sales are kept for auditing but excluded from product aggregates.
+func (s Store) Void(ctx context.Context, saleID string, now time.Time) error {
+	q, data, err := database.Update("sales").
+		Set("date_voided", now).
+		Where(database.Eq("sale_id", saleID)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("building query: %w", err)
+	}
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("voiding sale saleID[%s]: %w", saleID, err)
+	}
+
+	return nil
+}
+
+// orderByFields maps the fields sales can be ordered by to their column.
+var orderByFields = map[string]string{
+	"sale_id":      "sale_id",
+	"quantity":     "quantity",
+	"paid":         "paid",
+	"date_created": "date_created",
+}
+
+// Query retrieves a page of sales matching the filter, in the order of the
+// request. It also reports whether there are more sales past the page in the
+// direction it was fetched.
+func (s Store) Query(ctx context.Context, filter QueryFilter, req paging.Request) ([]Sale, bool, error) {
+	column, ok := orderByFields[req.Order.Field]
+	if !ok {
+		return nil, false, fmt.Errorf("field %q can't be ordered by", req.Order.Field)
+	}
+
+	q, data, err := database.Select("*").
+		From("sales").
+		Where(database.Filter(filter)...).
+		Page(req, column, "sale_id").
+		Build()
+	if err != nil {
+		return nil, false, fmt.Errorf("building query: %w", err)
+	}
+
+	var sls []Sale
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &sls); err != nil {
+		return nil, false, fmt.Errorf("selecting sales: %w", err)
+	}
+
+	return sls, req.Trim(&sls), nil
+}
+
+// Count returns the number of sales matching the filter.
+func (s Store) Count(ctx context.Context, filter QueryFilter) (int, error) {
+	q, data, err := database.Select("count(*) AS count").
+		From("sales").
+		Where(database.Filter(filter)...).
+		Build()
+	if err != nil {
+		return 0, fmt.Errorf("building query: %w", err)
+	}
+
+	var count struct {
+		Count int `db:"count"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &count); err != nil {
+		return 0, fmt.Errorf("counting sales: %w", err)
+	}
+
+	return count.Count, nil
+}
+
+// QueryByID finds the sale identified by a given ID.
+func (s Store) QueryByID(ctx context.Context, saleID string) (Sale, error) {
+	data := struct {
+		SaleID string `db:"sale_id"`
+	}{
+		SaleID: saleID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		sales
+	WHERE
+		sale_id = :sale_id`
+
+	var sl Sale
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &sl); err != nil {
+		return Sale{}, fmt.Errorf("selecting sale saleID[%q]: %w", saleID, err)
+	}
+
+	return sl, nil
+}
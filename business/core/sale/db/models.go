@@ -0,0 +1,23 @@
+package db
+
+import "time"
+
+// Sale represents an individual sale of a product.
+type Sale struct {
+	ID          string     `db:"sale_id"`      // Unique identifier.
+	UserID      string     `db:"user_id"`      // ID of the user who made the purchase.
+	ProductID   string     `db:"product_id"`   // ID of the product that was sold.
+	Quantity    int        `db:"quantity"`     // Number of items sold.
+	Paid        int        `db:"paid"`         // Total amount paid in cents.
+	DateCreated time.Time  `db:"date_created"` // When the sale was recorded.
+	DateVoided  *time.Time `db:"date_voided"`  // When the sale was refunded/voided.
+}
+
+// QueryFilter holds the fields sales can be filtered on. Nil fields are not
+// filtered on.
+type QueryFilter struct {
+	ProductID        *string    `filter:"product_id,eq"`
+	UserID           *string    `filter:"user_id,eq"`
+	StartCreatedDate *time.Time `filter:"date_created,gte"`
+	EndCreatedDate   *time.Time `filter:"date_created,lte"`
+}
@@ -0,0 +1,83 @@
+package sale
+
+import (
+	"strconv"
+	"time"
+	"unsafe"
+
+	"github.com/Fiiii/WT/business/core/sale/db"
+	"github.com/Fiiii/WT/business/sys/paging"
+)
+
+// Sale represents an individual sale of a product.
+type Sale struct {
+	ID          string     `json:"id"`                    // Unique identifier.
+	UserID      string     `json:"user_id"`               // ID of the user who made the purchase.
+	ProductID   string     `json:"product_id"`            // ID of the product that was sold.
+	Quantity    int        `json:"quantity"`              // Number of items sold.
+	Paid        int        `json:"paid"`                  // Total amount paid in cents.
+	DateCreated time.Time  `json:"date_created"`          // When the sale was recorded.
+	DateVoided  *time.Time `json:"date_voided,omitempty"` // When the sale was refunded/voided.
+}
+
+// NewSale is what we require from clients when recording a Sale. The amount
+// paid is not taken from clients, it follows from the cost of the product.
+type NewSale struct {
+	UserID    string `json:"user_id" validate:"required"`
+	ProductID string `json:"product_id" validate:"required"`
+	Quantity  int    `json:"quantity" validate:"gte=1"`
+}
+
+// QueryFilter holds the fields sales can be filtered on. The dates bound when
+// the sales were recorded.
+type QueryFilter struct {
+	ProductID        *string    `json:"product_id" validate:"omitempty,uuid4"`
+	UserID           *string    `json:"user_id" validate:"omitempty,uuid4"`
+	StartCreatedDate *time.Time `json:"start_created_date"`
+	EndCreatedDate   *time.Time `json:"end_created_date"`
+}
+
+// Set of fields sales can be ordered by.
+const (
+	OrderByID          = "sale_id"
+	OrderByQuantity    = "quantity"
+	OrderByPaid        = "paid"
+	OrderByDateCreated = "date_created"
+)
+
+// OrderByFields lists the fields sales can be ordered by.
+var OrderByFields = []string{OrderByID, OrderByQuantity, OrderByPaid, OrderByDateCreated}
+
+// DefaultOrderBy is the order of sales when none is requested.
+var DefaultOrderBy = paging.Order{Field: OrderByDateCreated}
+
+// =============================================================================
+
+// cursor returns the position of the sale in a list sorted by the order.
+func cursor(sl Sale, order paging.Order) paging.Cursor {
+	var value string
+	switch order.Field {
+	case OrderByQuantity:
+		value = strconv.Itoa(sl.Quantity)
+	case OrderByPaid:
+		value = strconv.Itoa(sl.Paid)
+	case OrderByDateCreated:
+		value = sl.DateCreated.Format(time.RFC3339Nano)
+	default:
+		value = sl.ID
+	}
+	return paging.NewCursor(order, value, sl.ID)
+}
+
+func toSale(dbSale db.Sale) Sale {
+	ps := (*Sale)(unsafe.Pointer(&dbSale))
+	return *ps
+}
+
+func toSaleSlice(dbSales []db.Sale) []Sale {
+	sales := make([]Sale, len(dbSales))
+	for i, dbSale := range dbSales {
+		sales[i] = toSale(dbSale)
+	}
+	return sales
+}
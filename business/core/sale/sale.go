@@ -0,0 +1,188 @@
+// Package sale provides a core business API for recording and querying
+// product sales.
+package sale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/product"
+	"github.com/Fiiii/WT/business/core/sale/db"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/paging"
+	"github.com/Fiiii/WT/business/sys/validate"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Set of error variables for CRUD operations.
+var (
+	ErrNotFound         = errors.New("sale not found")
+	ErrInvalidID        = errors.New("ID is not in its proper form")
+	ErrAlreadyVoided    = errors.New("sale is already voided")
+	ErrInvalidDateRange = errors.New("date range is not valid")
+)
+
+// Core manages the set of APIs for sale access.
+type Core struct {
+	store    db.Store
+	products product.Core
+}
+
+// NewCore constructs a core for sale api access.
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB) Core {
+	return Core{
+		store:    db.NewStore(log, sqlxDB),
+		products: product.NewCore(log, sqlxDB),
+	}
+}
+
+// Create records a new Sale on behalf of a user. Only admins can record sales
+// this way, and the sale is made as a purchase of the product, so the stock
+// is checked and the amount paid follows from its cost. It returns the
+// created Sale with fields like ID and DateCreated populated.
+func (c Core) Create(ctx context.Context, ns NewSale, now time.Time) (Sale, error) {
+	if err := validate.Check(ns); err != nil {
+		return Sale{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := validate.CheckID(ns.UserID); err != nil {
+		return Sale{}, ErrInvalidID
+	}
+
+	if err := validate.CheckID(ns.ProductID); err != nil {
+		return Sale{}, ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.AdminOnly, auth.PermSalesWrite, ""); err != nil {
+		return Sale{}, err
+	}
+
+	np := product.NewPurchase{
+		UserID:   ns.UserID,
+		Quantity: ns.Quantity,
+	}
+
+	pur, err := c.products.Purchase(ctx, ns.ProductID, np, now)
+	if err != nil {
+		return Sale{}, fmt.Errorf("purchase: %w", err)
+	}
+
+	sl := Sale{
+		ID:          pur.SaleID,
+		UserID:      pur.UserID,
+		ProductID:   pur.ProductID,
+		Quantity:    pur.Quantity,
+		Paid:        pur.Paid,
+		DateCreated: pur.DateCreated,
+	}
+
+	return sl, nil
+}
+
+// Void refunds the sale identified by a given ID. The sale is kept for
+// auditing but no longer counts towards the product sold/revenue aggregates.
+func (c Core) Void(ctx context.Context, saleID string, now time.Time) error {
+	if err := validate.CheckID(saleID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.AdminOnly, auth.PermSalesVoid, ""); err != nil {
+		return err
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbSale, err := store.QueryByID(ctx, saleID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrNotFound
+			}
+			return fmt.Errorf("query: %w", err)
+		}
+
+		if dbSale.DateVoided != nil {
+			return ErrAlreadyVoided
+		}
+
+		if err := store.Void(ctx, saleID, now); err != nil {
+			return fmt.Errorf("void: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	return nil
+}
+
+// Query retrieves a page of sales matching the filter. Users can only list
+// their own sales, so the filter must name them unless the caller is an
+// admin. The total number of matching sales is only counted when the request
+// asks for it.
+func (c Core) Query(ctx context.Context, filter QueryFilter, req paging.Request) ([]Sale, paging.Page, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, paging.Page{}, fmt.Errorf("validating filter: %w", err)
+	}
+
+	if filter.StartCreatedDate != nil && filter.EndCreatedDate != nil && filter.StartCreatedDate.After(*filter.EndCreatedDate) {
+		return nil, paging.Page{}, ErrInvalidDateRange
+	}
+
+	var ownerID string
+	if filter.UserID != nil {
+		ownerID = *filter.UserID
+	}
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermSalesRead, ownerID); err != nil {
+		return nil, paging.Page{}, err
+	}
+
+	dbSales, more, err := c.store.Query(ctx, db.QueryFilter(filter), req)
+	if err != nil {
+		return nil, paging.Page{}, fmt.Errorf("query: %w", err)
+	}
+	sales := toSaleSlice(dbSales)
+
+	var page paging.Page
+	if n := len(sales); n > 0 {
+		page = paging.NewPage(req, cursor(sales[0], req.Order), cursor(sales[n-1], req.Order), more)
+	}
+
+	if req.Total {
+		total, err := c.store.Count(ctx, db.QueryFilter(filter))
+		if err != nil {
+			return nil, paging.Page{}, fmt.Errorf("count: %w", err)
+		}
+		page.Total = &total
+	}
+
+	return sales, page, nil
+}
+
+// QueryByID finds the sale identified by a given ID.
+func (c Core) QueryByID(ctx context.Context, saleID string) (Sale, error) {
+	if err := validate.CheckID(saleID); err != nil {
+		return Sale{}, ErrInvalidID
+	}
+
+	dbSale, err := c.store.QueryByID(ctx, saleID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Sale{}, ErrNotFound
+		}
+		return Sale{}, fmt.Errorf("query: %w", err)
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermSalesRead, dbSale.UserID); err != nil {
+		return Sale{}, err
+	}
+
+	return toSale(dbSale), nil
+}
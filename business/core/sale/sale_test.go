@@ -0,0 +1,188 @@
+package sale_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/product"
+	"github.com/Fiiii/WT/business/core/sale"
+	"github.com/Fiiii/WT/business/data/dbtest"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/paging"
+	"github.com/Fiiii/WT/foundation/docker"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/go-cmp/cmp"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+func TestSale(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testsale")
+	t.Cleanup(teardown)
+
+	core := sale.NewCore(log, db)
+	prdCore := product.NewCore(log, db)
+
+	t.Log("Given the need to work with Sale records.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling a single Sale.", testID)
+		{
+			now := time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC)
+
+			userID := "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+			productID := "72f8b983-3eb4-48db-9ed0-e45cc6bd716b"
+
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: userID},
+				Roles:            []string{auth.RoleUser},
+			})
+			adminCtx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "5cf37266-3473-4006-984f-9325122678b7"},
+				Roles:            []string{auth.RoleAdmin},
+			})
+
+			before, err := prdCore.QueryByID(ctx, productID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve product by ID: %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to retrieve product by ID.", dbtest.Success, testID)
+
+			ns := sale.NewSale{
+				UserID:    userID,
+				ProductID: productID,
+				Quantity:  2,
+			}
+
+			if _, err := core.Create(ctx, ns, now); !errors.Is(err, auth.ErrForbidden) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to create a sale as a user : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to create a sale as a user.", dbtest.Success, testID)
+
+			sl, err := core.Create(adminCtx, ns, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a sale : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create a sale.", dbtest.Success, testID)
+
+			if sl.Paid != before.Cost*ns.Quantity {
+				t.Fatalf("\t%s\tTest %d:\tShould pay the cost of the product : got %d.", dbtest.Failed, testID, sl.Paid)
+			}
+			t.Logf("\t%s\tTest %d:\tShould pay the cost of the product.", dbtest.Success, testID)
+
+			ns.Quantity = before.Quantity
+			if _, err := core.Create(adminCtx, ns, now); !errors.Is(err, product.ErrInsufficientStock) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to sell more than the stock : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to sell more than the stock.", dbtest.Success, testID)
+			ns.Quantity = 2
+
+			saved, err := core.QueryByID(ctx, sl.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve sale by ID: %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to retrieve sale by ID.", dbtest.Success, testID)
+
+			if diff := cmp.Diff(sl, saved); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the same sale. Diff:\n%s", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get back the same sale.", dbtest.Success, testID)
+
+			userFilter := sale.QueryFilter{UserID: &userID}
+			sales, _, err := core.Query(ctx, userFilter, paging.Request{Order: sale.DefaultOrderBy, Limit: 10})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve sales by user : %s.", dbtest.Failed, testID, err)
+			}
+			if len(sales) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould get back one sale by user : got %d.", dbtest.Failed, testID, len(sales))
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to retrieve sales by user.", dbtest.Success, testID)
+
+			if _, _, err := core.Query(ctx, sale.QueryFilter{}, paging.Request{Order: sale.DefaultOrderBy, Limit: 10}); !errors.Is(err, auth.ErrForbidden) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve the sales of others as a user : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to retrieve the sales of others as a user.", dbtest.Success, testID)
+
+			productFilter := sale.QueryFilter{ProductID: &productID}
+			sales, page, err := core.Query(adminCtx, productFilter, paging.Request{Order: sale.DefaultOrderBy, Limit: 1, Total: true})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve sales by product : %s.", dbtest.Failed, testID, err)
+			}
+			if len(sales) != 1 || page.Next == "" || page.Total == nil || *page.Total != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould get back a page of the two sales by product : got %d %+v.", dbtest.Failed, testID, len(sales), page)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to retrieve sales by product.", dbtest.Success, testID)
+
+			start, end := now, now.Add(time.Hour)
+			rangeFilter := sale.QueryFilter{StartCreatedDate: &start, EndCreatedDate: &end}
+			sales, _, err = core.Query(adminCtx, rangeFilter, paging.Request{Order: sale.DefaultOrderBy, Limit: 10})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve sales by date range : %s.", dbtest.Failed, testID, err)
+			}
+			if len(sales) != 1 || sales[0].ID != sl.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the new sale by date range : got %d.", dbtest.Failed, testID, len(sales))
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to retrieve sales by date range.", dbtest.Success, testID)
+
+			rangeFilter = sale.QueryFilter{StartCreatedDate: &end, EndCreatedDate: &start}
+			if _, _, err := core.Query(adminCtx, rangeFilter, paging.Request{Order: sale.DefaultOrderBy, Limit: 10}); !errors.Is(err, sale.ErrInvalidDateRange) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve sales by a reversed date range : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to retrieve sales by a reversed date range.", dbtest.Success, testID)
+
+			after, err := prdCore.QueryByID(ctx, productID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve product by ID: %s.", dbtest.Failed, testID, err)
+			}
+			if after.Sold != before.Sold+ns.Quantity || after.Revenue != before.Revenue+sl.Paid {
+				t.Fatalf("\t%s\tTest %d:\tShould see the sale in product aggregates : sold %d revenue %d.", dbtest.Failed, testID, after.Sold, after.Revenue)
+			}
+			t.Logf("\t%s\tTest %d:\tShould see the sale in product aggregates.", dbtest.Success, testID)
+
+			if err := core.Void(ctx, sl.ID, now.Add(time.Minute)); !errors.Is(err, auth.ErrForbidden) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to void a sale as a user : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to void a sale as a user.", dbtest.Success, testID)
+
+			if err := core.Void(adminCtx, sl.ID, now.Add(time.Minute)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to void a sale : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to void a sale.", dbtest.Success, testID)
+
+			if err := core.Void(adminCtx, sl.ID, now.Add(time.Minute)); !errors.Is(err, sale.ErrAlreadyVoided) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to void a sale twice : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to void a sale twice.", dbtest.Success, testID)
+
+			after, err = prdCore.QueryByID(ctx, productID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve product by ID: %s.", dbtest.Failed, testID, err)
+			}
+			if diff := cmp.Diff(before, after); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould exclude voided sales from product aggregates. Diff:\n%s", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould exclude voided sales from product aggregates.", dbtest.Success, testID)
+
+			_, err = core.QueryByID(ctx, "not-a-uuid")
+			if !errors.Is(err, sale.ErrInvalidID) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve sale with invalid ID : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to retrieve sale with invalid ID.", dbtest.Success, testID)
+		}
+	}
+}
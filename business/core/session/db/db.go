@@ -0,0 +1,230 @@
+// Package db contains refresh and revoked token related CRUD functionality.
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for session access.
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+// NewStore constructs a data for api access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs passed function and do commit/rollback at the end. When
+// the store is already within a transaction, fn runs within a savepoint of
+// it instead and the options are ignored.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error, opts ...database.TranOption) error {
+	if s.isWithinTran {
+		return database.WithinSavepoint(ctx, s.log, s.db, fn)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn, opts...)
+}
+
+// Tran return new Store with transaction in it.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// CreateRefreshToken adds a RefreshToken to the database.
+func (s Store) CreateRefreshToken(ctx context.Context, rt RefreshToken) error {
+	const q = `
+	INSERT INTO refresh_tokens
+		(token_id, family_id, user_id, token_hash, date_created, date_expires)
+	VALUES
+		(:token_id, :family_id, :user_id, :token_hash, :date_created, :date_expires)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, rt); err != nil {
+		return fmt.Errorf("inserting refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// QueryRefreshTokenByHash finds the refresh token with the given hash and
+// locks it for the remainder of the current transaction.
+func (s Store) QueryRefreshTokenByHash(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	data := struct {
+		TokenHash string `db:"token_hash"`
+	}{
+		TokenHash: tokenHash,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		refresh_tokens
+	WHERE
+		token_hash = :token_hash
+	FOR UPDATE`
+
+	var rt RefreshToken
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &rt); err != nil {
+		return RefreshToken{}, fmt.Errorf("selecting refresh token: %w", err)
+	}
+
+	return rt, nil
+}
+
+// MarkRefreshTokenUsed records that the refresh token identified by a given
+// ID was exchanged.
+func (s Store) MarkRefreshTokenUsed(ctx context.Context, tokenID string, now time.Time) error {
+	data := struct {
+		TokenID  string    `db:"token_id"`
+		DateUsed time.Time `db:"date_used"`
+	}{
+		TokenID:  tokenID,
+		DateUsed: now,
+	}
+
+	const q = `
+	UPDATE
+		refresh_tokens
+	SET
+		"date_used" = :date_used
+	WHERE
+		token_id = :token_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("marking refresh token tokenID[%s] used: %w", tokenID, err)
+	}
+
+	return nil
+}
+
+// RevokeFamily revokes every refresh token of the given family which is not
+// revoked yet.
+func (s Store) RevokeFamily(ctx context.Context, familyID string, now time.Time) error {
+	data := struct {
+		FamilyID    string    `db:"family_id"`
+		DateRevoked time.Time `db:"date_revoked"`
+	}{
+		FamilyID:    familyID,
+		DateRevoked: now,
+	}
+
+	const q = `
+	UPDATE
+		refresh_tokens
+	SET
+		"date_revoked" = :date_revoked
+	WHERE
+		family_id = :family_id AND date_revoked IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("revoking refresh token familyID[%s]: %w", familyID, err)
+	}
+
+	return nil
+}
+
+// RevokeUserFamilies revokes every refresh token of the given user which is
+// not revoked yet.
+func (s Store) RevokeUserFamilies(ctx context.Context, userID string, now time.Time) error {
+	data := struct {
+		UserID      string    `db:"user_id"`
+		DateRevoked time.Time `db:"date_revoked"`
+	}{
+		UserID:      userID,
+		DateRevoked: now,
+	}
+
+	const q = `
+	UPDATE
+		refresh_tokens
+	SET
+		"date_revoked" = :date_revoked
+	WHERE
+		user_id = :user_id AND date_revoked IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("revoking refresh tokens userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// CreateRevokedToken adds an access token id to the revocation list.
+func (s Store) CreateRevokedToken(ctx context.Context, rt RevokedToken) error {
+	const q = `
+	INSERT INTO revoked_tokens
+		(jti, date_expires)
+	VALUES
+		(:jti, :date_expires)
+	ON CONFLICT DO NOTHING`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, rt); err != nil {
+		return fmt.Errorf("inserting revoked token: %w", err)
+	}
+
+	return nil
+}
+
+// QueryRevokedToken finds the revoked access token with the given id.
+func (s Store) QueryRevokedToken(ctx context.Context, jti string) (RevokedToken, error) {
+	data := struct {
+		JTI string `db:"jti"`
+	}{
+		JTI: jti,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		revoked_tokens
+	WHERE
+		jti = :jti`
+
+	var rt RevokedToken
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &rt); err != nil {
+		return RevokedToken{}, fmt.Errorf("selecting revoked token jti[%q]: %w", jti, err)
+	}
+
+	return rt, nil
+}
+
+// DeleteExpiredRevokedTokens removes revoked access tokens which expired at
+// or before the given time, since they fail validation on their own.
+func (s Store) DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) error {
+	data := struct {
+		Now time.Time `db:"now"`
+	}{
+		Now: now,
+	}
+
+	const q = `
+	DELETE FROM
+		revoked_tokens
+	WHERE
+		date_expires <= :now`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("deleting expired revoked tokens: %w", err)
+	}
+
+	return nil
+}
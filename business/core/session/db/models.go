@@ -0,0 +1,22 @@
+package db
+
+import "time"
+
+// RefreshToken represents a persisted refresh token. Only the hash of the
+// token is stored. Tokens issued by rotating one another share a family.
+type RefreshToken struct {
+	ID          string     `db:"token_id"`
+	FamilyID    string     `db:"family_id"`
+	UserID      string     `db:"user_id"`
+	TokenHash   string     `db:"token_hash"`
+	DateCreated time.Time  `db:"date_created"`
+	DateExpires time.Time  `db:"date_expires"`
+	DateUsed    *time.Time `db:"date_used"`
+	DateRevoked *time.Time `db:"date_revoked"`
+}
+
+// RevokedToken represents an access token revoked before its expiration.
+type RevokedToken struct {
+	JTI         string    `db:"jti"`
+	DateExpires time.Time `db:"date_expires"`
+}
@@ -0,0 +1,11 @@
+package session
+
+import "time"
+
+// Refresh represents a freshly issued refresh token. The token value is only
+// known at issue time, the database keeps its hash.
+type Refresh struct {
+	UserID      string    // ID of the user the token was issued to.
+	Token       string    // Opaque token value handed to the client.
+	DateExpires time.Time // When the token can no longer be exchanged.
+}
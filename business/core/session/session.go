@@ -0,0 +1,239 @@
+// Package session provides a core business API for refresh tokens and access
+// token revocation. Refresh tokens are opaque, single use and rotated on every
+// exchange. Presenting an already exchanged token revokes its whole family.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/session/db"
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/validate"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Set of error variables for session operations.
+var (
+	ErrInvalidToken = errors.New("refresh token is not valid")
+	ErrTokenReused  = errors.New("refresh token was already used")
+)
+
+// refreshTTL is how long a refresh token can be exchanged after being issued.
+const refreshTTL = 7 * 24 * time.Hour
+
+// Core manages the set of APIs for session access.
+type Core struct {
+	store db.Store
+}
+
+// NewCore constructs a core for session api access.
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB) Core {
+	return Core{
+		store: db.NewStore(log, sqlxDB),
+	}
+}
+
+// Issue creates a refresh token starting a new family for the specified user.
+func (c Core) Issue(ctx context.Context, userID string, now time.Time) (Refresh, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return Refresh{}, validate.ErrInvalidID
+	}
+
+	ref, dbRT, err := newRefresh(userID, validate.GenerateID(), now)
+	if err != nil {
+		return Refresh{}, err
+	}
+
+	if err := c.store.CreateRefreshToken(ctx, dbRT); err != nil {
+		return Refresh{}, fmt.Errorf("create: %w", err)
+	}
+
+	return ref, nil
+}
+
+// Rotate exchanges a refresh token for a new one of the same family. When the
+// token was already exchanged the whole family is revoked and ErrTokenReused
+// is returned, since either the client or an attacker holds a stolen token.
+func (c Core) Rotate(ctx context.Context, token string, now time.Time) (Refresh, error) {
+	var ref Refresh
+	var reused bool
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbRT, err := store.QueryRefreshTokenByHash(ctx, hash(token))
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrInvalidToken
+			}
+			return fmt.Errorf("query: %w", err)
+		}
+
+		switch {
+		case dbRT.DateRevoked != nil:
+			return ErrInvalidToken
+
+		case dbRT.DateUsed != nil:
+
+			// Commit the revocation and report the reuse afterwards, returning
+			// an error here would roll the revocation back.
+			reused = true
+			if err := store.RevokeFamily(ctx, dbRT.FamilyID, now); err != nil {
+				return fmt.Errorf("revoke family: %w", err)
+			}
+			return nil
+
+		case !now.Before(dbRT.DateExpires):
+			return ErrInvalidToken
+		}
+
+		if err := store.MarkRefreshTokenUsed(ctx, dbRT.ID, now); err != nil {
+			return fmt.Errorf("mark used: %w", err)
+		}
+
+		var newRT db.RefreshToken
+		ref, newRT, err = newRefresh(dbRT.UserID, dbRT.FamilyID, now)
+		if err != nil {
+			return err
+		}
+
+		if err := store.CreateRefreshToken(ctx, newRT); err != nil {
+			return fmt.Errorf("create: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return Refresh{}, fmt.Errorf("tran: %w", err)
+	}
+
+	if reused {
+		return Refresh{}, ErrTokenReused
+	}
+
+	return ref, nil
+}
+
+// Revoke revokes the family of the refresh token so none of its tokens can
+// be exchanged anymore. The token must belong to the specified user.
+func (c Core) Revoke(ctx context.Context, userID string, token string, now time.Time) error {
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbRT, err := store.QueryRefreshTokenByHash(ctx, hash(token))
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrInvalidToken
+			}
+			return fmt.Errorf("query: %w", err)
+		}
+
+		if dbRT.UserID != userID {
+			return ErrInvalidToken
+		}
+
+		if err := store.RevokeFamily(ctx, dbRT.FamilyID, now); err != nil {
+			return fmt.Errorf("revoke family: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeUser revokes every refresh token family of the user, signing them out
+// everywhere once their access tokens expire.
+func (c Core) RevokeUser(ctx context.Context, userID string, now time.Time) error {
+	if err := c.store.RevokeUserFamilies(ctx, userID, now); err != nil {
+		return fmt.Errorf("revoke user families: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeAccess adds the access token identified by jti to the revocation list
+// until the token expires on its own.
+func (c Core) RevokeAccess(ctx context.Context, jti string, expires time.Time) error {
+	dbRevoked := db.RevokedToken{
+		JTI:         jti,
+		DateExpires: expires,
+	}
+
+	if err := c.store.CreateRevokedToken(ctx, dbRevoked); err != nil {
+		return fmt.Errorf("create revoked: %w", err)
+	}
+
+	return nil
+}
+
+// IsRevoked reports if the access token identified by jti was revoked. It
+// implements the auth.RevocationList interface.
+func (c Core) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	if _, err := c.store.QueryRevokedToken(ctx, jti); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("query revoked: %w", err)
+	}
+
+	return true, nil
+}
+
+// PurgeRevoked removes revoked access tokens which expired at or before the
+// given time, since they fail validation on their own.
+func (c Core) PurgeRevoked(ctx context.Context, now time.Time) error {
+	if err := c.store.DeleteExpiredRevokedTokens(ctx, now); err != nil {
+		return fmt.Errorf("delete expired revoked: %w", err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// newRefresh generates a random refresh token for the user within the family.
+func newRefresh(userID string, familyID string, now time.Time) (Refresh, db.RefreshToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return Refresh{}, db.RefreshToken{}, fmt.Errorf("generating token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	dbRT := db.RefreshToken{
+		ID:          validate.GenerateID(),
+		FamilyID:    familyID,
+		UserID:      userID,
+		TokenHash:   hash(token),
+		DateCreated: now,
+		DateExpires: now.Add(refreshTTL),
+	}
+
+	ref := Refresh{
+		UserID:      userID,
+		Token:       token,
+		DateExpires: dbRT.DateExpires,
+	}
+
+	return ref, dbRT, nil
+}
+
+// hash returns the hex encoded SHA-256 of a token. Refresh tokens carry enough
+// entropy that a fast hash is sufficient.
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
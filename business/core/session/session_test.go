@@ -0,0 +1,119 @@
+package session_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/session"
+	"github.com/Fiiii/WT/business/data/dbtest"
+	"github.com/Fiiii/WT/foundation/docker"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+func TestSession(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testsession")
+	t.Cleanup(teardown)
+
+	core := session.NewCore(log, db)
+
+	t.Log("Given the need to work with refresh tokens.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen rotating a refresh token family.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			const userID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+
+			first, err := core.Issue(ctx, userID, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to issue a refresh token : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to issue a refresh token.", dbtest.Success, testID)
+
+			second, err := core.Rotate(ctx, first.Token, now.Add(time.Minute))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to rotate a refresh token : %s.", dbtest.Failed, testID, err)
+			}
+			if second.UserID != userID || second.Token == first.Token {
+				t.Fatalf("\t%s\tTest %d:\tShould get a new token for the same user : %+v.", dbtest.Failed, testID, second)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to rotate a refresh token.", dbtest.Success, testID)
+
+			if _, err := core.Rotate(ctx, first.Token, now.Add(2*time.Minute)); !errors.Is(err, session.ErrTokenReused) {
+				t.Fatalf("\t%s\tTest %d:\tShould detect reuse of a rotated token : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould detect reuse of a rotated token.", dbtest.Success, testID)
+
+			if _, err := core.Rotate(ctx, second.Token, now.Add(3*time.Minute)); !errors.Is(err, session.ErrInvalidToken) {
+				t.Fatalf("\t%s\tTest %d:\tShould revoke the whole family on reuse : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould revoke the whole family on reuse.", dbtest.Success, testID)
+
+			third, err := core.Issue(ctx, userID, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to issue a refresh token : %s.", dbtest.Failed, testID, err)
+			}
+
+			if err := core.Revoke(ctx, "5cf37266-3473-4006-984f-9325122678b7", third.Token, now); !errors.Is(err, session.ErrInvalidToken) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to revoke a token of another user : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to revoke a token of another user.", dbtest.Success, testID)
+
+			if err := core.Revoke(ctx, userID, third.Token, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to revoke a refresh token : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.Rotate(ctx, third.Token, now.Add(time.Minute)); !errors.Is(err, session.ErrInvalidToken) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to rotate a revoked token : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to revoke a refresh token.", dbtest.Success, testID)
+
+			if _, err := core.Rotate(ctx, "unknown", now); !errors.Is(err, session.ErrInvalidToken) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to rotate an unknown token : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to rotate an unknown token.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen revoking access tokens.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			const jti = "2e1a4f1c-96a6-4d7c-8f5e-0c4b3d3c6a11"
+
+			revoked, err := core.IsRevoked(ctx, jti)
+			if err != nil || revoked {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT see a token as revoked before : %v %s.", dbtest.Failed, testID, revoked, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT see a token as revoked before.", dbtest.Success, testID)
+
+			if err := core.RevokeAccess(ctx, jti, now.Add(time.Hour)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to revoke an access token : %s.", dbtest.Failed, testID, err)
+			}
+
+			revoked, err = core.IsRevoked(ctx, jti)
+			if err != nil || !revoked {
+				t.Fatalf("\t%s\tTest %d:\tShould see a token as revoked after : %v %s.", dbtest.Failed, testID, revoked, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould see a token as revoked after.", dbtest.Success, testID)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package db contains OIDC login and identity related CRUD functionality.
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for login and identity access.
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+// NewStore constructs a data for api access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs passed function and do commit/rollback at the end. When
+// the store is already within a transaction, fn runs within a savepoint of
+// it instead and the options are ignored.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error, opts ...database.TranOption) error {
+	if s.isWithinTran {
+		return database.WithinSavepoint(ctx, s.log, s.db, fn)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn, opts...)
+}
+
+// Tran return new Store with transaction in it.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// CreateLogin adds a Login to the database.
+func (s Store) CreateLogin(ctx context.Context, login Login) error {
+	const q = `
+	INSERT INTO oidc_logins
+		(state, nonce, code_verifier, date_expires)
+	VALUES
+		(:state, :nonce, :code_verifier, :date_expires)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, login); err != nil {
+		return fmt.Errorf("inserting login: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteLogin removes the login with the given state and returns it, so a
+// state can only be used once.
+func (s Store) DeleteLogin(ctx context.Context, state string) (Login, error) {
+	data := struct {
+		State string `db:"state"`
+	}{
+		State: state,
+	}
+
+	const q = `
+	DELETE FROM
+		oidc_logins
+	WHERE
+		state = :state
+	RETURNING
+		*`
+
+	var login Login
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &login); err != nil {
+		return Login{}, fmt.Errorf("deleting login: %w", err)
+	}
+
+	return login, nil
+}
+
+// DeleteExpiredLogins removes the logins which expired at or before the
+// given time.
+func (s Store) DeleteExpiredLogins(ctx context.Context, now time.Time) error {
+	data := struct {
+		Now time.Time `db:"now"`
+	}{
+		Now: now,
+	}
+
+	const q = `
+	DELETE FROM
+		oidc_logins
+	WHERE
+		date_expires <= :now`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("deleting expired logins: %w", err)
+	}
+
+	return nil
+}
+
+// CreateIdentity adds an Identity to the database.
+func (s Store) CreateIdentity(ctx context.Context, id Identity) error {
+	const q = `
+	INSERT INTO user_identities
+		(issuer, subject, user_id, date_created)
+	VALUES
+		(:issuer, :subject, :user_id, :date_created)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, id); err != nil {
+		return fmt.Errorf("inserting identity: %w", err)
+	}
+
+	return nil
+}
+
+// QueryIdentity finds the identity of the given account at a provider.
+func (s Store) QueryIdentity(ctx context.Context, issuer string, subject string) (Identity, error) {
+	data := struct {
+		Issuer  string `db:"issuer"`
+		Subject string `db:"subject"`
+	}{
+		Issuer:  issuer,
+		Subject: subject,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		user_identities
+	WHERE
+		issuer = :issuer AND subject = :subject`
+
+	var id Identity
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &id); err != nil {
+		return Identity{}, fmt.Errorf("selecting identity: %w", err)
+	}
+
+	return id, nil
+}
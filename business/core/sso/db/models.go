@@ -0,0 +1,20 @@
+package db
+
+import "time"
+
+// Login represents an OIDC login in progress, between sending the user to
+// the provider and the provider calling back.
+type Login struct {
+	State        string    `db:"state"`
+	Nonce        string    `db:"nonce"`
+	CodeVerifier string    `db:"code_verifier"`
+	DateExpires  time.Time `db:"date_expires"`
+}
+
+// Identity represents the link between an account at a provider and a user.
+type Identity struct {
+	Issuer      string    `db:"issuer"`
+	Subject     string    `db:"subject"`
+	UserID      string    `db:"user_id"`
+	DateCreated time.Time `db:"date_created"`
+}
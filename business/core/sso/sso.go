@@ -0,0 +1,149 @@
+// Package sso provides a core business API for signing users in through an
+// external OpenID Connect provider. Accounts at the provider are linked to
+// existing users by their verified email the first time they sign in.
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/sso/db"
+	userDB "github.com/Fiiii/WT/business/core/user/db"
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/foundation/oidc"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Set of error variables for login operations.
+var (
+	ErrInvalidState = errors.New("login state is not valid")
+	ErrNotLinked    = errors.New("account is not linked to a user")
+)
+
+// loginTTL is how long the user has to complete the login at the provider.
+const loginTTL = 10 * time.Minute
+
+// Provider declares the behavior of the OIDC provider users sign in with.
+type Provider interface {
+	AuthCodeURL(state string, nonce string, challenge string) string
+	Exchange(ctx context.Context, code string, verifier string) (oidc.IDToken, error)
+}
+
+// Core manages the set of APIs for OIDC login access.
+type Core struct {
+	store    db.Store
+	users    userDB.Store
+	provider Provider
+}
+
+// NewCore constructs a core for OIDC login api access.
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB, provider Provider) Core {
+	return Core{
+		store:    db.NewStore(log, sqlxDB),
+		users:    userDB.NewStore(log, sqlxDB),
+		provider: provider,
+	}
+}
+
+// Begin starts a login and returns the URL of the provider to send the user
+// to along with the state the provider will call back with.
+func (c Core) Begin(ctx context.Context, now time.Time) (string, string, error) {
+
+	// Logins which were never completed are cleaned up on the way.
+	if err := c.store.DeleteExpiredLogins(ctx, now); err != nil {
+		return "", "", fmt.Errorf("delete expired: %w", err)
+	}
+
+	var login db.Login
+	for _, v := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		value, err := oidc.RandomValue()
+		if err != nil {
+			return "", "", err
+		}
+		*v = value
+	}
+	login.DateExpires = now.Add(loginTTL)
+
+	if err := c.store.CreateLogin(ctx, login); err != nil {
+		return "", "", fmt.Errorf("create: %w", err)
+	}
+
+	authURL := c.provider.AuthCodeURL(login.State, login.Nonce, oidc.Challenge(login.CodeVerifier))
+
+	return authURL, login.State, nil
+}
+
+// Complete finishes the login identified by state with the code the provider
+// returned. It returns the ID of the user the account is linked to. Accounts
+// signing in for the first time are linked to the user with the same email,
+// provided the provider verified it.
+func (c Core) Complete(ctx context.Context, state string, code string, now time.Time) (string, error) {
+	login, err := c.store.DeleteLogin(ctx, state)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return "", ErrInvalidState
+		}
+		return "", fmt.Errorf("delete login: %w", err)
+	}
+
+	if !now.Before(login.DateExpires) {
+		return "", ErrInvalidState
+	}
+
+	idToken, err := c.provider.Exchange(ctx, code, login.CodeVerifier)
+	if err != nil {
+		return "", fmt.Errorf("exchange: %w", err)
+	}
+
+	if idToken.Nonce != login.Nonce {
+		return "", ErrInvalidState
+	}
+
+	var userID string
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		id, err := store.QueryIdentity(ctx, idToken.Issuer, idToken.Subject)
+		switch {
+		case err == nil:
+			userID = id.UserID
+			return nil
+		case !errors.Is(err, database.ErrDBNotFound):
+			return fmt.Errorf("query identity: %w", err)
+		}
+
+		if idToken.Email == "" || !idToken.EmailVerified {
+			return ErrNotLinked
+		}
+
+		dbUsr, err := c.users.Tran(tx).QueryByEmail(ctx, idToken.Email)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrNotLinked
+			}
+			return fmt.Errorf("query user: %w", err)
+		}
+
+		id = db.Identity{
+			Issuer:      idToken.Issuer,
+			Subject:     idToken.Subject,
+			UserID:      dbUsr.ID,
+			DateCreated: now,
+		}
+		if err := store.CreateIdentity(ctx, id); err != nil {
+			return fmt.Errorf("create identity: %w", err)
+		}
+
+		userID = dbUsr.ID
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return "", fmt.Errorf("tran: %w", err)
+	}
+
+	return userID, nil
+}
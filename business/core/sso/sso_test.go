@@ -0,0 +1,130 @@
+package sso_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/sso"
+	"github.com/Fiiii/WT/business/data/dbtest"
+	"github.com/Fiiii/WT/foundation/docker"
+	"github.com/Fiiii/WT/foundation/oidc"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+func TestLogin(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testsso")
+	t.Cleanup(teardown)
+
+	fake := dbtest.StartOIDCProvider(t, "wt-api")
+
+	ctx := context.Background()
+	provider, err := oidc.NewProvider(ctx, oidc.Config{
+		Issuer:      fake.URL,
+		ClientID:    fake.ClientID,
+		RedirectURL: "http://localhost:3000/v1/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to discover the provider : %s.", dbtest.Failed, err)
+	}
+
+	core := sso.NewCore(log, db, provider)
+
+	// login runs the whole flow for the account and returns the linked user.
+	login := func(account dbtest.OIDCAccount, now time.Time) (string, string, error) {
+		fake.SignIn(account)
+
+		authURL, state, err := core.Begin(ctx, now)
+		if err != nil {
+			return "", "", err
+		}
+
+		code, gotState, err := fake.Authorize(authURL)
+		if err != nil {
+			return "", "", err
+		}
+		if gotState != state {
+			return "", "", fmt.Errorf("provider returned state %q want %q", gotState, state)
+		}
+
+		userID, err := core.Complete(ctx, state, code, now)
+		return userID, state, err
+	}
+
+	t.Log("Given the need to sign users in through an OIDC provider.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen signing in with a verified email of a user.", testID)
+		{
+			const userID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+			now := time.Now()
+
+			account := dbtest.OIDCAccount{
+				Subject:       "1001",
+				Email:         "user@example.com",
+				EmailVerified: true,
+			}
+
+			got, state, err := login(account, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to sign in : %s.", dbtest.Failed, testID, err)
+			}
+			if got != userID {
+				t.Fatalf("\t%s\tTest %d:\tShould be linked to the user with the same email : got %s.", dbtest.Failed, testID, got)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be linked to the user with the same email.", dbtest.Success, testID)
+
+			if _, err := core.Complete(ctx, state, "code", now); !errors.Is(err, sso.ErrInvalidState) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use a state twice : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use a state twice.", dbtest.Success, testID)
+
+			account.Email = "renamed@example.com"
+			got, _, err = login(account, now)
+			if err != nil || got != userID {
+				t.Fatalf("\t%s\tTest %d:\tShould stay linked when the email changes : %s %v.", dbtest.Failed, testID, got, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould stay linked when the email changes.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen signing in with an account that cannot be linked.", testID)
+		{
+			now := time.Now()
+
+			unverified := dbtest.OIDCAccount{
+				Subject: "1002",
+				Email:   "admin@example.com",
+			}
+			if _, _, err := login(unverified, now); !errors.Is(err, sso.ErrNotLinked) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT link an unverified email : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT link an unverified email.", dbtest.Success, testID)
+
+			unknown := dbtest.OIDCAccount{
+				Subject:       "1003",
+				Email:         "stranger@example.com",
+				EmailVerified: true,
+			}
+			if _, _, err := login(unknown, now); !errors.Is(err, sso.ErrNotLinked) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT link an unknown email : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT link an unknown email.", dbtest.Success, testID)
+		}
+	}
+}
@@ -4,8 +4,10 @@ package db
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/paging"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 )
@@ -27,12 +29,14 @@ func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
 	}
 }
 
-// WithinTran runs passed function and do commit/rollback at the end.
-func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+// WithinTran runs passed function and do commit/rollback at the end. When
+// the store is already within a transaction, fn runs within a savepoint of
+// it instead and the options are ignored.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error, opts ...database.TranOption) error {
 	if s.isWithinTran {
-		return fn(s.db)
+		return database.WithinSavepoint(ctx, s.log, s.db, fn)
 	}
-	return database.WithinTran(ctx, s.log, s.tr, fn)
+	return database.WithinTran(ctx, s.log, s.tr, fn, opts...)
 }
 
 // Tran return new Store with transaction in it.
@@ -70,6 +74,7 @@ func (s Store) Update(ctx context.Context, usr User) error {
 		"email" = :email,
 		"roles" = :roles,
 		"password_hash" = :password_hash,
+		"email_verified_at" = :email_verified_at,
 		"date_updated" = :date_updated
 	WHERE
 		user_id = :user_id`
@@ -81,52 +86,294 @@ func (s Store) Update(ctx context.Context, usr User) error {
 	return nil
 }
 
-// Delete removes a user from the database.
-func (s Store) Delete(ctx context.Context, userID string) error {
+// UpdatePasswordHash replaces the password hash of a user, as long as it is
+// still the old one. A password changed in the meantime is kept.
+func (s Store) UpdatePasswordHash(ctx context.Context, userID string, oldHash []byte, newHash []byte) error {
+	data := struct {
+		UserID  string `db:"user_id"`
+		OldHash []byte `db:"old_hash"`
+		NewHash []byte `db:"new_hash"`
+	}{
+		UserID:  userID,
+		OldHash: oldHash,
+		NewHash: newHash,
+	}
+
+	const q = `
+	UPDATE
+		users
+	SET
+		"password_hash" = :new_hash
+	WHERE
+		user_id = :user_id AND
+		password_hash = :old_hash`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("updating password hash userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// UpdateMFA replaces the multi-factor authentication state of a user.
+func (s Store) UpdateMFA(ctx context.Context, usr User) error {
+	const q = `
+	UPDATE
+		users
+	SET
+		"mfa_secret" = :mfa_secret,
+		"mfa_last_step" = :mfa_last_step,
+		"date_mfa_enabled" = :date_mfa_enabled
+	WHERE
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, usr); err != nil {
+		return fmt.Errorf("updating mfa userID[%s]: %w", usr.ID, err)
+	}
+
+	return nil
+}
+
+// AdvanceMFAStep records the TOTP step of the last accepted code, provided it
+// is later than the one recorded. It returns database.ErrDBNotFound otherwise.
+func (s Store) AdvanceMFAStep(ctx context.Context, userID string, step int64) error {
 	data := struct {
 		UserID string `db:"user_id"`
+		Step   int64  `db:"mfa_last_step"`
 	}{
 		UserID: userID,
+		Step:   step,
 	}
 
 	const q = `
-	DELETE FROM
+	UPDATE
 		users
+	SET
+		"mfa_last_step" = :mfa_last_step
+	WHERE
+		user_id = :user_id AND mfa_last_step < :mfa_last_step
+	RETURNING
+		*`
+
+	var usr User
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &usr); err != nil {
+		return fmt.Errorf("advancing mfa step userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// CreateRecoveryCode adds a RecoveryCode to the database.
+func (s Store) CreateRecoveryCode(ctx context.Context, rc RecoveryCode) error {
+	const q = `
+	INSERT INTO recovery_codes
+		(code_id, user_id, code_hash)
+	VALUES
+		(:code_id, :user_id, :code_hash)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, rc); err != nil {
+		return fmt.Errorf("inserting recovery code: %w", err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks the unused recovery code of the user with the given
+// hash as used. It returns database.ErrDBNotFound if there is no such code.
+func (s Store) UseRecoveryCode(ctx context.Context, userID string, codeHash string, now time.Time) error {
+	data := struct {
+		UserID   string    `db:"user_id"`
+		CodeHash string    `db:"code_hash"`
+		DateUsed time.Time `db:"date_used"`
+	}{
+		UserID:   userID,
+		CodeHash: codeHash,
+		DateUsed: now,
+	}
+
+	const q = `
+	UPDATE
+		recovery_codes
+	SET
+		"date_used" = :date_used
+	WHERE
+		user_id = :user_id AND code_hash = :code_hash AND date_used IS NULL
+	RETURNING
+		*`
+
+	var rc RecoveryCode
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &rc); err != nil {
+		return fmt.Errorf("using recovery code userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// DeleteRecoveryCodes removes every recovery code of a user.
+func (s Store) DeleteRecoveryCodes(ctx context.Context, userID string) error {
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	DELETE FROM
+		recovery_codes
 	WHERE
 		user_id = :user_id`
 
 	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
-		return fmt.Errorf("deleting userID[%s]: %w", userID, err)
+		return fmt.Errorf("deleting recovery codes userID[%s]: %w", userID, err)
 	}
 
 	return nil
 }
 
-// Query retrieves a list of existing users from the database.
-func (s Store) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]User, error) {
+// CreateToken adds a single use token to the database.
+func (s Store) CreateToken(ctx context.Context, tkn Token) error {
+	const q = `
+	INSERT INTO user_tokens
+		(token_hash, user_id, purpose, email, date_created, date_expires)
+	VALUES
+		(:token_hash, :user_id, :purpose, :email, :date_created, :date_expires)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, tkn); err != nil {
+		return fmt.Errorf("inserting token userID[%s]: %w", tkn.UserID, err)
+	}
+
+	return nil
+}
+
+// UseToken marks the token as used, provided it has the purpose, was not
+// used before and has not expired. It returns database.ErrDBNotFound otherwise.
+func (s Store) UseToken(ctx context.Context, tokenHash string, purpose string, now time.Time) (Token, error) {
 	data := struct {
-		Offset      int `db:"offset"`
-		RowsPerPage int `db:"rows_per_page"`
+		TokenHash string    `db:"token_hash"`
+		Purpose   string    `db:"purpose"`
+		DateUsed  time.Time `db:"date_used"`
 	}{
-		Offset:      (pageNumber - 1) * rowsPerPage,
-		RowsPerPage: rowsPerPage,
+		TokenHash: tokenHash,
+		Purpose:   purpose,
+		DateUsed:  now,
 	}
 
 	const q = `
-	SELECT
-		*
-	FROM
+	UPDATE
+		user_tokens
+	SET
+		"date_used" = :date_used
+	WHERE
+		token_hash = :token_hash AND purpose = :purpose AND
+		date_used IS NULL AND date_expires > :date_used
+	RETURNING
+		*`
+
+	var tkn Token
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &tkn); err != nil {
+		return Token{}, fmt.Errorf("using token purpose[%s]: %w", purpose, err)
+	}
+
+	return tkn, nil
+}
+
+// DeleteUnusedTokens removes the unused tokens of a user for the purpose, so
+// only the most recently mailed token works.
+func (s Store) DeleteUnusedTokens(ctx context.Context, userID string, purpose string) error {
+	data := struct {
+		UserID  string `db:"user_id"`
+		Purpose string `db:"purpose"`
+	}{
+		UserID:  userID,
+		Purpose: purpose,
+	}
+
+	const q = `
+	DELETE FROM
+		user_tokens
+	WHERE
+		user_id = :user_id AND purpose = :purpose AND date_used IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("deleting tokens userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// Delete removes a user from the database.
+func (s Store) Delete(ctx context.Context, userID string) error {
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	DELETE FROM
 		users
-	ORDER BY
-		user_id
-	OFFSET :offset ROWS FETCH NEXT :rows_per_page ROWS ONLY`
+	WHERE
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("deleting userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// orderByFields maps the fields users can be ordered by to their column.
+var orderByFields = map[string]string{
+	"user_id":      "user_id",
+	"name":         "name",
+	"email":        "email",
+	"date_created": "date_created",
+}
+
+// Query retrieves a page of users matching the filter, in the order of the
+// request. It also reports whether there are more users past the page in the
+// direction it was fetched.
+func (s Store) Query(ctx context.Context, filter QueryFilter, req paging.Request) ([]User, bool, error) {
+	column, ok := orderByFields[req.Order.Field]
+	if !ok {
+		return nil, false, fmt.Errorf("field %q can't be ordered by", req.Order.Field)
+	}
+
+	q, data, err := database.Select("*").
+		From("users").
+		Where(database.Filter(filter)...).
+		Page(req, column, "user_id").
+		Build()
+	if err != nil {
+		return nil, false, fmt.Errorf("building query: %w", err)
+	}
 
 	var usrs []User
 	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &usrs); err != nil {
-		return nil, fmt.Errorf("selecting users: %w", err)
+		return nil, false, fmt.Errorf("selecting users: %w", err)
+	}
+
+	return usrs, req.Trim(&usrs), nil
+}
+
+// Count returns the number of users matching the filter.
+func (s Store) Count(ctx context.Context, filter QueryFilter) (int, error) {
+	q, data, err := database.Select("count(*) AS count").
+		From("users").
+		Where(database.Filter(filter)...).
+		Build()
+	if err != nil {
+		return 0, fmt.Errorf("building query: %w", err)
+	}
+
+	var count struct {
+		Count int `db:"count"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &count); err != nil {
+		return 0, fmt.Errorf("counting users: %w", err)
 	}
 
-	return usrs, nil
+	return count.Count, nil
 }
 
 // QueryByID gets the specified user from the database.
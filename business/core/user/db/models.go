@@ -9,11 +9,46 @@ import (
 // User represent the structure we need for moving data
 // between the app and the database.
 type User struct {
-	ID           string         `db:"user_id"`
-	Name         string         `db:"name"`
-	Email        string         `db:"email"`
-	Roles        pq.StringArray `db:"roles"`
-	PasswordHash []byte         `db:"password_hash"`
-	DateCreated  time.Time      `db:"date_created"`
-	DateUpdated  time.Time      `db:"date_updated"`
+	ID              string         `db:"user_id"`
+	Name            string         `db:"name"`
+	Email           string         `db:"email"`
+	Roles           pq.StringArray `db:"roles"`
+	PasswordHash    []byte         `db:"password_hash"`
+	DateCreated     time.Time      `db:"date_created"`
+	DateUpdated     time.Time      `db:"date_updated"`
+	MFASecret       string         `db:"mfa_secret"`
+	MFALastStep     int64          `db:"mfa_last_step"`
+	DateMFAEnabled  *time.Time     `db:"date_mfa_enabled"`
+	EmailVerifiedAt *time.Time     `db:"email_verified_at"`
+}
+
+// QueryFilter holds the fields users can be filtered on. Nil fields are not
+// filtered on.
+type QueryFilter struct {
+	Name             *string    `filter:"name,contains"`
+	Email            *string    `filter:"email,eq"`
+	StartCreatedDate *time.Time `filter:"date_created,gte"`
+	EndCreatedDate   *time.Time `filter:"date_created,lte"`
+}
+
+// RecoveryCode represents a single use code that replaces the TOTP code when
+// the authenticator is lost. Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID       string     `db:"code_id"`
+	UserID   string     `db:"user_id"`
+	CodeHash string     `db:"code_hash"`
+	DateUsed *time.Time `db:"date_used"`
+}
+
+// Token represents a single use token mailed to a user, such as a password
+// reset token. Only the hash of the token is stored. Email records the
+// address the token was sent to.
+type Token struct {
+	TokenHash   string     `db:"token_hash"`
+	UserID      string     `db:"user_id"`
+	Purpose     string     `db:"purpose"`
+	Email       string     `db:"email"`
+	DateCreated time.Time  `db:"date_created"`
+	DateExpires time.Time  `db:"date_expires"`
+	DateUsed    *time.Time `db:"date_used"`
 }
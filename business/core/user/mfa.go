@@ -0,0 +1,293 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/user/db"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/validate"
+	"github.com/Fiiii/WT/foundation/totp"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/jmoiron/sqlx"
+)
+
+// Set of error variables for multi-factor authentication.
+var (
+	ErrMFAEnabled     = errors.New("multi-factor authentication is already enabled")
+	ErrMFANotEnrolled = errors.New("multi-factor authentication is not enrolled")
+	ErrInvalidMFACode = errors.New("multi-factor authentication code is not valid")
+)
+
+const (
+	// mfaIssuer names the service in authenticator apps.
+	mfaIssuer = "WT"
+
+	// mfaPendingTTL is how long a user has to enter the second factor after
+	// their password was accepted.
+	mfaPendingTTL = 5 * time.Minute
+
+	// recoveryCodes is how many recovery codes a user gets.
+	recoveryCodes = 10
+)
+
+// EnrollMFA generates a new TOTP secret for the user. The secret is only
+// used once the user proves their authenticator has it with ConfirmMFA.
+func (c Core) EnrollMFA(ctx context.Context, userID string) (Enrollment, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return Enrollment{}, ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOnly, auth.PermUsersWrite, userID); err != nil {
+		return Enrollment{}, err
+	}
+
+	dbUsr, err := c.store.QueryByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Enrollment{}, ErrNotFound
+		}
+		return Enrollment{}, fmt.Errorf("query: %w", err)
+	}
+
+	if dbUsr.DateMFAEnabled != nil {
+		return Enrollment{}, ErrMFAEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return Enrollment{}, err
+	}
+
+	dbUsr.MFASecret = secret
+	dbUsr.MFALastStep = 0
+	if err := c.store.UpdateMFA(ctx, dbUsr); err != nil {
+		return Enrollment{}, fmt.Errorf("update mfa: %w", err)
+	}
+
+	enr := Enrollment{
+		Secret: secret,
+		URI:    totp.URI(mfaIssuer, dbUsr.Email, secret),
+	}
+
+	return enr, nil
+}
+
+// ConfirmMFA enables multi-factor authentication once the user enters a
+// valid code for the enrolled secret. It returns the recovery codes of the
+// user, which are only ever shown this once.
+func (c Core) ConfirmMFA(ctx context.Context, userID string, code string, now time.Time) ([]string, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOnly, auth.PermUsersWrite, userID); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbUsr, err := store.QueryByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrNotFound
+			}
+			return fmt.Errorf("query: %w", err)
+		}
+
+		switch {
+		case dbUsr.DateMFAEnabled != nil:
+			return ErrMFAEnabled
+		case dbUsr.MFASecret == "":
+			return ErrMFANotEnrolled
+		}
+
+		step, ok := totp.Validate(dbUsr.MFASecret, code, now)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+
+		dbUsr.MFALastStep = step
+		dbUsr.DateMFAEnabled = &now
+		if err := store.UpdateMFA(ctx, dbUsr); err != nil {
+			return fmt.Errorf("update mfa: %w", err)
+		}
+
+		codes, err = c.newRecoveryCodes(ctx, store, userID)
+		return err
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return nil, fmt.Errorf("tran: %w", err)
+	}
+
+	return codes, nil
+}
+
+// VerifyMFA checks the second factor of a user whose password was accepted,
+// either a TOTP code or an unused recovery code. On success it returns the
+// claims Authenticate holds back while the second factor is pending. A TOTP
+// code is accepted only once.
+func (c Core) VerifyMFA(ctx context.Context, userID string, code string, now time.Time) (auth.Claims, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return auth.Claims{}, ErrInvalidID
+	}
+
+	var claims auth.Claims
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbUsr, err := store.QueryByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrNotFound
+			}
+			return fmt.Errorf("query: %w", err)
+		}
+
+		if dbUsr.DateMFAEnabled == nil {
+			return ErrMFANotEnrolled
+		}
+
+		if step, ok := totp.Validate(dbUsr.MFASecret, code, now); ok {
+
+			// Moving the last step forward fails when a concurrent request
+			// already used this code or a later one.
+			if err := store.AdvanceMFAStep(ctx, userID, step); err != nil {
+				if errors.Is(err, database.ErrDBNotFound) {
+					return ErrInvalidMFACode
+				}
+				return fmt.Errorf("advance step: %w", err)
+			}
+
+			claims = newClaims(dbUsr, now)
+			return nil
+		}
+
+		if err := store.UseRecoveryCode(ctx, userID, hashRecoveryCode(code), now); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrInvalidMFACode
+			}
+			return fmt.Errorf("use recovery code: %w", err)
+		}
+
+		claims = newClaims(dbUsr, now)
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return auth.Claims{}, fmt.Errorf("tran: %w", err)
+	}
+
+	return claims, nil
+}
+
+// ResetMFA disables multi-factor authentication for a user who lost both
+// their authenticator and recovery codes. Only admins may reset it.
+func (c Core) ResetMFA(ctx context.Context, userID string) error {
+	if err := validate.CheckID(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.AdminOnly, auth.PermUsersWrite, userID); err != nil {
+		return err
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbUsr, err := store.QueryByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrNotFound
+			}
+			return fmt.Errorf("query: %w", err)
+		}
+
+		dbUsr.MFASecret = ""
+		dbUsr.MFALastStep = 0
+		dbUsr.DateMFAEnabled = nil
+		if err := store.UpdateMFA(ctx, dbUsr); err != nil {
+			return fmt.Errorf("update mfa: %w", err)
+		}
+
+		if err := store.DeleteRecoveryCodes(ctx, userID); err != nil {
+			return fmt.Errorf("delete recovery codes: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// newRecoveryCodes replaces the recovery codes of the user and returns them.
+func (c Core) newRecoveryCodes(ctx context.Context, store db.Store, userID string) ([]string, error) {
+	if err := store.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, fmt.Errorf("delete recovery codes: %w", err)
+	}
+
+	codes := make([]string, recoveryCodes)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("generating recovery code: %w", err)
+		}
+
+		// 16 base32 characters shown in groups of four: abcd-efgh-ijkl-mnop.
+		s := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16]
+
+		rc := db.RecoveryCode{
+			ID:       validate.GenerateID(),
+			UserID:   userID,
+			CodeHash: hashRecoveryCode(codes[i]),
+		}
+		if err := store.CreateRecoveryCode(ctx, rc); err != nil {
+			return nil, fmt.Errorf("create recovery code: %w", err)
+		}
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode returns the hex encoded SHA-256 of a recovery code, ignoring
+// case, spaces and dashes. Recovery codes carry 80 bits of entropy so a fast
+// hash is sufficient.
+func hashRecoveryCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newPendingClaims creates the claims of a token that only proves the password
+// of the user was accepted. It grants no roles and can only be exchanged for
+// real claims with VerifyMFA.
+func newPendingClaims(dbUsr db.User, now time.Time) auth.Claims {
+	return auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        validate.GenerateID(),
+			Subject:   dbUsr.ID,
+			Issuer:    "service project",
+			ExpiresAt: jwt.NewNumericDate(now.UTC().Add(mfaPendingTTL)),
+			IssuedAt:  jwt.NewNumericDate(now.UTC()),
+		},
+		MFAPending: true,
+	}
+}
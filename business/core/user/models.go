@@ -5,17 +5,22 @@ import (
 	"unsafe"
 
 	"github.com/Fiiii/WT/business/core/user/db"
+	"github.com/Fiiii/WT/business/sys/paging"
 )
 
 // User represents an individual user.
 type User struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	Email        string    `json:"email"`
-	Roles        []string  `json:"roles"`
-	PasswordHash []byte    `json:"-"`
-	DateCreated  time.Time `json:"date_created"`
-	DateUpdated  time.Time `json:"date_updated"`
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email"`
+	Roles           []string   `json:"roles"`
+	PasswordHash    []byte     `json:"-"`
+	DateCreated     time.Time  `json:"date_created"`
+	DateUpdated     time.Time  `json:"date_updated"`
+	MFASecret       string     `json:"-"`
+	MFALastStep     int64      `json:"-"`
+	DateMFAEnabled  *time.Time `json:"date_mfa_enabled,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
 }
 
 // NewUser contains information needed to create a new User.
@@ -23,7 +28,7 @@ type NewUser struct {
 	Name            string   `json:"name" validate:"required"`
 	Email           string   `json:"email" validate:"required,email"`
 	Roles           []string `json:"roles" validate:"required"`
-	Password        string   `json:"password" validate:"required"`
+	Password        string   `json:"password" validate:"required,password=Email"`
 	PasswordConfirm string   `json:"password_confirm" validate:"eqfield=Password"`
 }
 
@@ -37,10 +42,48 @@ type UpdateUser struct {
 	Name            *string  `json:"name"`
 	Email           *string  `json:"email" validate:"omitempty,email"`
 	Roles           []string `json:"roles"`
-	Password        *string  `json:"password"`
+	Password        *string  `json:"password" validate:"omitempty,password=Email"`
 	PasswordConfirm *string  `json:"password_confirm" validate:"omitempty,eqfield=Password"`
 }
 
+// QueryFilter holds the fields users can be filtered on. Name matches any
+// part of the name, the dates bound when the users were created.
+type QueryFilter struct {
+	Name             *string    `json:"name" validate:"omitempty,min=1"`
+	Email            *string    `json:"email" validate:"omitempty,email"`
+	StartCreatedDate *time.Time `json:"start_created_date"`
+	EndCreatedDate   *time.Time `json:"end_created_date"`
+}
+
+// Set of fields users can be ordered by.
+const (
+	OrderByID          = "user_id"
+	OrderByName        = "name"
+	OrderByEmail       = "email"
+	OrderByDateCreated = "date_created"
+)
+
+// OrderByFields lists the fields users can be ordered by.
+var OrderByFields = []string{OrderByID, OrderByName, OrderByEmail, OrderByDateCreated}
+
+// DefaultOrderBy is the order of users when none is requested.
+var DefaultOrderBy = paging.Order{Field: OrderByID}
+
+// ResetPassword contains the information needed to set a new password with a
+// password reset token.
+type ResetPassword struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password" validate:"required,password"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
+
+// Enrollment represents a TOTP secret waiting to be confirmed. The URI is
+// usually shown as a QR code for authenticator apps to scan.
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
 // =============================================================================
 
 func toUser(dbUsr db.User) User {
@@ -48,6 +91,22 @@ func toUser(dbUsr db.User) User {
 	return *pu
 }
 
+// cursor returns the position of the user in a list sorted by the order.
+func cursor(usr User, order paging.Order) paging.Cursor {
+	var value string
+	switch order.Field {
+	case OrderByName:
+		value = usr.Name
+	case OrderByEmail:
+		value = usr.Email
+	case OrderByDateCreated:
+		value = usr.DateCreated.Format(time.RFC3339Nano)
+	default:
+		value = usr.ID
+	}
+	return paging.NewCursor(order, value, usr.ID)
+}
+
 func toUserSlice(dbUsrs []db.User) []User {
 	users := make([]User, len(dbUsrs))
 	for i, dbUsr := range dbUsrs {
@@ -0,0 +1,274 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fiiii/WT/business/core/user/db"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/password"
+	"github.com/Fiiii/WT/business/sys/validate"
+	"github.com/Fiiii/WT/foundation/mailer"
+	"github.com/Fiiii/WT/foundation/web"
+	"github.com/jmoiron/sqlx"
+)
+
+// Set of error variables for password reset and email verification.
+var (
+	ErrInvalidToken  = errors.New("token is not valid or has expired")
+	ErrEmailVerified = errors.New("email is already verified")
+)
+
+// Purposes of the single use tokens mailed to users.
+const (
+	purposeReset  = "password_reset"
+	purposeVerify = "email_verification"
+)
+
+// Lifetimes of the single use tokens mailed to users.
+const (
+	resetTTL  = time.Hour
+	verifyTTL = 24 * time.Hour
+)
+
+// resetTimeout bounds the work done in the background for a password reset
+// request.
+const resetTimeout = 30 * time.Second
+
+// RequestPasswordReset mails a password reset token to the user with the
+// email. The work is done in the background, so neither the response time
+// nor a failure to deliver the mail tells callers which emails have an
+// account. Failures are logged.
+func (c Core) RequestPasswordReset(ctx context.Context, email string, now time.Time) {
+	traceID := web.GetTraceID(ctx)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), resetTimeout)
+		defer cancel()
+
+		if err := c.sendReset(ctx, email, now); err != nil {
+			c.log.Errorw("password reset", "traceid", traceID, "ERROR", err)
+		}
+	}()
+}
+
+// sendReset mails a password reset token to the user with the email, if
+// there is one.
+func (c Core) sendReset(ctx context.Context, email string, now time.Time) error {
+	dbUsr, err := c.store.QueryByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return nil
+		}
+		return fmt.Errorf("query: %w", err)
+	}
+
+	token, err := c.newToken(ctx, dbUsr, purposeReset, resetTTL, now)
+	if err != nil {
+		return err
+	}
+
+	msg := mailer.Message{
+		To:      dbUsr.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Use the following token to choose a new password. It expires in an hour.\n\n%s\n\n"+
+			"If you did not ask to reset your password you can ignore this message.", token),
+	}
+	if err := c.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("sending reset: %w", err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password for the user the token was mailed to and
+// returns the ID of that user. Every token can be used once.
+func (c Core) ResetPassword(ctx context.Context, rp ResetPassword, now time.Time) (string, error) {
+	if err := validate.Check(rp); err != nil {
+		return "", fmt.Errorf("validating data: %w", err)
+	}
+
+	hash, err := password.Hash(rp.Password)
+	if err != nil {
+		return "", fmt.Errorf("generating password hash: %w", err)
+	}
+
+	var userID string
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbTkn, err := store.UseToken(ctx, hashToken(rp.Token), purposeReset, now)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrInvalidToken
+			}
+			return fmt.Errorf("use token: %w", err)
+		}
+
+		dbUsr, err := store.QueryByID(ctx, dbTkn.UserID)
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+
+		// The request carries no email, so the password can only be checked
+		// against it once the user is known.
+		if err := validate.CheckPassword(rp.Password, dbUsr.Email); err != nil {
+			return validate.FieldErrors{{Field: "password", Error: err.Error()}}
+		}
+
+		// The token proves the user can read mail sent to the address.
+		dbUsr.PasswordHash = hash
+		if dbUsr.EmailVerifiedAt == nil && dbUsr.Email == dbTkn.Email {
+			dbUsr.EmailVerifiedAt = &now
+		}
+		dbUsr.DateUpdated = now
+
+		if err := store.Update(ctx, dbUsr); err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+
+		userID = dbUsr.ID
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return "", fmt.Errorf("tran: %w", err)
+	}
+
+	return userID, nil
+}
+
+// RequestEmailVerification mails an email verification token to the current
+// email of the user. Users may request it for themselves, admins for anyone.
+func (c Core) RequestEmailVerification(ctx context.Context, userID string, now time.Time) error {
+	if err := validate.CheckID(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermUsersWrite, userID); err != nil {
+		return err
+	}
+
+	dbUsr, err := c.store.QueryByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("query: %w", err)
+	}
+
+	if dbUsr.EmailVerifiedAt != nil {
+		return ErrEmailVerified
+	}
+
+	token, err := c.newToken(ctx, dbUsr, purposeVerify, verifyTTL, now)
+	if err != nil {
+		return err
+	}
+
+	msg := mailer.Message{
+		To:      dbUsr.Email,
+		Subject: "Verify your email",
+		Body:    fmt.Sprintf("Use the following token to verify your email. It expires in a day.\n\n%s", token),
+	}
+	if err := c.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("sending verification: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email of the user the token was mailed to as
+// verified. The token is only good for the address it was mailed to.
+func (c Core) VerifyEmail(ctx context.Context, token string, now time.Time) error {
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbTkn, err := store.UseToken(ctx, hashToken(token), purposeVerify, now)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrInvalidToken
+			}
+			return fmt.Errorf("use token: %w", err)
+		}
+
+		dbUsr, err := store.QueryByID(ctx, dbTkn.UserID)
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+
+		if dbUsr.Email != dbTkn.Email {
+			return ErrInvalidToken
+		}
+
+		dbUsr.EmailVerifiedAt = &now
+		dbUsr.DateUpdated = now
+
+		if err := store.Update(ctx, dbUsr); err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// newToken stores a new single use token for the user, replacing the unused
+// ones with the same purpose, and returns it.
+func (c Core) newToken(ctx context.Context, dbUsr db.User, purpose string, ttl time.Duration, now time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	dbTkn := db.Token{
+		TokenHash:   hashToken(token),
+		UserID:      dbUsr.ID,
+		Purpose:     purpose,
+		Email:       dbUsr.Email,
+		DateCreated: now,
+		DateExpires: now.Add(ttl),
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		if err := store.DeleteUnusedTokens(ctx, dbUsr.ID, purpose); err != nil {
+			return fmt.Errorf("delete unused: %w", err)
+		}
+
+		if err := store.CreateToken(ctx, dbTkn); err != nil {
+			return fmt.Errorf("create token: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return "", fmt.Errorf("tran: %w", err)
+	}
+
+	return token, nil
+}
+
+// hashToken returns the hex encoded SHA-256 of a mailed token. The tokens are
+// random so a fast hash is sufficient.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
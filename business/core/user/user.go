@@ -9,11 +9,13 @@ import (
 	"github.com/Fiiii/WT/business/core/user/db"
 	"github.com/Fiiii/WT/business/sys/auth"
 	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/paging"
+	"github.com/Fiiii/WT/business/sys/password"
 	"github.com/Fiiii/WT/business/sys/validate"
+	"github.com/Fiiii/WT/foundation/mailer"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Set of error variables for CRUD operations.
@@ -21,27 +23,48 @@ var (
 	ErrNotFound              = errors.New("user not found")
 	ErrInvalidID             = errors.New("ID is not in its proper form")
 	ErrAuthenticationFailure = errors.New("authentication failed")
+	ErrEmailTaken            = errors.New("email is already in use")
 )
 
+// emailConstraint is the unique constraint on the email of users.
+const emailConstraint = "users_email_key"
+
+// accessTTL is how long an access token is valid. Clients exchange a refresh
+// token for a new access token once it expires.
+const accessTTL = time.Hour
+
 // Core manages the set of API's for user access.
 type Core struct {
-	store db.Store
+	log    *zap.SugaredLogger
+	store  db.Store
+	mailer mailer.Mailer
 }
 
-//NewCore constructs a core for user api access.
-func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB) Core {
+//NewCore constructs a core for user api access. The mailer delivers password
+// reset and email verification messages.
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB, mail mailer.Mailer) Core {
 	return Core{
-		store: db.NewStore(log, sqlxDB),
+		log:    log,
+		store:  db.NewStore(log, sqlxDB),
+		mailer: mail,
 	}
 }
 
+// Tran returns a core running against the transaction, so it can take part
+// in a business operation spanning several cores. Transactions the core
+// starts itself run within savepoints of it.
+func (c Core) Tran(tx sqlx.ExtContext) Core {
+	c.store = c.store.Tran(tx)
+	return c
+}
+
 // Create inserts a new user into the database.
 func (c Core) Create(ctx context.Context, nu NewUser, now time.Time) (User, error) {
 	if err := validate.Check(nu); err != nil {
 		return User{}, fmt.Errorf("validating data: %w", err)
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(nu.Password), bcrypt.DefaultCost)
+	hash, err := password.Hash(nu.Password)
 	if err != nil {
 		return User{}, fmt.Errorf("generating password hash: %w", err)
 	}
@@ -65,13 +88,17 @@ func (c Core) Create(ctx context.Context, nu NewUser, now time.Time) (User, erro
 	}
 
 	if err := c.store.WithinTran(ctx, tran); err != nil {
+		if database.IsConstraint(err, emailConstraint) {
+			return User{}, ErrEmailTaken
+		}
 		return User{}, fmt.Errorf("tran: %w", err)
 	}
 
 	return toUser(dbUsr), nil
 }
 
-// Update replaces a user document in the database.
+// Update replaces a user document in the database. Users may update
+// themselves, only admins may update others or change roles.
 func (c Core) Update(ctx context.Context, userID string, uu UpdateUser, now time.Time) error {
 	if err := validate.CheckID(userID); err != nil {
 		return ErrInvalidID
@@ -81,6 +108,18 @@ func (c Core) Update(ctx context.Context, userID string, uu UpdateUser, now time
 		return fmt.Errorf("validating data: %w", err)
 	}
 
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermUsersWrite, userID); err != nil {
+		return err
+	}
+
+	// Only an admin may change roles, otherwise users could grant themselves
+	// more privileges.
+	if uu.Roles != nil {
+		if err := auth.Enforce(ctx, auth.AdminOnly, auth.PermUsersWrite, userID); err != nil {
+			return err
+		}
+	}
+
 	dbUsr, err := c.store.QueryByID(ctx, userID)
 	if err != nil {
 		if errors.Is(err, database.ErrDBNotFound) {
@@ -92,14 +131,20 @@ func (c Core) Update(ctx context.Context, userID string, uu UpdateUser, now time
 	if uu.Name != nil {
 		dbUsr.Name = *uu.Name
 	}
-	if uu.Email != nil {
+	if uu.Email != nil && *uu.Email != dbUsr.Email {
 		dbUsr.Email = *uu.Email
+		dbUsr.EmailVerifiedAt = nil
 	}
 	if uu.Roles != nil {
 		dbUsr.Roles = uu.Roles
 	}
 	if uu.Password != nil {
-		pw, err := bcrypt.GenerateFromPassword([]byte(*uu.Password), bcrypt.DefaultCost)
+		// The request only carries the email when it changes it.
+		if err := validate.CheckPassword(*uu.Password, dbUsr.Email); err != nil {
+			return validate.FieldErrors{{Field: "password", Error: err.Error()}}
+		}
+
+		pw, err := password.Hash(*uu.Password)
 		if err != nil {
 			return fmt.Errorf("generating password hash: %w", err)
 		}
@@ -108,18 +153,26 @@ func (c Core) Update(ctx context.Context, userID string, uu UpdateUser, now time
 	dbUsr.DateUpdated = now
 
 	if err := c.store.Update(ctx, dbUsr); err != nil {
+		if database.IsConstraint(err, emailConstraint) {
+			return ErrEmailTaken
+		}
 		return fmt.Errorf("udpate: %w", err)
 	}
 
 	return nil
 }
 
-// Delete removes a user from the database.
+// Delete removes a user from the database. Users may delete themselves, only
+// admins may delete others.
 func (c Core) Delete(ctx context.Context, userID string) error {
 	if err := validate.CheckID(userID); err != nil {
 		return ErrInvalidID
 	}
 
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermUsersWrite, userID); err != nil {
+		return err
+	}
+
 	if err := c.store.Delete(ctx, userID); err != nil {
 		return fmt.Errorf("delete: %w", err)
 	}
@@ -127,14 +180,33 @@ func (c Core) Delete(ctx context.Context, userID string) error {
 	return nil
 }
 
-// Query retrieves a list of existing users from the database.
-func (c Core) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]User, error) {
-	dbUsers, err := c.store.Query(ctx, pageNumber, rowsPerPage)
+// Query retrieves a page of users matching the filter. The total number of
+// matching users is only counted when the request asks for it.
+func (c Core) Query(ctx context.Context, filter QueryFilter, req paging.Request) ([]User, paging.Page, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, paging.Page{}, fmt.Errorf("validating filter: %w", err)
+	}
+
+	dbUsers, more, err := c.store.Query(ctx, db.QueryFilter(filter), req)
 	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
+		return nil, paging.Page{}, fmt.Errorf("query: %w", err)
 	}
+	usrs := toUserSlice(dbUsers)
 
-	return toUserSlice(dbUsers), nil
+	var page paging.Page
+	if n := len(usrs); n > 0 {
+		page = paging.NewPage(req, cursor(usrs[0], req.Order), cursor(usrs[n-1], req.Order), more)
+	}
+
+	if req.Total {
+		total, err := c.store.Count(ctx, db.QueryFilter(filter))
+		if err != nil {
+			return nil, paging.Page{}, fmt.Errorf("count: %w", err)
+		}
+		page.Total = &total
+	}
+
+	return usrs, page, nil
 }
 
 // QueryByID gets the specified user from the database.
@@ -143,6 +215,10 @@ func (c Core) QueryByID(ctx context.Context, userID string) (User, error) {
 		return User{}, ErrInvalidID
 	}
 
+	if err := auth.Enforce(ctx, auth.OwnerOrAdmin, auth.PermUsersRead, userID); err != nil {
+		return User{}, err
+	}
+
 	dbUsr, err := c.store.QueryByID(ctx, userID)
 	if err != nil {
 		if errors.Is(err, database.ErrDBNotFound) {
@@ -175,33 +251,120 @@ func (c Core) QueryByEmail(ctx context.Context, email string) (User, error) {
 
 // Authenticate finds a user by their email and verifies their password. On
 // success, it returns a Claims User representing this user. The claims can be
-// used to generate a token for future authentication.
-func (c Core) Authenticate(ctx context.Context, now time.Time, email, password string) (auth.Claims, error) {
+// used to generate a token for future authentication. An unknown email and a
+// wrong password both fail with ErrAuthenticationFailure. For users with
+// multi-factor authentication the claims are pending until VerifyMFA.
+func (c Core) Authenticate(ctx context.Context, now time.Time, email, pass string) (auth.Claims, error) {
 	dbUsr, err := c.store.QueryByEmail(ctx, email)
 	if err != nil {
 		if errors.Is(err, database.ErrDBNotFound) {
-			return auth.Claims{}, ErrNotFound
+
+			// Spend the same time as a real comparison so the response
+			// time does not reveal whether the email exists.
+			password.CompareDummy(pass)
+			return auth.Claims{}, ErrAuthenticationFailure
 		}
 		return auth.Claims{}, fmt.Errorf("query: %w", err)
 	}
 
-	// Compare the provided password with the saved hash. Use the bcrypt
-	// comparison function so it is cryptographically secure.
-	if err := bcrypt.CompareHashAndPassword(dbUsr.PasswordHash, []byte(password)); err != nil {
-		return auth.Claims{}, ErrAuthenticationFailure
+	// Compare the provided password with the saved hash. The comparison is
+	// constant time so it is cryptographically secure.
+	if err := password.Compare(dbUsr.PasswordHash, pass); err != nil {
+		if errors.Is(err, password.ErrMismatch) {
+			return auth.Claims{}, ErrAuthenticationFailure
+		}
+		return auth.Claims{}, fmt.Errorf("compare: %w", err)
+	}
+
+	// The plain password is only known now, so this is the chance to upgrade
+	// a hash generated with a weaker target. Failing to do so is not a reason
+	// to fail the login.
+	if password.NeedsRehash(dbUsr.PasswordHash) {
+		c.rehash(ctx, dbUsr, pass)
+	}
+
+	// Users with multi-factor authentication get a token which is only good
+	// for entering the second factor.
+	if dbUsr.DateMFAEnabled != nil {
+		return newPendingClaims(dbUsr, now), nil
 	}
 
 	// If we are this far the request is valid. Create some claims for the user
 	// and generate their token.
-	claims := auth.Claims{
+	return newClaims(dbUsr, now), nil
+}
+
+// Claims builds the claims of the specified user, the same way Authenticate
+// does, for issuing a new access token after a refresh token was exchanged.
+func (c Core) Claims(ctx context.Context, userID string, now time.Time) (auth.Claims, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return auth.Claims{}, ErrInvalidID
+	}
+
+	dbUsr, err := c.store.QueryByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return auth.Claims{}, ErrNotFound
+		}
+		return auth.Claims{}, fmt.Errorf("query: %w", err)
+	}
+
+	return newClaims(dbUsr, now), nil
+}
+
+// LoginClaims builds the claims of the specified user after they signed in
+// without a password, like through an OIDC provider. As with Authenticate,
+// users with multi-factor authentication get claims which are only good for
+// entering the second factor.
+func (c Core) LoginClaims(ctx context.Context, userID string, now time.Time) (auth.Claims, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return auth.Claims{}, ErrInvalidID
+	}
+
+	dbUsr, err := c.store.QueryByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return auth.Claims{}, ErrNotFound
+		}
+		return auth.Claims{}, fmt.Errorf("query: %w", err)
+	}
+
+	if dbUsr.DateMFAEnabled != nil {
+		return newPendingClaims(dbUsr, now), nil
+	}
+
+	return newClaims(dbUsr, now), nil
+}
+
+// =============================================================================
+
+// rehash replaces the password hash of the user with one generated with the
+// current target. Only the hash is written, and only while it is the one the
+// login checked, so changes made to the user in the meantime are kept.
+// Errors are logged since the login succeeded regardless.
+func (c Core) rehash(ctx context.Context, dbUsr db.User, pass string) {
+	hash, err := password.Hash(pass)
+	if err != nil {
+		c.log.Errorw("rehash", "userID", dbUsr.ID, "ERROR", err)
+		return
+	}
+
+	if err := c.store.UpdatePasswordHash(ctx, dbUsr.ID, dbUsr.PasswordHash, hash); err != nil {
+		c.log.Errorw("rehash", "userID", dbUsr.ID, "ERROR", err)
+	}
+}
+
+// newClaims creates the claims of an access token for the user. Every token
+// gets a unique id so it can be revoked before it expires.
+func newClaims(dbUsr db.User, now time.Time) auth.Claims {
+	return auth.Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        validate.GenerateID(),
 			Subject:   dbUsr.ID,
 			Issuer:    "service project",
-			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(now.UTC().Add(accessTTL)),
+			IssuedAt:  jwt.NewNumericDate(now.UTC()),
 		},
 		Roles: dbUsr.Roles,
 	}
-
-	return claims, nil
 }
@@ -1,93 +1,410 @@
-package user
+package user_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/Fiiii/WT/foundation/logger"
+	"github.com/Fiiii/WT/business/core/user"
+	"github.com/Fiiii/WT/business/data/dbtest"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/password"
+	"github.com/Fiiii/WT/business/sys/validate"
+	"github.com/Fiiii/WT/foundation/docker"
+	"github.com/Fiiii/WT/foundation/mailer"
+	"github.com/Fiiii/WT/foundation/totp"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/go-cmp/cmp"
 )
 
-// Success and failure markers.
-const (
-	Success = "\u2713"
-	Failed  = "\u2717"
-)
+var c *docker.Container
 
-func TestUser(t *testing.T) {
-	log, err := logger.New("TEST")
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
 	if err != nil {
-		t.Fatalf("creating logger error")
-
+		fmt.Println(err)
+		return
 	}
-	store := NewCore(log, nil)
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+func TestUser(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testuser")
+	t.Cleanup(teardown)
+
+	core := user.NewCore(log, db, mailer.NewMemory())
 
 	t.Log("Given the need to work with User records.")
 	{
 		testID := 0
 		t.Logf("\tTest %d:\tWhen handling single User.", testID)
 		{
-			ctx := context.Background()
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "5cf37266-3473-4006-984f-9325122678b7"},
+				Roles:            []string{auth.RoleAdmin},
+			})
 			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
 
-			nu := NewUser{
-				Name:  "Fii",
-				Email: "fii@fii.com",
-				Roles: []string{"Admin"},
+			nu := user.NewUser{
+				Name:            "Fii",
+				Email:           "fii@fii.com",
+				Roles:           []string{"ADMIN"},
+				Password:        "Gophers#2021",
+				PasswordConfirm: "Gophers#2021",
 			}
 
-			user, err := store.Create(ctx, nu, now)
+			usr, err := core.Create(ctx, nu, now)
 			if err != nil {
-				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", Failed, testID, err)
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
 			}
-			t.Logf("\t%s\tTest %d:\tShould be able to create user.", Success, testID)
+			t.Logf("\t%s\tTest %d:\tShould be able to create user.", dbtest.Success, testID)
 
-			saved, err := store.Query(ctx, user.ID, 1)
+			saved, err := core.QueryByID(ctx, usr.ID)
 			if err != nil {
-				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve user by ID: %s.", Failed, testID, err)
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve user by ID: %s.", dbtest.Failed, testID, err)
 			}
-			t.Logf("\t%s\tTest %d:\tShould be able to retrieve user by ID.", Success, testID)
+			t.Logf("\t%s\tTest %d:\tShould be able to retrieve user by ID.", dbtest.Success, testID)
 
-			if diff := cmp.Diff(user, saved); diff != "" {
-				t.Fatalf("\t%s\tTest %d:\tShould get back the same user. Diff:\n%s", Failed, testID, diff)
+			if diff := cmp.Diff(usr, saved); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the same user. Diff:\n%s", dbtest.Failed, testID, diff)
 			}
-			t.Logf("\t%s\tTest %d:\tShould get back the same user.", Success, testID)
+			t.Logf("\t%s\tTest %d:\tShould get back the same user.", dbtest.Success, testID)
 
-			upd := UpdateUser{
-				Name:  StringPointer("Updated Fii"),
-				Email: StringPointer("updated@fii.com"),
+			if _, err := core.Create(ctx, nu, now); !errors.Is(err, user.ErrEmailTaken) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to create a user with the same email : %v.", dbtest.Failed, testID, err)
 			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to create a user with the same email.", dbtest.Success, testID)
 
-			if err := store.Update(ctx, user.ID, upd, now); err != nil {
-				t.Fatalf("\t%s\tTest %d:\tShould be able to update user : %s.", Failed, testID, err)
+			upd := user.UpdateUser{
+				Name:  dbtest.StringPointer("Updated Fii"),
+				Email: dbtest.StringPointer("updated@fii.com"),
 			}
-			t.Logf("\t%s\tTest %d:\tShould be able to update user.", Success, testID)
+
+			if err := core.Update(ctx, usr.ID, upd, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update user : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to update user.", dbtest.Success, testID)
+
+			saved, err = core.QueryByEmail(ctx, *upd.Email)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve user by Email : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to retrieve user by Email.", dbtest.Success, testID)
 
 			if saved.Name != *upd.Name {
-				t.Errorf("\t%s\tTest %d:\tShould be able to see updates to Name.", Failed, testID)
+				t.Errorf("\t%s\tTest %d:\tShould be able to see updates to Name.", dbtest.Failed, testID)
 				t.Logf("\t\tTest %d:\tGot: %v", testID, saved.Name)
 				t.Logf("\t\tTest %d:\tExp: %v", testID, *upd.Name)
 			} else {
-				t.Logf("\t%s\tTest %d:\tShould be able to see updates to Name.", Success, testID)
+				t.Logf("\t%s\tTest %d:\tShould be able to see updates to Name.", dbtest.Success, testID)
 			}
 
 			if saved.Email != *upd.Email {
-				t.Errorf("\t%s\tTest %d:\tShould be able to see updates to Email.", Failed, testID)
+				t.Errorf("\t%s\tTest %d:\tShould be able to see updates to Email.", dbtest.Failed, testID)
 				t.Logf("\t\tTest %d:\tGot: %v", testID, saved.Email)
 				t.Logf("\t\tTest %d:\tExp: %v", testID, *upd.Email)
 			} else {
-				t.Logf("\t%s\tTest %d:\tShould be able to see updates to Email.", Success, testID)
+				t.Logf("\t%s\tTest %d:\tShould be able to see updates to Email.", dbtest.Success, testID)
+			}
+
+			same := user.UpdateUser{
+				Password:        dbtest.StringPointer("Updated@fii.com"),
+				PasswordConfirm: dbtest.StringPointer("Updated@fii.com"),
+			}
+			var fe validate.FieldErrors
+			if err := core.Update(ctx, usr.ID, same, now); !errors.As(err, &fe) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use the email as the password : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use the email as the password.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, usr.ID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete user.", dbtest.Success, testID)
+
+			_, err = core.QueryByID(ctx, usr.ID)
+			if !errors.Is(err, user.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve deleted user : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to retrieve deleted user.", dbtest.Success, testID)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testauthenticate")
+	t.Cleanup(teardown)
+
+	core := user.NewCore(log, db, mailer.NewMemory())
+
+	t.Log("Given the need to authenticate User credentials.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling the seeded admin User.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			claims, err := core.Authenticate(ctx, now, "admin@example.com", "gophers")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to authenticate : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to authenticate.", dbtest.Success, testID)
+
+			if claims.Subject != "5cf37266-3473-4006-984f-9325122678b7" {
+				t.Fatalf("\t%s\tTest %d:\tShould get claims for the admin : got %q.", dbtest.Failed, testID, claims.Subject)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get claims for the admin.", dbtest.Success, testID)
+
+			if _, err := core.Authenticate(ctx, now, "admin@example.com", "wrong"); !errors.Is(err, user.ErrAuthenticationFailure) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to authenticate with a wrong password : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to authenticate with a wrong password.", dbtest.Success, testID)
+
+			if _, err := core.Authenticate(ctx, now, "nobody@example.com", "gophers"); !errors.Is(err, user.ErrAuthenticationFailure) {
+				t.Fatalf("\t%s\tTest %d:\tShould fail the same way for an unknown email : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould fail the same way for an unknown email.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen the password hash target is raised.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			t.Cleanup(func() { password.SetTarget(password.DefaultTarget) })
+			if err := password.SetTarget(password.Target{Algorithm: password.AlgBcrypt, Cost: 11}); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to raise the target : %s.", dbtest.Failed, testID, err)
+			}
+
+			if _, err := core.Authenticate(ctx, now, "admin@example.com", "gophers"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to authenticate : %s.", dbtest.Failed, testID, err)
+			}
+
+			usr, err := core.QueryByEmail(ctx, "admin@example.com")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve the user : %s.", dbtest.Failed, testID, err)
+			}
+			if password.NeedsRehash(usr.PasswordHash) {
+				t.Fatalf("\t%s\tTest %d:\tShould rehash the password on login : %s.", dbtest.Failed, testID, usr.PasswordHash[:7])
+			}
+			t.Logf("\t%s\tTest %d:\tShould rehash the password on login.", dbtest.Success, testID)
+
+			if _, err := core.Authenticate(ctx, now, "admin@example.com", "gophers"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould authenticate with the new hash : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould authenticate with the new hash.", dbtest.Success, testID)
+		}
+	}
+}
+
+func TestMFA(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testmfa")
+	t.Cleanup(teardown)
+
+	core := user.NewCore(log, db, mailer.NewMemory())
+
+	t.Log("Given the need to protect a User with a second factor.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen enrolling the seeded admin User.", testID)
+		{
+			const adminID = "5cf37266-3473-4006-984f-9325122678b7"
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: adminID},
+				Roles:            []string{auth.RoleAdmin},
+			})
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			enr, err := core.EnrollMFA(ctx, adminID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to enroll : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to enroll.", dbtest.Success, testID)
+
+			code, err := totp.Code(enr.Secret, totp.Step(now))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to generate a code : %s.", dbtest.Failed, testID, err)
+			}
+
+			recovery, err := core.ConfirmMFA(ctx, adminID, code, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to confirm : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to confirm.", dbtest.Success, testID)
+
+			claims, err := core.Authenticate(ctx, now, "admin@example.com", "gophers")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to authenticate : %s.", dbtest.Failed, testID, err)
+			}
+			if !claims.MFAPending || len(claims.Roles) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould get pending claims without roles : %+v.", dbtest.Failed, testID, claims)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get pending claims without roles.", dbtest.Success, testID)
+
+			claims, err = core.LoginClaims(ctx, adminID, now)
+			if err != nil || !claims.MFAPending || len(claims.Roles) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould get pending claims when signing in without a password : %+v %v.", dbtest.Failed, testID, claims, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get pending claims when signing in without a password.", dbtest.Success, testID)
+
+			if _, err := core.VerifyMFA(ctx, adminID, code, now); !errors.Is(err, user.ErrInvalidMFACode) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to reuse the confirmation code : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to reuse the confirmation code.", dbtest.Success, testID)
+
+			later := now.Add(time.Minute)
+			code, err = totp.Code(enr.Secret, totp.Step(later))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to generate a code : %s.", dbtest.Failed, testID, err)
+			}
+
+			claims, err = core.VerifyMFA(ctx, adminID, code, later)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to verify a code : %s.", dbtest.Failed, testID, err)
+			}
+			if claims.MFAPending || !claims.Authorized(auth.RoleAdmin) {
+				t.Fatalf("\t%s\tTest %d:\tShould get the admin claims : %+v.", dbtest.Failed, testID, claims)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to verify a code.", dbtest.Success, testID)
+
+			if _, err := core.VerifyMFA(ctx, adminID, recovery[0], later); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to use a recovery code : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to use a recovery code.", dbtest.Success, testID)
+
+			if _, err := core.VerifyMFA(ctx, adminID, recovery[0], later); !errors.Is(err, user.ErrInvalidMFACode) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to reuse a recovery code : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to reuse a recovery code.", dbtest.Success, testID)
+
+			if err := core.ResetMFA(ctx, adminID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reset : %s.", dbtest.Failed, testID, err)
+			}
+
+			claims, err = core.Authenticate(ctx, now, "admin@example.com", "gophers")
+			if err != nil || claims.MFAPending {
+				t.Fatalf("\t%s\tTest %d:\tShould authenticate without a second factor after a reset : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould authenticate without a second factor after a reset.", dbtest.Success, testID)
+		}
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testpasswordreset")
+	t.Cleanup(teardown)
+
+	mail := mailer.NewMemory()
+	core := user.NewCore(log, db, mail)
+
+	t.Log("Given the need to recover accounts and verify emails.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling the seeded admin User.", testID)
+		{
+			const adminID = "5cf37266-3473-4006-984f-9325122678b7"
+			ctx := auth.SetClaims(context.Background(), auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: adminID},
+				Roles:            []string{auth.RoleAdmin},
+			})
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			core.RequestPasswordReset(ctx, "nobody@example.com", now)
+			core.RequestPasswordReset(ctx, "admin@example.com", now)
+
+			msg, ok := awaitMail(mail, "admin@example.com")
+			if !ok {
+				t.Fatalf("\t%s\tTest %d:\tShould mail the reset token.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould mail the reset token.", dbtest.Success, testID)
+
+			if n := len(mail.Messages()); n != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT mail an unknown email : got %d messages.", dbtest.Failed, testID, n)
+			}
+			t.Logf("\t%s\tTest %d:\tShould accept an unknown email without mailing it.", dbtest.Success, testID)
+
+			rp := user.ResetPassword{
+				Token:           mailedToken(msg.Body),
+				Password:        "new gophers",
+				PasswordConfirm: "new gophers",
+			}
+
+			same := user.ResetPassword{Token: rp.Token, Password: "Admin@example.com", PasswordConfirm: "Admin@example.com"}
+			var fe validate.FieldErrors
+			if _, err := core.ResetPassword(ctx, same, now); !errors.As(err, &fe) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use the email as the password : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use the email as the password.", dbtest.Success, testID)
+
+			if _, err := core.ResetPassword(ctx, rp, now.Add(2*time.Hour)); !errors.Is(err, user.ErrInvalidToken) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use an expired token : %s.", dbtest.Failed, testID, err)
 			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use an expired token.", dbtest.Success, testID)
 
-			if err := store.Delete(ctx, user.ID); err != nil {
-				t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", Failed, testID, err)
+			userID, err := core.ResetPassword(ctx, rp, now)
+			if err != nil || userID != adminID {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reset the password : %v.", dbtest.Failed, testID, err)
 			}
-			t.Logf("\t%s\tTest %d:\tShould be able to delete user.", Success, testID)
+			t.Logf("\t%s\tTest %d:\tShould be able to reset the password.", dbtest.Success, testID)
+
+			if _, err := core.ResetPassword(ctx, rp, now); !errors.Is(err, user.ErrInvalidToken) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to reuse the token : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to reuse the token.", dbtest.Success, testID)
+
+			if _, err := core.Authenticate(ctx, now, "admin@example.com", "new gophers"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould authenticate with the new password : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould authenticate with the new password.", dbtest.Success, testID)
+
+			// The reset proved the email, so verify it again after a change.
+			email := "fii@example.com"
+			if err := core.Update(ctx, adminID, user.UpdateUser{Email: &email}, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to change the email : %s.", dbtest.Failed, testID, err)
+			}
+
+			if err := core.RequestEmailVerification(ctx, adminID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to request verification : %s.", dbtest.Failed, testID, err)
+			}
+			msg, ok = mail.Last(email)
+			if !ok {
+				t.Fatalf("\t%s\tTest %d:\tShould mail the verification token.", dbtest.Failed, testID)
+			}
+
+			if err := core.VerifyEmail(ctx, mailedToken(msg.Body), now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to verify the email : %s.", dbtest.Failed, testID, err)
+			}
+
+			usr, err := core.QueryByID(ctx, adminID)
+			if err != nil || usr.EmailVerifiedAt == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould mark the email verified : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to verify the email.", dbtest.Success, testID)
 		}
 	}
 }
 
-func StringPointer(s string) *string {
-	return &s
+// mailedToken extracts the token from a mailed message, which is placed on a
+// line of its own after the first paragraph.
+func mailedToken(body string) string {
+	return strings.Split(body, "\n")[2]
+}
+
+// awaitMail waits for a message mailed to the recipient in the background.
+func awaitMail(mail *mailer.Memory, to string) (mailer.Message, bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if msg, ok := mail.Last(to); ok {
+			return msg, true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return mailer.Message{}, false
 }
@@ -5,16 +5,19 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
 
+	dbUser "github.com/Fiiii/WT/business/core/user/db"
 	dbschema "github.com/Fiiii/WT/business/data/schema"
 	"github.com/Fiiii/WT/business/sys/auth"
 	"github.com/Fiiii/WT/business/sys/database"
 	"github.com/Fiiii/WT/foundation/docker"
 	"github.com/Fiiii/WT/foundation/keystore"
-	dbUser "github.com/fiiii/WT/business/core/user/db"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -37,6 +40,11 @@ func StartDB() (*docker.Container, error) {
 	return docker.StartContainer(image, port, args...)
 }
 
+// StopDB stops a running database instance.
+func StopDB(c *docker.Container) {
+	docker.StopContainer(c.ID)
+}
+
 // NewUnit creates a test database inside a Docker container. It creates the
 // required table structure but the database is otherwise empty. It returns
 // the database to use as well as a function to call at the end of the test.
@@ -141,7 +149,7 @@ func NewIntegration(t *testing.T, c *docker.Container, dbName string) *Test {
 	}
 
 	// Build an authenticator using this private key and id for the key store.
-	auth, err := auth.New(keyID, keystore.NewMap(map[string]*rsa.PrivateKey{keyID: privateKey}))
+	auth, err := auth.New(keyID, keystore.NewMap(map[string]crypto.Signer{keyID: privateKey}))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -169,6 +177,7 @@ func (test *Test) Token(email, pass string) string {
 
 	claims := auth.Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			Subject:   dbUsr.ID,
 			Issuer:    "service project",
 			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Hour)),
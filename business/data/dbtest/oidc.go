@@ -0,0 +1,236 @@
+package dbtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/foundation/keystore"
+	"github.com/Fiiii/WT/foundation/oidc"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// OIDCAccount represents an account at the fake OIDC provider.
+type OIDCAccount struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// OIDCProvider is an in-process OpenID Connect provider for tests. There is
+// no consent page: the account selected with SignIn is signed in as soon as
+// the user agent reaches the authorization endpoint.
+type OIDCProvider struct {
+	URL      string
+	ClientID string
+
+	server  *httptest.Server
+	keys    *keystore.KeyStore
+	mu      sync.Mutex
+	account OIDCAccount
+	codes   map[string]oidcCode
+}
+
+// oidcCode represents an authorization code waiting to be exchanged.
+type oidcCode struct {
+	account     OIDCAccount
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+// oidcKID is the key id of the key signing the ID tokens.
+const oidcKID = "dbtest-oidc"
+
+// StartOIDCProvider starts a fake OIDC provider accepting the given client.
+// The provider is shut down when the test completes.
+func StartOIDCProvider(t *testing.T, clientID string) *OIDCProvider {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating oidc key: %s", err)
+	}
+
+	p := OIDCProvider{
+		ClientID: clientID,
+		keys:     keystore.New(),
+		codes:    make(map[string]oidcCode),
+	}
+	p.keys.Add(privateKey, oidcKID)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+
+	p.server = httptest.NewServer(mux)
+	p.URL = p.server.URL
+	t.Cleanup(p.server.Close)
+
+	return &p
+}
+
+// SignIn selects the account signed in by the next authorization request.
+func (p *OIDCProvider) SignIn(account OIDCAccount) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.account = account
+}
+
+// Authorize plays the user agent: it visits the authorization URL and
+// returns the code and state the provider redirects back with.
+func (p *OIDCProvider) Authorize(authURL string) (string, string, error) {
+	client := http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+func (p *OIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	md := map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/jwks",
+	}
+	json.NewEncoder(w).Encode(md)
+}
+
+func (p *OIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(p.keys.JWKS())
+}
+
+func (p *OIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code, err := oidc.RandomValue()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	p.mu.Lock()
+	p.codes[code] = oidcCode{
+		account:     p.account,
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	p.mu.Unlock()
+
+	v := url.Values{
+		"code":  {code},
+		"state": {q.Get("state")},
+	}
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+v.Encode(), http.StatusFound)
+}
+
+func (p *OIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(err error) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": err.Error()})
+	}
+
+	if err := r.ParseForm(); err != nil {
+		tokenError(err)
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if id, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(id)
+	}
+
+	p.mu.Lock()
+	code, found := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	switch {
+	case !found:
+		tokenError(errors.New("unknown code"))
+		return
+	case clientID != p.ClientID:
+		tokenError(errors.New("wrong client"))
+		return
+	case r.PostForm.Get("redirect_uri") != code.redirectURI:
+		tokenError(errors.New("wrong redirect uri"))
+		return
+	case oidc.Challenge(r.PostForm.Get("code_verifier")) != code.challenge:
+		tokenError(errors.New("wrong code verifier"))
+		return
+	}
+
+	now := time.Now()
+	claims := struct {
+		jwt.RegisteredClaims
+		Email         string `json:"email,omitempty"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name,omitempty"`
+		Nonce         string `json:"nonce,omitempty"`
+	}{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.URL,
+			Subject:   code.account.Subject,
+			Audience:  jwt.ClaimStrings{p.ClientID},
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Email:         code.account.Email,
+		EmailVerified: code.account.EmailVerified,
+		Name:          code.account.Name,
+		Nonce:         code.nonce,
+	}
+
+	privateKey, err := p.keys.PrivateKey(oidcKID)
+	if err != nil {
+		tokenError(err)
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = oidcKID
+	idToken, err := token.SignedString(privateKey)
+	if err != nil {
+		tokenError(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "dbtest",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
@@ -0,0 +1,36 @@
+package dbschema
+
+import (
+	"testing"
+
+	"github.com/ardanlabs/darwin"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestMigrations(t *testing.T) {
+	t.Log("Given the need to apply the migrations in the order they are written.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen parsing the schema.", testID)
+		{
+			migs := darwin.ParseMigrations(schemaDoc)
+			if len(migs) == 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to parse the migrations.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to parse the migrations.", success, testID)
+
+			// Versions are read as numbers, so 1.10 is the same as 1.1.
+			for i := 1; i < len(migs); i++ {
+				if migs[i].Version <= migs[i-1].Version {
+					t.Fatalf("\t%s\tTest %d:\tShould have increasing versions : %v %q after %v %q.", failed, testID, migs[i].Version, migs[i].Description, migs[i-1].Version, migs[i-1].Description)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould have increasing versions.", success, testID)
+		}
+	}
+}
@@ -1,3 +1,14 @@
+DELETE FROM archived_products;
+DELETE FROM lockout_audit;
+DELETE FROM login_failures;
+DELETE FROM user_tokens;
+DELETE FROM recovery_codes;
+DELETE FROM user_identities;
+DELETE FROM oidc_logins;
+DELETE FROM api_keys;
+DELETE FROM revoked_tokens;
+DELETE FROM refresh_tokens;
+DELETE FROM reservations;
 DELETE FROM sales;
 DELETE FROM products;
 DELETE FROM users;
\ No newline at end of file
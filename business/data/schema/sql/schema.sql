@@ -41,3 +41,160 @@ CREATE TABLE sales (
 	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
 	FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
 );
+
+-- Version: 1.4
+-- Description: Add void support to sales
+ALTER TABLE sales ADD COLUMN date_voided TIMESTAMP;
+
+-- Version: 1.5
+-- Description: Create table reservations
+CREATE TABLE reservations (
+	reservation_id UUID,
+	product_id     UUID,
+	cart_id        TEXT,
+	quantity       INT,
+	date_created   TIMESTAMP,
+	date_expires   TIMESTAMP,
+
+	PRIMARY KEY (reservation_id),
+	FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+);
+
+-- Version: 1.6
+-- Description: Create tables refresh_tokens and revoked_tokens
+CREATE TABLE refresh_tokens (
+	token_id     UUID,
+	family_id    UUID,
+	user_id      UUID,
+	token_hash   TEXT UNIQUE,
+	date_created TIMESTAMP,
+	date_expires TIMESTAMP,
+	date_used    TIMESTAMP,
+	date_revoked TIMESTAMP,
+
+	PRIMARY KEY (token_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+CREATE TABLE revoked_tokens (
+	jti          TEXT,
+	date_expires TIMESTAMP,
+
+	PRIMARY KEY (jti)
+);
+
+-- Version: 1.7
+-- Description: Create table api_keys
+CREATE TABLE api_keys (
+	key_id       UUID,
+	user_id      UUID,
+	name         TEXT,
+	prefix       TEXT,
+	key_hash     TEXT UNIQUE,
+	scopes       TEXT[],
+	date_created TIMESTAMP,
+	date_revoked TIMESTAMP,
+
+	PRIMARY KEY (key_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- Version: 1.8
+-- Description: Create tables oidc_logins and user_identities
+CREATE TABLE oidc_logins (
+	state         TEXT,
+	nonce         TEXT,
+	code_verifier TEXT,
+	date_expires  TIMESTAMP,
+
+	PRIMARY KEY (state)
+);
+
+CREATE TABLE user_identities (
+	issuer       TEXT,
+	subject      TEXT,
+	user_id      UUID,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (issuer, subject),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- Version: 1.9
+-- Description: Add TOTP multi-factor authentication to users
+ALTER TABLE users ADD COLUMN mfa_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN mfa_last_step BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN date_mfa_enabled TIMESTAMP;
+
+CREATE TABLE recovery_codes (
+	code_id   UUID,
+	user_id   UUID,
+	code_hash TEXT,
+	date_used TIMESTAMP,
+
+	PRIMARY KEY (code_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- Version: 2.0
+-- Description: Add email verification and single use user tokens
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+CREATE TABLE user_tokens (
+	token_hash   TEXT,
+	user_id      UUID,
+	purpose      TEXT,
+	email        TEXT,
+	date_created TIMESTAMP,
+	date_expires TIMESTAMP,
+	date_used    TIMESTAMP,
+
+	PRIMARY KEY (token_hash),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- Version: 2.1
+-- Description: Add failed login counters and the lockout audit log
+CREATE TABLE login_failures (
+	counter_key       TEXT,
+	failures          INT,
+	date_last_failure TIMESTAMP,
+	date_locked_until TIMESTAMP,
+
+	PRIMARY KEY (counter_key)
+);
+
+CREATE TABLE lockout_audit (
+	audit_id          UUID,
+	counter_key       TEXT,
+	event             TEXT,
+	actor_id          TEXT,
+	date_locked_until TIMESTAMP,
+	date_created      TIMESTAMP,
+
+	PRIMARY KEY (audit_id)
+);
+
+-- Version: 2.2
+-- Description: Create table archived_products
+CREATE TABLE archived_products (
+	product_id    UUID,
+	user_id       UUID,
+	name          TEXT,
+	cost          INT,
+	quantity      INT,
+	sold          INT,
+	revenue       INT,
+	date_created  TIMESTAMP,
+	date_updated  TIMESTAMP,
+	date_archived TIMESTAMP,
+
+	PRIMARY KEY (product_id)
+);
+
+-- Version: 2.3
+-- Description: Add the user holding a reservation
+DELETE FROM reservations;
+ALTER TABLE reservations ADD COLUMN user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE;
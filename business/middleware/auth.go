@@ -8,33 +8,65 @@ import (
 	"strings"
 
 	"github.com/Fiiii/WT/business/sys/auth"
-	"github.com/Fiiii/WT/business/sys/validate"
+	"github.com/Fiiii/WT/business/sys/problem"
 	"github.com/Fiiii/WT/foundation/web"
 )
 
-// Authenticate validates a JWT from the `Authorization` header.
+// Authenticate validates a JWT from the `Authorization` header. Machine
+// clients may present an API key instead, either as `Authorization: ApiKey
+// <key>` or in the `X-API-Key` header. Both produce the same claims. Tokens
+// still waiting for the second factor are rejected.
 func Authenticate(a *auth.Auth) web.Middleware {
+	return authenticate(a, false)
+}
+
+// AuthenticateMFA validates a JWT from the `Authorization` header which was
+// issued to a user who still has to enter the second factor. Any other token
+// is rejected.
+func AuthenticateMFA(a *auth.Auth) web.Middleware {
+	return authenticate(a, true)
+}
+
+// authenticate constructs the middleware shared by the Authenticate variants.
+// The pending flag decides which kind of token is accepted.
+func authenticate(a *auth.Auth, pending bool) web.Middleware {
 
 	// This is the actual middleware function to be executed.
 	m := func(handler web.Handler) web.Handler {
 
 		// Create the handler that will be attached in the middleware chain.
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			var claims auth.Claims
+			var err error
 
-			// Expecting: bearer <token>
+			// Expecting: bearer <token>, apikey <key> or the X-API-Key header.
 			authStr := r.Header.Get("authorization")
-
-			// Parse the authorization header.
 			parts := strings.Split(authStr, " ")
-			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-				err := errors.New("expected authorization header format: bearer <token>")
-				return validate.NewRequestError(err, http.StatusUnauthorized)
+
+			switch {
+			case authStr == "" && r.Header.Get("x-api-key") != "":
+				claims, err = a.ValidateAPIKey(ctx, r.Header.Get("x-api-key"))
+
+			case len(parts) == 2 && strings.ToLower(parts[0]) == "bearer":
+
+				// Validate the token is signed by us.
+				claims, err = a.ValidateToken(ctx, parts[1])
+
+			case len(parts) == 2 && strings.ToLower(parts[0]) == "apikey":
+				claims, err = a.ValidateAPIKey(ctx, parts[1])
+
+			default:
+				err := errors.New("expected authorization header format: bearer <token> or apikey <key>")
+				return problem.NewRequestError(err, http.StatusUnauthorized)
 			}
 
-			// Validate the token is signed by us.
-			claims, err := a.ValidateToken(parts[1])
 			if err != nil {
-				return validate.NewRequestError(err, http.StatusUnauthorized)
+				return problem.NewRequestError(err, http.StatusUnauthorized)
+			}
+
+			if claims.MFAPending != pending {
+				err := errors.New("token is not valid for this endpoint")
+				return problem.NewRequestError(err, http.StatusUnauthorized)
 			}
 
 			// Add claims to the context, so they can be retrieved later.
@@ -53,6 +85,30 @@ func Authenticate(a *auth.Auth) web.Middleware {
 // Authorize validates that an authenticated user has at least one role from a
 // specified list. This method constructs the actual function that is used.
 func Authorize(roles ...string) web.Middleware {
+	return authorize(func(claims auth.Claims) bool {
+		return claims.Authorized(roles...)
+	}, fmt.Sprintf("roles[%v]", roles))
+}
+
+// AuthorizeAll validates that the roles of an authenticated user grant every
+// permission from a specified list.
+func AuthorizeAll(perms ...string) web.Middleware {
+	return authorize(func(claims auth.Claims) bool {
+		return claims.HasAllPermissions(perms...)
+	}, fmt.Sprintf("all of permissions[%v]", perms))
+}
+
+// AuthorizeAny validates that the roles of an authenticated user grant at
+// least one permission from a specified list.
+func AuthorizeAny(perms ...string) web.Middleware {
+	return authorize(func(claims auth.Claims) bool {
+		return claims.HasAnyPermission(perms...)
+	}, fmt.Sprintf("any of permissions[%v]", perms))
+}
+
+// authorize constructs the middleware shared by the Authorize variants. The
+// check decides if the claims are allowed, want describes what was required.
+func authorize(check func(claims auth.Claims) bool, want string) web.Middleware {
 
 	// This is the actual middleware function to be executed.
 	m := func(handler web.Handler) web.Handler {
@@ -63,15 +119,15 @@ func Authorize(roles ...string) web.Middleware {
 			// If the context is missing this value return failure.
 			claims, err := auth.GetClaims(ctx)
 			if err != nil {
-				return validate.NewRequestError(
+				return problem.NewRequestError(
 					fmt.Errorf("you are not authorized for that action, no claims"),
 					http.StatusForbidden,
 				)
 			}
 
-			if !claims.Authorized(roles...) {
-				return validate.NewRequestError(
-					fmt.Errorf("you are not authorized for that action, claims[%v] roles[%v]", claims.Roles, roles),
+			if !check(claims) {
+				return problem.NewRequestError(
+					fmt.Errorf("you are not authorized for that action, claims[%v] %s", claims.Roles, want),
 					http.StatusForbidden,
 				)
 			}
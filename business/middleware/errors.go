@@ -2,14 +2,52 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"github.com/Fiiii/WT/business/core/apikey"
+	"github.com/Fiiii/WT/business/core/product"
+	"github.com/Fiiii/WT/business/core/sale"
+	"github.com/Fiiii/WT/business/core/user"
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/metrics"
+	"github.com/Fiiii/WT/business/sys/problem"
+	"github.com/Fiiii/WT/business/sys/ratelimit"
 	"github.com/Fiiii/WT/business/sys/validate"
 	"github.com/Fiiii/WT/foundation/web"
 	"go.uber.org/zap"
 	"net/http"
 )
 
+// sentinels maps the errors of the core packages to the code responded with
+// when a handler returns them without a RequestError. The first match wins.
+var sentinels = []struct {
+	err  error
+	code problem.Code
+}{
+	{validate.ErrInvalidID, problem.CodeInvalidID},
+	{user.ErrInvalidID, problem.CodeInvalidID},
+	{product.ErrInvalidID, problem.CodeInvalidID},
+	{sale.ErrInvalidID, problem.CodeInvalidID},
+	{apikey.ErrInvalidID, problem.CodeInvalidID},
+	{user.ErrNotFound, problem.CodeNotFound},
+	{product.ErrNotFound, problem.CodeNotFound},
+	{product.ErrReservationNotFound, problem.CodeNotFound},
+	{sale.ErrNotFound, problem.CodeNotFound},
+	{apikey.ErrNotFound, problem.CodeNotFound},
+	{database.ErrDBNotFound, problem.CodeNotFound},
+	{user.ErrEmailTaken, problem.CodeEmailTaken},
+	{product.ErrUnknownUser, problem.CodeConstraint},
+	{database.ErrDBDuplicatedEntry, problem.CodeDuplicate},
+	{database.ErrDBForeignKey, problem.CodeConstraint},
+	{database.ErrDBCheck, problem.CodeConstraint},
+	{product.ErrInsufficientStock, problem.CodeInsufficientStock},
+	{auth.ErrForbidden, problem.CodeForbidden},
+	{ratelimit.ErrLimitExceeded, problem.CodeRateLimited},
+}
+
 // Errors - middleware for handling errors coming out of the call chain. Errors are divided
-// between standard application errors and client-response errors.
+// between standard application errors and client-response errors, which are
+// responded with as RFC 7807 problem details.
 func Errors (log *zap.SugaredLogger) web.Middleware {
 
 	// This is the actual middleware function to be executed.
@@ -28,31 +66,14 @@ func Errors (log *zap.SugaredLogger) web.Middleware {
 
 			if err := handler(ctx, w, r); err != nil {
 				log.Errorw("ERROR", "traceid", v.TraceID, "ERROR", err)
+				metrics.AddErrors(ctx)
 
 				// Build out the error response.
-				var er validate.ErrorResponse
-				var status int
-				switch act := validate.Cause(err).(type) {
-				case validate.FieldErrors:
-					er = validate.ErrorResponse{
-						Error:  "data validation error",
-						Fields: act.Error(),
-					}
-					status = http.StatusBadRequest
-				case *validate.RequestError:
-					er = validate.ErrorResponse{
-						Error: act.Error(),
-					}
-					status = act.Status
-				default:
-					er = validate.ErrorResponse{
-						Error: http.StatusText(http.StatusInternalServerError),
-					}
-					status = http.StatusInternalServerError
-				}
+				pd := details(err, v.TraceID)
 
 				// Respond with the error back to the client.
-				if err := web.Respond(ctx, w, er, status); err != nil {
+				w.Header().Set("Content-Type", problem.ContentType)
+				if err := web.Respond(ctx, w, pd, pd.Status); err != nil {
 					return err
 				}
 
@@ -69,3 +90,20 @@ func Errors (log *zap.SugaredLogger) web.Middleware {
 	}
 	return m
 }
+
+// details constructs the problem details for an error. Errors that are not
+// expected are responded with as internal errors without their message, so
+// nothing about the service leaks to the client.
+func details(err error, traceID string) problem.Details {
+	if pd, ok := problem.FromError(err, traceID); ok {
+		return pd
+	}
+
+	for _, s := range sentinels {
+		if errors.Is(err, s.err) {
+			return problem.NewDetails(s.code, s.err.Error(), traceID)
+		}
+	}
+
+	return problem.NewDetails(problem.CodeInternal, http.StatusText(http.StatusInternalServerError), traceID)
+}
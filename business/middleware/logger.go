@@ -31,7 +31,8 @@ func Logger (log *zap.SugaredLogger) web.Middleware {
 			err = handler(ctx, w, r)
 
 			log.Infow("request completed", "traceid", v.TraceID, "method", r.Method, "path", r.URL.Path,
-				"remoteaddr", r.RemoteAddr, "statuscode", v.StatusCode, "since", time.Since(v.Now))
+				"remoteaddr", r.RemoteAddr, "statuscode", v.StatusCode, "bytes", v.BytesWritten,
+				"ttfb", v.TimeToFirstByte, "since", time.Since(v.Now))
 
 			return err
 		}
@@ -5,9 +5,11 @@ import (
 	"github.com/Fiiii/WT/business/sys/metrics"
 	"github.com/Fiiii/WT/foundation/web"
 	"net/http"
+	"time"
 )
 
-// Metrics updates application metric values.
+// Metrics updates application metric values. It has to run before the Errors
+// middleware so the status code of failed requests is known.
 func Metrics() web.Middleware {
 
 	// This is the actual middleware function to be executed.
@@ -18,20 +20,22 @@ func Metrics() web.Middleware {
 			// Add the metrics into the context for metric gathering.
 			ctx = metrics.Set(ctx)
 
+			// If the context is missing this value, request the service
+			// to be shutdown gracefully.
+			v, err := web.GetValues(ctx)
+			if err != nil {
+				return web.NewShutdownError("web value missing from context")
+			}
+
 			// Call the next handler.
-			err := handler(ctx, w, r)
+			err = handler(ctx, w, r)
 
 			// Handle updating the metrics that can be handled here.
 
 			// Increment the request and goroutines counter.
-			metrics.AddRequests(ctx)
+			metrics.AddRequests(ctx, r.Method, web.Route(r), v.StatusCode, v.BytesWritten, time.Since(v.Now))
 			metrics.AddGoroutines(ctx)
 
-			// Increment if there is an error flowing through the request.
-			if err != nil {
-				metrics.AddErrors(ctx)
-			}
-
 			// Return the error, so it can be handled further up the chain.
 			return err
 		}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/business/sys/ratelimit"
+	"github.com/Fiiii/WT/foundation/web"
+)
+
+// KeyFunc returns who a request is counted against.
+type KeyFunc func(ctx context.Context, r *http.Request) string
+
+// ByIP counts requests against the address of the client.
+func ByIP(ctx context.Context, r *http.Request) string {
+	return "ip:" + web.RemoteIP(r)
+}
+
+// BySubject counts requests against the authenticated subject, falling back
+// to the address of the client for unauthenticated requests. It must come
+// after Authenticate in the middleware chain.
+func BySubject(ctx context.Context, r *http.Request) string {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil || claims.Subject == "" {
+		return ByIP(ctx, r)
+	}
+	return "sub:" + claims.Subject
+}
+
+// RateLimit limits the requests to every route it is applied to with a token
+// bucket per route and key. The RateLimit headers describe the bucket, and
+// denied requests fail with ratelimit.ErrLimitExceeded, which Errors turns
+// into a 429.
+func RateLimit(store ratelimit.Store, limit ratelimit.Limit, key KeyFunc) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	m := func(handler web.Handler) web.Handler {
+
+		// Create the handler that will be attached in the middleware chain.
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			v, err := web.GetValues(ctx)
+			if err != nil {
+				return web.NewShutdownError("web value missing from context")
+			}
+
+			// The limit is part of the key so a route limited more strictly
+			// than every route gets a bucket of its own.
+			k := fmt.Sprintf("%s %s %d/%s/%d %s", r.Method, web.Route(r), limit.Requests, limit.Period, limit.Burst, key(ctx, r))
+			res, err := store.Take(ctx, k, limit, v.Now)
+			if err != nil {
+				return fmt.Errorf("rate limit: %w", err)
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", ceilSeconds(res.Reset))
+
+			if !res.Allowed {
+				w.Header().Set("Retry-After", ceilSeconds(res.RetryAfter))
+				return ratelimit.ErrLimitExceeded
+			}
+
+			return handler(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return m
+}
+
+// ceilSeconds formats a duration as whole seconds, rounding up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
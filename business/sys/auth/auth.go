@@ -2,46 +2,78 @@
 package auth
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
+	"sync"
 )
 
 var (
-	ErrForbidden = errors.New("attempted action is not allowed")
+	ErrForbidden    = errors.New("attempted action is not allowed")
+	ErrTokenRevoked = errors.New("token has been revoked")
+	ErrVerifierOnly = errors.New("auth can only validate tokens")
+	ErrNoAPIKeys    = errors.New("api keys are not supported")
 )
 
 // KeyLookup declares a method set of behavior for looking up
-// private and public keys for JWT use.
+// private and public keys for JWT use. RSA, ECDSA and Ed25519 keys
+// are supported.
 type KeyLookup interface {
-	PrivateKey(kid string) (*rsa.PrivateKey, error)
-	PublicKey(kid string) (*rsa.PublicKey, error)
+	PrivateKey(kid string) (crypto.Signer, error)
+	PublicKey(kid string) (crypto.PublicKey, error)
+}
+
+// validMethods lists the algorithms accepted when parsing tokens.
+var validMethods = []string{"RS256", "ES256", "ES384", "ES512", "EdDSA"}
+
+// RevocationList declares a method set of behavior for checking if a token,
+// identified by its jti claim, was revoked before its expiration.
+type RevocationList interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// APIKeyValidator declares a method set of behavior for exchanging an API key
+// for the claims of the user owning it.
+type APIKeyValidator interface {
+	ValidateAPIKey(ctx context.Context, key string) (Claims, error)
 }
 
 // Auth is used to authenticate clients. It can generate a token for a
 // set of user claims and recreate the claims by parsing the token.
 type Auth struct {
+	mu        sync.RWMutex
 	activeKID string
 	keyLookup KeyLookup
-	method    jwt.SigningMethod
 	keyFunc   func(t *jwt.Token) (interface{}, error)
 	parser    *jwt.Parser
+	revoked   RevocationList
+	apiKeys   APIKeyValidator
 }
 
 // New creates an Auth to support authentication/authorization.
 func New(activeKID string, keyLookup KeyLookup) (*Auth, error) {
 	// The activeKID represents the private key used to signed new tokens.
-	_, err := keyLookup.PrivateKey(activeKID)
-	if err != nil {
-		return nil, errors.New("active KID does not exist in store")
+	if err := checkSigningKey(keyLookup, activeKID); err != nil {
+		return nil, err
 	}
 
-	method := jwt.GetSigningMethod("RS256")
-	if method == nil {
-		return nil, errors.New("configuring algorithm RS256")
-	}
+	return newAuth(activeKID, keyLookup)
+}
+
+// NewVerifier creates an Auth that only validates tokens, for deployments
+// that hold public keys only. GenerateToken always fails with ErrVerifierOnly.
+func NewVerifier(keyLookup KeyLookup) (*Auth, error) {
+	return newAuth("", keyLookup)
+}
 
+// newAuth constructs the Auth shared by New and NewVerifier.
+func newAuth(activeKID string, keyLookup KeyLookup) (*Auth, error) {
 	keyFunc := func(t *jwt.Token) (interface{}, error) {
 		kid, ok := t.Header["kid"]
 		if !ok {
@@ -51,20 +83,35 @@ func New(activeKID string, keyLookup KeyLookup) (*Auth, error) {
 		if !ok {
 			return nil, errors.New("user token key id (kid) must be string")
 		}
-		return keyLookup.PublicKey(kidID)
+
+		publicKey, err := keyLookup.PublicKey(kidID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The algorithm must also match the key it claims to be signed with,
+		// or a token could pick a weaker algorithm than the key was made for.
+		method, err := signingMethod(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != method.Alg() {
+			return nil, fmt.Errorf("algorithm %s does not match key %s", t.Method.Alg(), kidID)
+		}
+
+		return publicKey, nil
 	}
 
 	// Create the token parser to use. The algorithm used to sign the JWT must be
 	// validated to avoid a critical vulnerability:
 	// https://auth0.com/blog/critical-vulnerabilities-in-json-web-token-libraries/
 	parser := jwt.NewParser(
-		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithValidMethods(validMethods),
 	)
 
 	a := Auth{
 		activeKID: activeKID,
 		keyLookup: keyLookup,
-		method:    method,
 		keyFunc:   keyFunc,
 		parser:    parser,
 	}
@@ -72,16 +119,61 @@ func New(activeKID string, keyLookup KeyLookup) (*Auth, error) {
 	return &a, nil
 }
 
+// SetRevocationList configures the list consulted by ValidateToken for
+// revoked tokens. It must be called before the Auth is used concurrently.
+func (a *Auth) SetRevocationList(revoked RevocationList) {
+	a.revoked = revoked
+}
+
+// ActiveKID returns the key id used to sign new tokens.
+func (a *Auth) ActiveKID() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.activeKID
+}
+
+// SetActiveKID switches the key used to sign new tokens. Tokens signed with
+// the previous key stay valid for as long as the key lookup can find its
+// public key. It is safe to call while the Auth is in use.
+func (a *Auth) SetActiveKID(activeKID string) error {
+	if err := checkSigningKey(a.keyLookup, activeKID); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.activeKID = activeKID
+	return nil
+}
+
+// SetAPIKeyValidator configures the validator consulted by ValidateAPIKey. It
+// must be called before the Auth is used concurrently.
+func (a *Auth) SetAPIKeyValidator(apiKeys APIKeyValidator) {
+	a.apiKeys = apiKeys
+}
+
 // GenerateToken generates a signed JWT token string representing the user Claims.
 func (a *Auth) GenerateToken(claims Claims) (string, error) {
-	token := jwt.NewWithClaims(a.method, claims)
-	token.Header["kid"] = a.activeKID
+	activeKID := a.ActiveKID()
+	if activeKID == "" {
+		return "", ErrVerifierOnly
+	}
 
-	privateKey, err := a.keyLookup.PrivateKey(a.activeKID)
+	privateKey, err := a.keyLookup.PrivateKey(activeKID)
 	if err != nil {
 		return "", errors.New("kid lookup failed")
 	}
 
+	method, err := signingMethod(privateKey.Public())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = activeKID
+
 	str, err := token.SignedString(privateKey)
 	if err != nil {
 		return "", fmt.Errorf("signing token: %w", err)
@@ -91,8 +183,9 @@ func (a *Auth) GenerateToken(claims Claims) (string, error) {
 }
 
 // ValidateToken recreates the Claims that were used to generate a token. It
-// verifies that the token was signed using our key.
-func (a *Auth) ValidateToken(tokenStr string) (Claims, error) {
+// verifies that the token was signed using our key and, when a revocation
+// list is configured, that the token was not revoked.
+func (a *Auth) ValidateToken(ctx context.Context, tokenStr string) (Claims, error) {
 	var claims Claims
 	token, err := a.parser.ParseWithClaims(tokenStr, &claims, a.keyFunc)
 	if err != nil {
@@ -103,5 +196,63 @@ func (a *Auth) ValidateToken(tokenStr string) (Claims, error) {
 		return Claims{}, errors.New("invalid token")
 	}
 
+	if a.revoked != nil && claims.ID != "" {
+		revoked, err := a.revoked.IsRevoked(ctx, claims.ID)
+		if err != nil {
+			return Claims{}, fmt.Errorf("checking revocation: %w", err)
+		}
+		if revoked {
+			return Claims{}, ErrTokenRevoked
+		}
+	}
+
 	return claims, nil
 }
+
+// ValidateAPIKey recreates the Claims of the user owning an API key. It fails
+// with ErrNoAPIKeys when no validator is configured.
+func (a *Auth) ValidateAPIKey(ctx context.Context, key string) (Claims, error) {
+	if a.apiKeys == nil {
+		return Claims{}, ErrNoAPIKeys
+	}
+
+	return a.apiKeys.ValidateAPIKey(ctx, key)
+}
+
+// checkSigningKey verifies the key exists and can sign tokens.
+func checkSigningKey(keyLookup KeyLookup, kid string) error {
+	privateKey, err := keyLookup.PrivateKey(kid)
+	if err != nil {
+		return errors.New("active KID does not exist in store")
+	}
+
+	if _, err := signingMethod(privateKey.Public()); err != nil {
+		return fmt.Errorf("active KID: %w", err)
+	}
+
+	return nil
+}
+
+// signingMethod returns the signing method matching the type of a key.
+func signingMethod(publicKey crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+
+	case *ecdsa.PublicKey:
+		switch publicKey.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, errors.New("unsupported EC curve")
+
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %T", publicKey)
+}
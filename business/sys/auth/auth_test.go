@@ -1,9 +1,16 @@
 package auth_test
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"github.com/Fiiii/WT/business/sys/auth"
+	"github.com/Fiiii/WT/foundation/keystore"
 	"github.com/golang-jwt/jwt/v4"
 	"testing"
 	"time"
@@ -54,7 +61,7 @@ func TestAuth(t *testing.T) {
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to generate a JWT.", success, testID)
 
-			parsedClaims, err := a.ValidateToken(token)
+			parsedClaims, err := a.ValidateToken(context.Background(), token)
 			if err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to parse the claims: %v", failed, testID, err)
 			}
@@ -67,19 +74,316 @@ func TestAuth(t *testing.T) {
 			}
 			t.Logf("\t%s\tTest %d:\tShould have the expected number of roles.", success, testID)
 		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen handling a revoked token.", testID)
+		{
+			const keyID = "54bb2165-71e1-41a6-af3e-7da4a0e1e2c1"
+			privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a private key: %v", failed, testID, err)
+			}
+
+			a, err := auth.New(keyID, &keyStore{pk: privateKey})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an authenticator: %v", failed, testID, err)
+			}
+
+			revoked := revocationList{}
+			a.SetRevocationList(revoked)
+
+			claims := auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					ID:        "8a1f4c7e-0b7b-4c43-9f3c-3a5e8b6f2d10",
+					Subject:   "5cf37266-3473-4006-984f-9325122678b7",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+					IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+				},
+				Roles: []string{auth.RoleUser},
+			}
+
+			token, err := a.GenerateToken(claims)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to generate a JWT: %v", failed, testID, err)
+			}
+
+			if _, err := a.ValidateToken(context.Background(), token); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to parse a token not revoked: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to parse a token not revoked.", success, testID)
+
+			revoked[claims.ID] = true
+
+			if _, err := a.ValidateToken(context.Background(), token); !errors.Is(err, auth.ErrTokenRevoked) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to parse a revoked token: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to parse a revoked token.", success, testID)
+		}
+	}
+}
+
+func TestActiveKID(t *testing.T) {
+	t.Log("Given the need to rotate the signing key at runtime.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen switching the active key.", testID)
+		{
+			const (
+				kid1 = "54bb2165-71e1-41a6-af3e-7da4a0e1e2c1"
+				kid2 = "4754d86b-7a6d-4df5-9c65-224741361492"
+			)
+
+			ks := keystore.New()
+			for _, kid := range []string{kid1, kid2} {
+				privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create a private key: %v", failed, testID, err)
+				}
+				ks.Add(privateKey, kid)
+			}
+
+			a, err := auth.New(kid1, ks)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an authenticator: %v", failed, testID, err)
+			}
+
+			claims := auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "5cf37266-3473-4006-984f-9325122678b7",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+				Roles: []string{auth.RoleUser},
+			}
+
+			oldToken, err := a.GenerateToken(claims)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to generate a JWT: %v", failed, testID, err)
+			}
+
+			if err := a.SetActiveKID("unknown"); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to switch to an unknown key.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to switch to an unknown key.", success, testID)
+
+			if err := a.SetActiveKID(kid2); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to switch the active key: %v", failed, testID, err)
+			}
+
+			newToken, err := a.GenerateToken(claims)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to generate a JWT: %v", failed, testID, err)
+			}
+
+			token, _, err := jwt.NewParser().ParseUnverified(newToken, &auth.Claims{})
+			if err != nil || token.Header["kid"] != kid2 {
+				t.Fatalf("\t%s\tTest %d:\tShould sign new tokens with the new key: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould sign new tokens with the new key.", success, testID)
+
+			if _, err := a.ValidateToken(context.Background(), oldToken); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould still validate tokens signed with the old key: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould still validate tokens signed with the old key.", success, testID)
+		}
+	}
+}
+
+func TestSigningMethods(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to create an RSA key: %v", failed, err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to create an EC key: %v", failed, err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to create an Ed25519 key: %v", failed, err)
+	}
+
+	tt := []struct {
+		kid string
+		key crypto.Signer
+		alg string
+	}{
+		{"rsa", rsaKey, "RS256"},
+		{"ec", ecKey, "ES256"},
+		{"ed", edKey, "EdDSA"},
+	}
+
+	ks := keystore.New()
+	for _, tc := range tt {
+		ks.Add(tc.key, tc.kid)
+	}
+
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "5cf37266-3473-4006-984f-9325122678b7",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Roles: []string{auth.RoleUser},
+	}
+
+	t.Log("Given the need to sign tokens with different kinds of keys.")
+	{
+		for testID, tc := range tt {
+			t.Logf("\tTest %d:\tWhen signing with a %s key.", testID, tc.alg)
+			{
+				a, err := auth.New(tc.kid, ks)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create an authenticator: %v", failed, testID, err)
+				}
+
+				str, err := a.GenerateToken(claims)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to generate a JWT: %v", failed, testID, err)
+				}
+
+				token, _, err := jwt.NewParser().ParseUnverified(str, &auth.Claims{})
+				if err != nil || token.Method.Alg() != tc.alg {
+					t.Fatalf("\t%s\tTest %d:\tShould sign with %s: %v", failed, testID, tc.alg, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould sign with %s.", success, testID, tc.alg)
+
+				if _, err := a.ValidateToken(context.Background(), str); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to parse the claims: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to parse the claims.", success, testID)
+			}
+		}
+
+		testID := len(tt)
+		t.Logf("\tTest %d:\tWhen the algorithm does not match the key.", testID)
+		{
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+			token.Header["kid"] = "rsa"
+			str, err := token.SignedString(ecKey)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to sign a JWT: %v", failed, testID, err)
+			}
+
+			a, err := auth.NewVerifier(ks)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a verifier: %v", failed, testID, err)
+			}
+
+			if _, err := a.ValidateToken(context.Background(), str); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to parse the claims.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to parse the claims.", success, testID)
+		}
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	tt := []struct {
+		name   string
+		roles  []string
+		scopes []string
+		check  func(c auth.Claims) bool
+		want   bool
+	}{
+		{"admin role of a user and admin", []string{auth.RoleUser, auth.RoleAdmin}, nil, func(c auth.Claims) bool { return c.Authorized(auth.RoleAdmin) }, true},
+		{"admin role of a user", []string{auth.RoleUser}, nil, func(c auth.Claims) bool { return c.Authorized(auth.RoleAdmin) }, false},
+		{"any role of a user", []string{auth.RoleUser}, nil, func(c auth.Claims) bool { return c.Authorized(auth.RoleAdmin, auth.RoleUser) }, true},
+		{"any role without roles", nil, nil, func(c auth.Claims) bool { return c.Authorized(auth.RoleUser) }, false},
+		{"all permissions of a user and admin", []string{auth.RoleUser, auth.RoleAdmin}, nil, func(c auth.Claims) bool { return c.HasAllPermissions(auth.PermSalesVoid, auth.PermProductsWrite) }, true},
+		{"all permissions of a user", []string{auth.RoleUser}, nil, func(c auth.Claims) bool { return c.HasAllPermissions(auth.PermSalesVoid, auth.PermProductsWrite) }, false},
+		{"any permission of a user", []string{auth.RoleUser}, nil, func(c auth.Claims) bool { return c.HasAnyPermission(auth.PermSalesVoid, auth.PermProductsWrite) }, true},
+		{"any permission of an unknown role", []string{"GUEST"}, nil, func(c auth.Claims) bool { return c.HasAnyPermission(auth.PermProductsRead) }, false},
+		{"scoped permission of an admin", []string{auth.RoleAdmin}, []string{auth.PermProductsRead}, func(c auth.Claims) bool { return c.HasAllPermissions(auth.PermProductsRead) }, true},
+		{"unscoped permission of an admin", []string{auth.RoleAdmin}, []string{auth.PermProductsRead}, func(c auth.Claims) bool { return c.HasAnyPermission(auth.PermSalesVoid) }, false},
+	}
+
+	t.Log("Given the need to authorize users by roles and permissions.")
+	{
+		for testID, tc := range tt {
+			t.Logf("\tTest %d:\tWhen checking the %s.", testID, tc.name)
+			{
+				claims := auth.Claims{Roles: tc.roles, Scopes: tc.scopes}
+				if got := tc.check(claims); got != tc.want {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected result: got %v want %v", failed, testID, got, tc.want)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected result.", success, testID)
+			}
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	const (
+		ownerID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+		otherID = "5cf37266-3473-4006-984f-9325122678b7"
+	)
+
+	scopedCtx := func(subject string, scopes ...string) context.Context {
+		claims := auth.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: subject},
+			Roles:            []string{auth.RoleAdmin},
+			Scopes:           scopes,
+		}
+		return auth.SetClaims(context.Background(), claims)
+	}
+
+	tt := []struct {
+		name   string
+		ctx    context.Context
+		policy auth.Policy
+		perm   string
+		err    error
+	}{
+		{"owner", claimsCtx(ownerID, auth.RoleUser), auth.OwnerOrAdmin, auth.PermUsersWrite, nil},
+		{"other user", claimsCtx(otherID, auth.RoleUser), auth.OwnerOrAdmin, auth.PermUsersWrite, auth.ErrForbidden},
+		{"admin", claimsCtx(otherID, auth.RoleAdmin), auth.OwnerOrAdmin, auth.PermUsersWrite, nil},
+		{"owner not admin", claimsCtx(ownerID, auth.RoleUser), auth.AdminOnly, auth.PermUsersWrite, auth.ErrForbidden},
+		{"owner only", claimsCtx(ownerID, auth.RoleUser), auth.OwnerOnly, auth.PermUsersWrite, nil},
+		{"admin not owner", claimsCtx(otherID, auth.RoleAdmin), auth.OwnerOnly, auth.PermUsersWrite, auth.ErrForbidden},
+		{"no claims", context.Background(), auth.OwnerOrAdmin, auth.PermUsersWrite, auth.ErrForbidden},
+		{"admin key in scope", scopedCtx(otherID, auth.PermUsersWrite), auth.AdminOnly, auth.PermUsersWrite, nil},
+		{"admin key out of scope", scopedCtx(otherID, auth.PermProductsRead), auth.AdminOnly, auth.PermUsersWrite, auth.ErrForbidden},
+		{"owner key out of scope", scopedCtx(ownerID, auth.PermProductsRead), auth.OwnerOnly, auth.PermUsersWrite, auth.ErrForbidden},
+	}
+
+	t.Log("Given the need to enforce ownership policies.")
+	{
+		for testID, tc := range tt {
+			t.Logf("\tTest %d:\tWhen checking %s.", testID, tc.name)
+			{
+				err := auth.Enforce(tc.ctx, tc.policy, tc.perm, ownerID)
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected result: got %v want %v", failed, testID, err, tc.err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected result.", success, testID)
+			}
+		}
 	}
 }
 
 // =====================================================================================================================
 
 type keyStore struct {
-	pk *rsa.PrivateKey
+	pk crypto.Signer
 }
 
-func (ks *keyStore) PrivateKey(kid string) (*rsa.PrivateKey, error) {
+func (ks *keyStore) PrivateKey(kid string) (crypto.Signer, error) {
 	return ks.pk, nil
 }
 
-func (ks *keyStore) PublicKey(kid string) (*rsa.PublicKey, error) {
-	return &ks.pk.PublicKey, nil
+func (ks *keyStore) PublicKey(kid string) (crypto.PublicKey, error) {
+	return ks.pk.Public(), nil
+}
+
+func claimsCtx(subject string, roles ...string) context.Context {
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: subject},
+		Roles:            roles,
+	}
+	return auth.SetClaims(context.Background(), claims)
+}
+
+type revocationList map[string]bool
+
+func (rl revocationList) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	return rl[jti], nil
 }
@@ -13,22 +13,69 @@ const (
 	RoleUser  = "USER"
 )
 
-// Claims represents the authorization claims transmitted via a JWT.
+// Claims represents the authorization claims transmitted via a JWT. Scopes,
+// when set, narrow the permissions granted by the roles. API keys use them.
+// MFAPending marks a token that still waits for the second factor.
 type Claims struct {
 	jwt.RegisteredClaims
-	Roles []string `json:"roles"`
+	Roles      []string `json:"roles"`
+	Scopes     []string `json:"scopes,omitempty"`
+	MFAPending bool     `json:"mfa_pending,omitempty"`
 }
 
 // Authorized returns true if the claims has at least one of the provided roles.
 func (c Claims) Authorized(roles ...string) bool {
 	for _, has := range c.Roles {
 		for _, want := range roles {
-			return has == want
+			if has == want {
+				return true
+			}
 		}
 	}
 	return false
 }
 
+// HasAllPermissions returns true if the roles of the claims grant every one
+// of the provided permissions.
+func (c Claims) HasAllPermissions(perms ...string) bool {
+	granted := c.permissions()
+	for _, perm := range perms {
+		if _, ok := granted[perm]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// HasAnyPermission returns true if the roles of the claims grant at least one
+// of the provided permissions.
+func (c Claims) HasAnyPermission(perms ...string) bool {
+	granted := c.permissions()
+	for _, perm := range perms {
+		if _, ok := granted[perm]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// permissions returns the permissions granted by the roles, narrowed down to
+// the scopes when there are any.
+func (c Claims) permissions() map[string]struct{} {
+	granted := Permissions(c.Roles...)
+	if c.Scopes == nil {
+		return granted
+	}
+
+	scoped := make(map[string]struct{})
+	for _, scope := range c.Scopes {
+		if _, ok := granted[scope]; ok {
+			scoped[scope] = struct{}{}
+		}
+	}
+	return scoped
+}
+
 // ctxKey represents the type of value for the context key.
 type ctxKey int
 
@@ -0,0 +1,47 @@
+package auth
+
+import "context"
+
+// Policy decides if the holder of the claims may act on a resource owned by
+// the user identified by ownerID.
+type Policy func(claims Claims, ownerID string) bool
+
+// Set of policies enforced by the core packages.
+var (
+	// OwnerOrAdmin allows the owner of the resource and any admin.
+	OwnerOrAdmin Policy = func(claims Claims, ownerID string) bool {
+		return claims.Subject == ownerID || claims.Authorized(RoleAdmin)
+	}
+
+	// OwnerOnly allows the owner of the resource only.
+	OwnerOnly Policy = func(claims Claims, ownerID string) bool {
+		return claims.Subject == ownerID
+	}
+
+	// AdminOnly allows admins regardless of who owns the resource.
+	AdminOnly Policy = func(claims Claims, ownerID string) bool {
+		return claims.Authorized(RoleAdmin)
+	}
+)
+
+// Enforce checks the claims stored in the context against the policy. It
+// returns ErrForbidden when the context carries no claims or the policy
+// denies access. The roles are checked against perm by the routes, but scoped
+// claims, like those of an API key, must also have perm in scope, even to act
+// on resources they own or as an admin.
+func Enforce(ctx context.Context, policy Policy, perm string, ownerID string) error {
+	claims, err := GetClaims(ctx)
+	if err != nil {
+		return ErrForbidden
+	}
+
+	if claims.Scopes != nil && !claims.HasAllPermissions(perm) {
+		return ErrForbidden
+	}
+
+	if !policy(claims, ownerID) {
+		return ErrForbidden
+	}
+
+	return nil
+}
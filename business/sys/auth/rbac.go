@@ -0,0 +1,61 @@
+package auth
+
+// These are the permissions the roles can be granted. A permission names a
+// resource and the action on it.
+const (
+	PermUsersRead        = "users:read"
+	PermUsersWrite       = "users:write"
+	PermProductsRead     = "products:read"
+	PermProductsWrite    = "products:write"
+	PermProductsPurchase = "products:purchase"
+	PermSalesRead        = "sales:read"
+	PermSalesWrite       = "sales:write"
+	PermSalesVoid        = "sales:void"
+	PermAPIKeysWrite     = "apikeys:write"
+)
+
+// rolePermissions maps every role to the permissions it grants. Ownership of
+// individual resources is checked separately by the policies.
+var rolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermUsersRead,
+		PermUsersWrite,
+		PermProductsRead,
+		PermProductsWrite,
+		PermProductsPurchase,
+		PermSalesRead,
+		PermSalesWrite,
+		PermSalesVoid,
+		PermAPIKeysWrite,
+	},
+	RoleUser: {
+		PermProductsRead,
+		PermProductsWrite,
+		PermProductsPurchase,
+		PermAPIKeysWrite,
+	},
+}
+
+// Permissions returns the set of permissions granted by the roles. Unknown
+// roles grant nothing.
+func Permissions(roles ...string) map[string]struct{} {
+	granted := make(map[string]struct{})
+	for _, role := range roles {
+		for _, perm := range rolePermissions[role] {
+			granted[perm] = struct{}{}
+		}
+	}
+	return granted
+}
+
+// IsPermission reports if perm is granted by any of the roles.
+func IsPermission(perm string) bool {
+	for _, perms := range rolePermissions {
+		for _, p := range perms {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
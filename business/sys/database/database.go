@@ -3,13 +3,19 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/Fiiii/WT/business/sys/metrics"
+	"github.com/Fiiii/WT/foundation/trace"
 	"github.com/Fiiii/WT/foundation/web"
 	"go.uber.org/zap"
+	"math/rand"
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -20,6 +26,10 @@ import (
 var (
 	ErrDBNotFound        = errors.New("not found")
 	ErrDBDuplicatedEntry = errors.New("duplicated entry")
+	ErrDBForeignKey      = errors.New("foreign key violation")
+	ErrDBCheck           = errors.New("check violation")
+	ErrDBSerialization   = errors.New("serialization failure")
+	ErrDBDeadlock        = errors.New("deadlock detected")
 )
 
 // Config is the required properties to use the database.
@@ -92,13 +102,18 @@ func StatusCheck(ctx context.Context, db *sqlx.DB) error {
 }
 
 // NamedExecContext is a helper function to execute a CUD operation with
-// logging and tracing.
-func NamedExecContext(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, query string, data interface{}) error {
+// logging, tracing and metrics.
+func NamedExecContext(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, query string, data interface{}) (err error) {
 	q := queryString(query, data)
 	log.Infow("database.NamedExecContext", "traceid", web.GetTraceID(ctx), "query", q)
 
+	ctx, span := startSpan(ctx, "database.NamedExecContext", query)
+	defer span.End()
+	defer observe("NamedExecContext", query, time.Now(), &err)
+
 	if _, err := sqlx.NamedExecContext(ctx, db, query, data); err != nil {
-		return err
+		span.RecordError(err)
+		return Translate(err)
 	}
 
 	return nil
@@ -106,10 +121,14 @@ func NamedExecContext(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtCo
 
 // NamedQuerySlice is a helper function for executing queries that return a
 // collection of data to be unmarshalled into a slice.
-func NamedQuerySlice(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, query string, data interface{}, dest interface{}) error {
+func NamedQuerySlice(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, query string, data interface{}, dest interface{}) (err error) {
 	q := queryString(query, data)
 	log.Infow("database.NamedQuerySlice", "traceid", web.GetTraceID(ctx), "query", q)
 
+	ctx, span := startSpan(ctx, "database.NamedQuerySlice", query)
+	defer span.End()
+	defer observe("NamedQuerySlice", query, time.Now(), &err)
+
 	val := reflect.ValueOf(dest)
 	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice {
 		return errors.New("must provide a pointer to a slice")
@@ -117,7 +136,8 @@ func NamedQuerySlice(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtCon
 
 	rows, err := sqlx.NamedQueryContext(ctx, db, query, data)
 	if err != nil {
-		return err
+		span.RecordError(err)
+		return Translate(err)
 	}
 	defer rows.Close()
 
@@ -125,37 +145,81 @@ func NamedQuerySlice(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtCon
 	for rows.Next() {
 		v := reflect.New(slice.Type().Elem())
 		if err := rows.StructScan(v.Interface()); err != nil {
+			span.RecordError(err)
 			return err
 		}
 		slice.Set(reflect.Append(slice, v.Elem()))
 	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		return Translate(err)
+	}
+	span.SetAttribute("db.rows", slice.Len())
 
 	return nil
 }
 
 // NamedQueryStruct is a helper function for executing queries that return a
 // single value to be unmarshalled into a struct type.
-func NamedQueryStruct(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, query string, data interface{}, dest interface{}) error {
+func NamedQueryStruct(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, query string, data interface{}, dest interface{}) (err error) {
 	q := queryString(query, data)
 	log.Infow("database.NamedQueryStruct", "traceid", web.GetTraceID(ctx), "query", q)
 
+	ctx, span := startSpan(ctx, "database.NamedQueryStruct", query)
+	defer span.End()
+	defer observe("NamedQueryStruct", query, time.Now(), &err)
+
 	rows, err := sqlx.NamedQueryContext(ctx, db, query, data)
 	if err != nil {
-		return err
+		span.RecordError(err)
+		return Translate(err)
 	}
 	defer rows.Close()
 
+	// A statement like INSERT .. RETURNING may only fail once the row is
+	// read, so the error must be told apart from an empty result.
 	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			span.RecordError(err)
+			return Translate(err)
+		}
 		return ErrDBNotFound
 	}
 
 	if err := rows.StructScan(dest); err != nil {
+		span.RecordError(err)
 		return err
 	}
 
 	return nil
 }
 
+// startSpan starts a client span for a query, recording the statement with
+// its named parameters so the values, like password hashes, never leave the
+// service.
+func startSpan(ctx context.Context, name string, query string) (context.Context, *trace.Span) {
+	ctx, span := trace.Start(ctx, name, trace.KindClient)
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.statement", query)
+	return ctx, span
+}
+
+// observe records how long a query took once the helper returns. Not found
+// is an expected outcome of a query rather than a failure.
+func observe(operation string, query string, start time.Time, err *error) {
+	failed := *err != nil && !errors.Is(*err, ErrDBNotFound)
+	metrics.AddQuery(operation, command(query), time.Since(start), failed)
+}
+
+// command returns the SQL command of the query, like SELECT or INSERT.
+func command(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
 // queryString provides a pretty print version of the query and parameters.
 func queryString(query string, args ...interface{}) string {
 	query, params, err := sqlx.Named(query, args)
@@ -184,16 +248,100 @@ func queryString(query string, args ...interface{}) string {
 
 // Transactor interface needed to begin transaction.
 type Transactor interface {
-	Beginx() (*sqlx.Tx, error)
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error)
+}
+
+// Defaults for retrying a transaction that failed to serialize or deadlocked.
+const (
+	defaultAttempts = 3
+	retryBase       = 20 * time.Millisecond
+	retryMax        = 500 * time.Millisecond
+)
+
+// tranConfig represents how WithinTran runs a transaction.
+type tranConfig struct {
+	opts     sql.TxOptions
+	attempts int
+}
+
+// TranOption configures how WithinTran runs a transaction.
+type TranOption func(*tranConfig)
+
+// Isolation runs the transaction at the isolation level, like
+// sql.LevelSerializable. The default is the level of the database.
+func Isolation(level sql.IsolationLevel) TranOption {
+	return func(cfg *tranConfig) {
+		cfg.opts.Isolation = level
+	}
+}
+
+// ReadOnly runs the transaction in read only mode.
+func ReadOnly() TranOption {
+	return func(cfg *tranConfig) {
+		cfg.opts.ReadOnly = true
+	}
+}
+
+// MaxAttempts sets how many times the transaction is run before a
+// serialization failure or deadlock is returned. One disables retries.
+func MaxAttempts(n int) TranOption {
+	return func(cfg *tranConfig) {
+		if n > 0 {
+			cfg.attempts = n
+		}
+	}
 }
 
-// WithinTran runs passed function and do commit/rollback at the end.
-func WithinTran(ctx context.Context, log *zap.SugaredLogger, db Transactor, fn func(sqlx.ExtContext) error) error {
+// WithinTran runs passed function and do commit/rollback at the end. When
+// the transaction fails to serialize or deadlocks, it is rolled back and fn
+// runs again in a new transaction after a jittered backoff, so fn must not
+// have side effects outside of the transaction.
+func WithinTran(ctx context.Context, log *zap.SugaredLogger, db Transactor, fn func(sqlx.ExtContext) error, opts ...TranOption) error {
+	traceID := web.GetTraceID(ctx)
+
+	cfg := tranConfig{
+		attempts: defaultAttempts,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	ctx, span := trace.Start(ctx, "database.WithinTran", trace.KindInternal)
+	defer span.End()
+
+	for attempt := 1; ; attempt++ {
+		span.SetAttribute("db.attempts", attempt)
+
+		err := runTran(ctx, log, db, cfg.opts, fn)
+		if err == nil {
+			return nil
+		}
+		span.RecordError(err)
+
+		if attempt >= cfg.attempts || !retryable(err) {
+			return err
+		}
+
+		wait := backoff(attempt)
+		log.Infow("retry tran", "traceid", traceID, "attempt", attempt, "backoff", wait, "ERROR", err)
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// runTran runs fn once within a new transaction.
+func runTran(ctx context.Context, log *zap.SugaredLogger, db Transactor, opts sql.TxOptions, fn func(sqlx.ExtContext) error) error {
 	traceID := web.GetTraceID(ctx)
 
 	// Begin the transaction.
 	log.Infow("begin tran", "traceid", traceID)
-	tx, err := db.Beginx()
+	tx, err := db.BeginTxx(ctx, &opts)
 	if err != nil {
 		return fmt.Errorf("begin tran: %w", err)
 	}
@@ -207,7 +355,7 @@ func WithinTran(ctx context.Context, log *zap.SugaredLogger, db Transactor, fn f
 	defer func() {
 		if mustRollback {
 			log.Infow("rollback tran", "traceid", traceID)
-			if err := tx.Rollback(); err != nil {
+			if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
 				log.Errorw("unable to rollback tran", "traceid", traceID, "ERROR", err)
 			}
 		}
@@ -225,8 +373,69 @@ func WithinTran(ctx context.Context, log *zap.SugaredLogger, db Transactor, fn f
 	// Commit the transaction.
 	log.Infow("commit tran", "traceid", traceID)
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit tran: %w", err)
+		return fmt.Errorf("commit tran: %w", Translate(err))
 	}
 
 	return nil
 }
+
+// savepoints numbers savepoints so nested ones get distinct names.
+var savepoints uint64
+
+// WithinSavepoint runs passed function within a savepoint of the transaction
+// and releases it at the end. When fn fails only what fn did is rolled back,
+// so the caller may handle the error and carry on with the transaction.
+func WithinSavepoint(ctx context.Context, log *zap.SugaredLogger, tx sqlx.ExtContext, fn func(sqlx.ExtContext) error) error {
+	traceID := web.GetTraceID(ctx)
+	name := fmt.Sprintf("sp_%d", atomic.AddUint64(&savepoints, 1))
+
+	log.Infow("begin savepoint", "traceid", traceID, "savepoint", name)
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("begin savepoint: %w", Translate(err))
+	}
+
+	if err := fn(tx); err != nil {
+		log.Infow("rollback savepoint", "traceid", traceID, "savepoint", name)
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); err != nil {
+			log.Errorw("unable to rollback savepoint", "traceid", traceID, "savepoint", name, "ERROR", err)
+		}
+		return fmt.Errorf("exec savepoint: %w", err)
+	}
+
+	log.Infow("release savepoint", "traceid", traceID, "savepoint", name)
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("release savepoint: %w", Translate(err))
+	}
+
+	return nil
+}
+
+// retryable reports whether running the transaction again may succeed.
+func retryable(err error) bool {
+	return errors.Is(err, ErrDBSerialization) || errors.Is(err, ErrDBDeadlock)
+}
+
+// jitter is the source of the random part of the backoff. A rand.Rand is
+// not safe for concurrent use.
+var jitter = struct {
+	sync.Mutex
+	*rand.Rand
+}{
+	Rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+}
+
+// backoff returns how long to wait before the next attempt. The delay doubles
+// with every attempt up to retryMax, and a random half of it is dropped so
+// transactions that conflicted do not run again at the same time.
+func backoff(attempt int) time.Duration {
+	d := retryMax
+	if attempt < 8 {
+		if exp := retryBase << (attempt - 1); exp < retryMax {
+			d = exp
+		}
+	}
+
+	jitter.Lock()
+	defer jitter.Unlock()
+	return d/2 + time.Duration(jitter.Int63n(int64(d/2)+1))
+}
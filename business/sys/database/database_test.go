@@ -0,0 +1,178 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// conn is a driver connection that only supports transactions and
+// statements without arguments, recording the options transactions begin
+// with, the statements and how transactions end.
+type conn struct {
+	opts      []driver.TxOptions
+	queries   []string
+	commits   int
+	rollbacks int
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *conn) Close() error { return nil }
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.queries = append(c.queries, query)
+	return driver.RowsAffected(0), nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.opts = append(c.opts, opts)
+	return tx{c}, nil
+}
+
+type tx struct{ c *conn }
+
+func (t tx) Commit() error   { t.c.commits++; return nil }
+func (t tx) Rollback() error { t.c.rollbacks++; return nil }
+
+type connector struct{ c *conn }
+
+func (ct connector) Connect(context.Context) (driver.Conn, error) { return ct.c, nil }
+func (ct connector) Driver() driver.Driver                        { return nil }
+
+func TestWithinTran(t *testing.T) {
+	log := zap.NewNop().Sugar()
+
+	t.Log("Given the need to run functions within a transaction.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the transaction fails to serialize.", testID)
+		{
+			c := conn{}
+			db := sqlx.NewDb(sql.OpenDB(connector{&c}), "postgres")
+
+			calls := 0
+			fn := func(sqlx.ExtContext) error {
+				calls++
+				if calls < 3 {
+					return fmt.Errorf("update: %w", database.Translate(&pq.Error{Code: "40001"}))
+				}
+				return nil
+			}
+
+			err := database.WithinTran(context.Background(), log, db, fn, database.Isolation(sql.LevelSerializable), database.ReadOnly())
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to run the transaction : %s.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to run the transaction.", success, testID)
+
+			if calls != 3 || c.rollbacks != 2 || c.commits != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould run it again until it commits : calls[%d] rollbacks[%d] commits[%d].", failed, testID, calls, c.rollbacks, c.commits)
+			}
+			t.Logf("\t%s\tTest %d:\tShould run it again until it commits.", success, testID)
+
+			want := driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelSerializable), ReadOnly: true}
+			for _, opts := range c.opts {
+				if opts != want {
+					t.Fatalf("\t%s\tTest %d:\tShould begin with the options : got %+v.", failed, testID, opts)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould begin with the options.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the transaction keeps deadlocking.", testID)
+		{
+			c := conn{}
+			db := sqlx.NewDb(sql.OpenDB(connector{&c}), "postgres")
+
+			calls := 0
+			fn := func(sqlx.ExtContext) error {
+				calls++
+				return database.Translate(&pq.Error{Code: "40P01"})
+			}
+
+			err := database.WithinTran(context.Background(), log, db, fn, database.MaxAttempts(2))
+			if !errors.Is(err, database.ErrDBDeadlock) || calls != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould give up after the last attempt : calls[%d] %v.", failed, testID, calls, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould give up after the last attempt.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the function fails for another reason.", testID)
+		{
+			c := conn{}
+			db := sqlx.NewDb(sql.OpenDB(connector{&c}), "postgres")
+
+			calls := 0
+			fn := func(sqlx.ExtContext) error {
+				calls++
+				return database.Translate(&pq.Error{Code: "23505"})
+			}
+
+			err := database.WithinTran(context.Background(), log, db, fn)
+			if !errors.Is(err, database.ErrDBDuplicatedEntry) || calls != 1 || c.rollbacks != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould roll back without running it again : calls[%d] %v.", failed, testID, calls, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould roll back without running it again.", success, testID)
+		}
+	}
+}
+
+func TestWithinSavepoint(t *testing.T) {
+	log := zap.NewNop().Sugar()
+
+	t.Log("Given the need to run functions within a savepoint of a transaction.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a nested function fails and the error is handled.", testID)
+		{
+			c := conn{}
+			db := sqlx.NewDb(sql.OpenDB(connector{&c}), "postgres")
+
+			fn := func(tx sqlx.ExtContext) error {
+				if err := database.WithinSavepoint(context.Background(), log, tx, func(sqlx.ExtContext) error { return nil }); err != nil {
+					return err
+				}
+
+				err := database.WithinSavepoint(context.Background(), log, tx, func(sqlx.ExtContext) error {
+					return database.Translate(&pq.Error{Code: "23505"})
+				})
+				if !errors.Is(err, database.ErrDBDuplicatedEntry) {
+					return fmt.Errorf("should get back the error of the nested function: %v", err)
+				}
+				return nil
+			}
+
+			if err := database.WithinTran(context.Background(), log, db, fn); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to run the transaction : %s.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to run the transaction.", success, testID)
+
+			if len(c.queries) != 4 || c.commits != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould commit after the savepoints : %q commits[%d].", failed, testID, c.queries, c.commits)
+			}
+			first, second := strings.TrimPrefix(c.queries[0], "SAVEPOINT "), strings.TrimPrefix(c.queries[2], "SAVEPOINT ")
+			if first == second || c.queries[1] != "RELEASE SAVEPOINT "+first || c.queries[3] != "ROLLBACK TO SAVEPOINT "+second {
+				t.Fatalf("\t%s\tTest %d:\tShould release the first and roll back the second : %q.", failed, testID, c.queries)
+			}
+			t.Logf("\t%s\tTest %d:\tShould release the first and roll back the second.", success, testID)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// errorCodes maps the Postgres error codes callers may handle to the error
+// variables they are reported as.
+var errorCodes = map[string]error{
+	"unique_violation":      ErrDBDuplicatedEntry,
+	"foreign_key_violation": ErrDBForeignKey,
+	"check_violation":       ErrDBCheck,
+	"serialization_failure": ErrDBSerialization,
+	"deadlock_detected":     ErrDBDeadlock,
+}
+
+// Error is returned when Postgres rejects a statement for a reason the caller
+// may handle, like a unique or foreign key violation. It unwraps to one of
+// the error variables of the package and carries the constraint that failed.
+type Error struct {
+	Err        error
+	Constraint string
+	Table      string
+	Column     string
+	Message    string
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Constraint != "" {
+		return fmt.Sprintf("%s on constraint %s: %s", e.Err, e.Constraint, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Err, e.Message)
+}
+
+// Unwrap returns the error variable the failure is reported as.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Translate converts a Postgres error the caller may handle into an Error.
+// Any other error is returned as is. The helpers of the package already
+// translate their errors.
+func Translate(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	dbErr, ok := errorCodes[pqErr.Code.Name()]
+	if !ok {
+		return err
+	}
+
+	return &Error{
+		Err:        dbErr,
+		Constraint: pqErr.Constraint,
+		Table:      pqErr.Table,
+		Column:     pqErr.Column,
+		Message:    pqErr.Message,
+	}
+}
+
+// IsConstraint reports whether the error was caused by a violation of the
+// named constraint, like users_email_key.
+func IsConstraint(err error, constraint string) bool {
+	var dbErr *Error
+	return errors.As(err, &dbErr) && dbErr.Constraint == constraint
+}
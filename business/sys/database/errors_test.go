@@ -0,0 +1,63 @@
+package database_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/lib/pq"
+)
+
+func TestTranslate(t *testing.T) {
+	t.Log("Given the need to handle errors reported by Postgres.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a unique constraint is violated.", testID)
+		{
+			pqErr := &pq.Error{
+				Code:       "23505",
+				Message:    `duplicate key value violates unique constraint "users_email_key"`,
+				Table:      "users",
+				Constraint: "users_email_key",
+			}
+			err := fmt.Errorf("create: %w", database.Translate(pqErr))
+
+			if !errors.Is(err, database.ErrDBDuplicatedEntry) {
+				t.Fatalf("\t%s\tTest %d:\tShould report a duplicated entry : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould report a duplicated entry.", success, testID)
+
+			if !database.IsConstraint(err, "users_email_key") || database.IsConstraint(err, "api_keys_key_hash_key") {
+				t.Fatalf("\t%s\tTest %d:\tShould carry the constraint name : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould carry the constraint name.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen other errors are reported.", testID)
+		{
+			tests := []struct {
+				code pq.ErrorCode
+				err  error
+			}{
+				{"23503", database.ErrDBForeignKey},
+				{"23514", database.ErrDBCheck},
+				{"40001", database.ErrDBSerialization},
+				{"40P01", database.ErrDBDeadlock},
+			}
+			for _, tt := range tests {
+				if err := database.Translate(&pq.Error{Code: tt.code}); !errors.Is(err, tt.err) {
+					t.Fatalf("\t%s\tTest %d:\tShould report %q for %s : %v.", failed, testID, tt.err, tt.code, err)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould report the error of each code.", success, testID)
+
+			syntax := &pq.Error{Code: "42601"}
+			if err := database.Translate(syntax); err != syntax {
+				t.Fatalf("\t%s\tTest %d:\tShould keep errors callers do not handle : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep errors callers do not handle.", success, testID)
+		}
+	}
+}
@@ -0,0 +1,496 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Fiiii/WT/business/sys/paging"
+)
+
+// ErrInvalidQuery occurs when a query is built with a column that is not a
+// plain identifier, or from a filter with a malformed tag.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// identifier matches the column names accepted in conditions, ordering and
+// assignments, optionally qualified by a table alias.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// checkColumn returns an error when the column is not a plain identifier.
+func checkColumn(column string) error {
+	if !identifier.MatchString(column) {
+		return fmt.Errorf("%w: column %q", ErrInvalidQuery, column)
+	}
+	return nil
+}
+
+// =============================================================================
+
+// Cond represents a condition of a WHERE clause. Values are always passed as
+// named parameters, never written into the SQL.
+type Cond struct {
+	column string
+	op     string
+	values []interface{}
+	err    error
+}
+
+// Eq matches rows where the column equals the value.
+func Eq(column string, value interface{}) Cond {
+	return Cond{column: column, op: "=", values: []interface{}{value}}
+}
+
+// Gt matches rows where the column is greater than the value.
+func Gt(column string, value interface{}) Cond {
+	return Cond{column: column, op: ">", values: []interface{}{value}}
+}
+
+// Gte matches rows where the column is greater than or equal to the value.
+func Gte(column string, value interface{}) Cond {
+	return Cond{column: column, op: ">=", values: []interface{}{value}}
+}
+
+// Lt matches rows where the column is less than the value.
+func Lt(column string, value interface{}) Cond {
+	return Cond{column: column, op: "<", values: []interface{}{value}}
+}
+
+// Lte matches rows where the column is less than or equal to the value.
+func Lte(column string, value interface{}) Cond {
+	return Cond{column: column, op: "<=", values: []interface{}{value}}
+}
+
+// Contains matches rows where the column contains s, ignoring case. The
+// wildcards of LIKE in s are matched literally.
+func Contains(column string, s string) Cond {
+	return Cond{column: column, op: "ILIKE", values: []interface{}{"%" + paging.EscapeLike(s) + "%"}}
+}
+
+// IsNull matches rows where the column is NULL.
+func IsNull(column string) Cond {
+	return Cond{column: column, op: "IS NULL"}
+}
+
+// After matches rows sorted after the values of the columns, comparing them
+// as a row so it can be used for keyset paging. Desc matches the rows before
+// them instead.
+func After(columns []string, values []interface{}, desc bool) Cond {
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	if len(columns) != len(values) {
+		return Cond{err: fmt.Errorf("%w: %d columns compared with %d values", ErrInvalidQuery, len(columns), len(values))}
+	}
+	return Cond{column: strings.Join(columns, ", "), op: op, values: values}
+}
+
+// ops maps the operators of filter tags to the condition they build.
+var ops = map[string]func(column string, value interface{}) Cond{
+	"eq":  Eq,
+	"gt":  Gt,
+	"gte": Gte,
+	"lt":  Lt,
+	"lte": Lte,
+	"contains": func(column string, value interface{}) Cond {
+		s, ok := value.(string)
+		if !ok {
+			return Cond{err: fmt.Errorf("%w: contains on %T", ErrInvalidQuery, value)}
+		}
+		return Contains(column, s)
+	},
+}
+
+// Filter returns the conditions of a filter struct. Every field is a pointer
+// tagged with the column and operator it filters with, like
+// `filter:"p.cost,gte"`, and only fields that are not nil add a condition.
+// The operators are eq, gt, gte, lt, lte and contains.
+func Filter(filter interface{}) []Cond {
+	v := reflect.Indirect(reflect.ValueOf(filter))
+	if v.Kind() != reflect.Struct {
+		return []Cond{{err: fmt.Errorf("%w: filter must be a struct, got %T", ErrInvalidQuery, filter)}}
+	}
+
+	var conds []Cond
+	for i := 0; i < v.NumField(); i++ {
+		tag, ok := v.Type().Field(i).Tag.Lookup("filter")
+		if !ok {
+			continue
+		}
+
+		f := v.Field(i)
+		if f.Kind() != reflect.Ptr {
+			conds = append(conds, Cond{err: fmt.Errorf("%w: filter field %s must be a pointer", ErrInvalidQuery, v.Type().Field(i).Name)})
+			continue
+		}
+		if f.IsNil() {
+			continue
+		}
+
+		column, op := tag, "eq"
+		if j := strings.IndexByte(tag, ','); j >= 0 {
+			column, op = tag[:j], tag[j+1:]
+		}
+
+		build, ok := ops[op]
+		if !ok {
+			conds = append(conds, Cond{err: fmt.Errorf("%w: filter operator %q", ErrInvalidQuery, op)})
+			continue
+		}
+		conds = append(conds, build(column, f.Elem().Interface()))
+	}
+
+	return conds
+}
+
+// =============================================================================
+
+// builder accumulates the named parameters of a statement. The parameters
+// are numbered so conditions on the same column don't collide.
+type builder struct {
+	params map[string]interface{}
+	err    error
+}
+
+// param adds a parameter and returns its placeholder. Times are passed in
+// UTC since the columns are timestamps without a time zone, which would
+// otherwise drop the offset.
+func (b *builder) param(value interface{}) string {
+	if b.params == nil {
+		b.params = make(map[string]interface{})
+	}
+	if t, ok := value.(time.Time); ok {
+		value = t.UTC()
+	}
+	name := fmt.Sprintf("p%d", len(b.params)+1)
+	b.params[name] = value
+	return ":" + name
+}
+
+// column records an error when the column is not a plain identifier.
+func (b *builder) column(column string) string {
+	if b.err == nil {
+		b.err = checkColumn(column)
+	}
+	return column
+}
+
+// where writes the WHERE clause of the conditions.
+func (b *builder) where(sb *strings.Builder, conds []Cond) {
+	if len(conds) == 0 {
+		return
+	}
+
+	sb.WriteString(" WHERE ")
+	for i, c := range conds {
+		if c.err != nil && b.err == nil {
+			b.err = c.err
+		}
+		if i > 0 {
+			sb.WriteString(" AND ")
+		}
+
+		if len(c.values) > 1 {
+			columns := strings.Split(c.column, ", ")
+			placeholders := make([]string, len(c.values))
+			for j := range c.values {
+				b.column(columns[j])
+				placeholders[j] = b.param(c.values[j])
+			}
+			fmt.Fprintf(sb, "(%s) %s (%s)", c.column, c.op, strings.Join(placeholders, ", "))
+			continue
+		}
+
+		sb.WriteString(b.column(c.column) + " " + c.op)
+		for _, value := range c.values {
+			sb.WriteString(" " + b.param(value))
+		}
+	}
+}
+
+// =============================================================================
+
+// order represents a column of an ORDER BY clause.
+type order struct {
+	column string
+	desc   bool
+}
+
+// SelectQuery builds a SELECT statement. The columns, table and joins are
+// written as given, so they must never come from user input.
+type SelectQuery struct {
+	columns []string
+	from    string
+	joins   []string
+	where   []Cond
+	groupBy []string
+	orderBy []order
+	limit   int
+}
+
+// Select starts a SELECT statement for the columns.
+func Select(columns ...string) *SelectQuery {
+	return &SelectQuery{columns: columns}
+}
+
+// From sets the table the rows are selected from.
+func (q *SelectQuery) From(table string) *SelectQuery {
+	q.from = table
+	return q
+}
+
+// Join adds a JOIN clause, like "LEFT JOIN sales AS s ON ...".
+func (q *SelectQuery) Join(join string) *SelectQuery {
+	q.joins = append(q.joins, join)
+	return q
+}
+
+// Where adds conditions which must all match.
+func (q *SelectQuery) Where(conds ...Cond) *SelectQuery {
+	q.where = append(q.where, conds...)
+	return q
+}
+
+// GroupBy sets the columns rows are grouped by.
+func (q *SelectQuery) GroupBy(columns ...string) *SelectQuery {
+	q.groupBy = append(q.groupBy, columns...)
+	return q
+}
+
+// OrderBy adds a column rows are sorted by.
+func (q *SelectQuery) OrderBy(column string, desc bool) *SelectQuery {
+	q.orderBy = append(q.orderBy, order{column, desc})
+	return q
+}
+
+// Limit sets the maximum number of rows returned.
+func (q *SelectQuery) Limit(n int) *SelectQuery {
+	q.limit = n
+	return q
+}
+
+// Page selects the page of the request from rows sorted by the column and
+// then the id column. One row past the limit is selected so paging.Trim can
+// tell whether there are more rows.
+func (q *SelectQuery) Page(req paging.Request, column string, idColumn string) *SelectQuery {
+	op, dir := req.Comparison()
+	desc := op == "<"
+
+	if !req.Cursor.IsZero() {
+		q.Where(After([]string{column, idColumn}, []interface{}{req.Cursor.Value, req.Cursor.ID}, desc))
+	}
+	if column != idColumn {
+		q.OrderBy(column, dir == "DESC")
+	}
+	return q.OrderBy(idColumn, dir == "DESC").Limit(req.Limit + 1)
+}
+
+// Build returns the statement and its named parameters.
+func (q *SelectQuery) Build() (string, map[string]interface{}, error) {
+	var b builder
+	var sb strings.Builder
+
+	sb.WriteString("SELECT " + strings.Join(q.columns, ", ") + " FROM " + q.from)
+	for _, join := range q.joins {
+		sb.WriteString(" " + join)
+	}
+
+	b.where(&sb, q.where)
+
+	if len(q.groupBy) > 0 {
+		sb.WriteString(" GROUP BY " + strings.Join(q.groupBy, ", "))
+	}
+
+	for i, o := range q.orderBy {
+		if i == 0 {
+			sb.WriteString(" ORDER BY ")
+		} else {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(b.column(o.column))
+		if o.desc {
+			sb.WriteString(" DESC")
+		} else {
+			sb.WriteString(" ASC")
+		}
+	}
+
+	if q.limit > 0 {
+		sb.WriteString(" LIMIT " + b.param(q.limit))
+	}
+
+	if b.err != nil {
+		return "", nil, b.err
+	}
+	return sb.String(), b.params, nil
+}
+
+// =============================================================================
+
+// InsertQuery builds an INSERT statement from the db tags of a struct.
+type InsertQuery struct {
+	table   string
+	row     interface{}
+	columns []string
+}
+
+// Insert starts an INSERT statement of the row, a struct with db tags. Only
+// the columns are inserted when some are given, otherwise every tagged
+// field is.
+func Insert(table string, row interface{}, columns ...string) *InsertQuery {
+	return &InsertQuery{table: table, row: row, columns: columns}
+}
+
+// Build returns the statement and its named parameters.
+func (q *InsertQuery) Build() (string, map[string]interface{}, error) {
+	values, order, err := fields(q.row)
+	if err != nil {
+		return "", nil, err
+	}
+
+	columns := q.columns
+	if len(columns) == 0 {
+		columns = order
+	}
+
+	var b builder
+	placeholders := make([]string, len(columns))
+	for i, column := range columns {
+		value, ok := values[column]
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %T has no column %q", ErrInvalidQuery, q.row, column)
+		}
+		b.column(column)
+		placeholders[i] = b.param(value)
+	}
+
+	if b.err != nil {
+		return "", nil, b.err
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", q.table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	return query, b.params, nil
+}
+
+// fields returns the values of the fields of a struct by their db tag, and
+// the tags in the order of the fields.
+func fields(row interface{}) (map[string]interface{}, []string, error) {
+	v := reflect.Indirect(reflect.ValueOf(row))
+	if v.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("%w: row must be a struct, got %T", ErrInvalidQuery, row)
+	}
+
+	values := make(map[string]interface{})
+	var order []string
+	for i := 0; i < v.NumField(); i++ {
+		tag := strings.SplitN(v.Type().Field(i).Tag.Get("db"), ",", 2)[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		values[tag] = v.Field(i).Interface()
+		order = append(order, tag)
+	}
+
+	return values, order, nil
+}
+
+// =============================================================================
+
+// assignment represents a column set by an UPDATE statement.
+type assignment struct {
+	column string
+	value  interface{}
+}
+
+// UpdateQuery builds an UPDATE statement.
+type UpdateQuery struct {
+	table string
+	set   []assignment
+	where []Cond
+}
+
+// Update starts an UPDATE statement of the table.
+func Update(table string) *UpdateQuery {
+	return &UpdateQuery{table: table}
+}
+
+// Set adds a column to set to the value.
+func (q *UpdateQuery) Set(column string, value interface{}) *UpdateQuery {
+	q.set = append(q.set, assignment{column, value})
+	return q
+}
+
+// Where adds conditions which must all match.
+func (q *UpdateQuery) Where(conds ...Cond) *UpdateQuery {
+	q.where = append(q.where, conds...)
+	return q
+}
+
+// Build returns the statement and its named parameters. Updating every row
+// of a table is refused, add a condition matching all rows on purpose.
+func (q *UpdateQuery) Build() (string, map[string]interface{}, error) {
+	if len(q.set) == 0 {
+		return "", nil, fmt.Errorf("%w: update of %s sets no column", ErrInvalidQuery, q.table)
+	}
+	if len(q.where) == 0 {
+		return "", nil, fmt.Errorf("%w: update of %s has no condition", ErrInvalidQuery, q.table)
+	}
+
+	var b builder
+	var sb strings.Builder
+
+	sb.WriteString("UPDATE " + q.table + " SET ")
+	for i, a := range q.set {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(b.column(a.column) + " = " + b.param(a.value))
+	}
+
+	b.where(&sb, q.where)
+
+	if b.err != nil {
+		return "", nil, b.err
+	}
+	return sb.String(), b.params, nil
+}
+
+// =============================================================================
+
+// DeleteQuery builds a DELETE statement.
+type DeleteQuery struct {
+	table string
+	where []Cond
+}
+
+// Delete starts a DELETE statement of the table.
+func Delete(table string) *DeleteQuery {
+	return &DeleteQuery{table: table}
+}
+
+// Where adds conditions which must all match.
+func (q *DeleteQuery) Where(conds ...Cond) *DeleteQuery {
+	q.where = append(q.where, conds...)
+	return q
+}
+
+// Build returns the statement and its named parameters. Deleting every row
+// of a table is refused, like with UPDATE.
+func (q *DeleteQuery) Build() (string, map[string]interface{}, error) {
+	if len(q.where) == 0 {
+		return "", nil, fmt.Errorf("%w: delete of %s has no condition", ErrInvalidQuery, q.table)
+	}
+
+	var b builder
+	var sb strings.Builder
+
+	sb.WriteString("DELETE FROM " + q.table)
+	b.where(&sb, q.where)
+
+	if b.err != nil {
+		return "", nil, b.err
+	}
+	return sb.String(), b.params, nil
+}
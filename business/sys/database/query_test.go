@@ -0,0 +1,121 @@
+package database_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/business/sys/database"
+	"github.com/Fiiii/WT/business/sys/paging"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestSelect(t *testing.T) {
+	type filter struct {
+		Name    *string    `filter:"p.name,contains"`
+		MinCost *int       `filter:"p.cost,gte"`
+		UserID  *string    `filter:"p.user_id"`
+		Start   *time.Time `filter:"p.date_created,gte"`
+	}
+
+	t.Log("Given the need to build SELECT statements.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen selecting a page of filtered rows.", testID)
+		{
+			name := "50% off"
+			cost := 10
+			cursor := paging.NewCursor(paging.Order{Field: "cost", Desc: true}, "20", "a2b0639f-2cc6-44b8-b97b-15d69dbb511e")
+			req := paging.Request{Order: paging.Order{Field: "cost", Desc: true}, Cursor: cursor, Limit: 2}
+
+			q, data, err := database.Select("p.*").
+				From("products AS p").
+				Where(database.Filter(filter{Name: &name, MinCost: &cost})...).
+				GroupBy("p.product_id").
+				Page(req, "p.cost", "p.product_id").
+				Build()
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to build the query : %s.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to build the query.", success, testID)
+
+			const want = "SELECT p.* FROM products AS p WHERE p.name ILIKE :p1 AND p.cost >= :p2 AND (p.cost, p.product_id) < (:p3, :p4) GROUP BY p.product_id ORDER BY p.cost DESC, p.product_id DESC LIMIT :p5"
+			if q != want {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the statement :\n got %s\nwant %s", failed, testID, q, want)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get back the statement.", success, testID)
+
+			params := fmt.Sprint(data)
+			if want := `map[p1:%50\% off% p2:10 p3:20 p4:a2b0639f-2cc6-44b8-b97b-15d69dbb511e p5:3]`; params != want {
+				t.Fatalf("\t%s\tTest %d:\tShould pass the values as parameters : got %s, want %s.", failed, testID, params, want)
+			}
+			t.Logf("\t%s\tTest %d:\tShould pass the values as parameters.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen a column is not an identifier.", testID)
+		{
+			_, _, err := database.Select("*").From("users").OrderBy("name; DROP TABLE users", false).Build()
+			if !errors.Is(err, database.ErrInvalidQuery) {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse to build the query : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse to build the query.", success, testID)
+		}
+	}
+}
+
+func TestStatements(t *testing.T) {
+	type sale struct {
+		ID       string     `db:"sale_id"`
+		Quantity int        `db:"quantity"`
+		Voided   *time.Time `db:"date_voided"`
+		Note     string
+	}
+
+	t.Log("Given the need to build INSERT, UPDATE and DELETE statements.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen inserting a row.", testID)
+		{
+			q, data, err := database.Insert("sales", sale{ID: "1", Quantity: 2}).Build()
+			if err != nil || q != "INSERT INTO sales (sale_id, quantity, date_voided) VALUES (:p1, :p2, :p3)" || data["p2"] != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould insert the tagged fields : %s %v %v.", failed, testID, q, data, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould insert the tagged fields.", success, testID)
+
+			q, _, err = database.Insert("sales", sale{ID: "1"}, "sale_id").Build()
+			if err != nil || q != "INSERT INTO sales (sale_id) VALUES (:p1)" {
+				t.Fatalf("\t%s\tTest %d:\tShould only insert the given columns : %s %v.", failed, testID, q, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only insert the given columns.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen updating and deleting rows.", testID)
+		{
+			now := time.Date(2021, time.October, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+			q, data, err := database.Update("sales").Set("date_voided", now).Where(database.Eq("sale_id", "1"), database.IsNull("date_voided")).Build()
+			if err != nil || q != "UPDATE sales SET date_voided = :p1 WHERE sale_id = :p2 AND date_voided IS NULL" {
+				t.Fatalf("\t%s\tTest %d:\tShould build the update : %s %v.", failed, testID, q, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould build the update.", success, testID)
+
+			if got := data["p1"].(time.Time); got.Location() != time.UTC || !got.Equal(now) {
+				t.Fatalf("\t%s\tTest %d:\tShould pass times in UTC : %v.", failed, testID, got)
+			}
+			t.Logf("\t%s\tTest %d:\tShould pass times in UTC.", success, testID)
+
+			if _, _, err := database.Delete("sales").Build(); !errors.Is(err, database.ErrInvalidQuery) {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse to delete every row : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse to delete every row.", success, testID)
+		}
+	}
+}
@@ -4,6 +4,9 @@ package metrics
 import (
 	"context"
 	"expvar"
+	"runtime"
+	"strconv"
+	"time"
 )
 
 // This holds the single instance of the metrics value needed for
@@ -14,13 +17,20 @@ var m *metrics
 
 // =============================================================================
 
-// metrics represents the set of metrics we gather. These fields are
-// safe to be accessed concurrently thanks to expvar. No extra abstraction is required.
+// metrics represents the set of metrics we gather. The expvar fields are
+// published on /debug/vars, the labeled ones are only exposed in the
+// Prometheus text format. All fields are safe to be accessed concurrently.
 type metrics struct {
 	goroutines *expvar.Int
 	requests   *expvar.Int
 	errors     *expvar.Int
 	panics     *expvar.Int
+
+	httpRequests *vec
+	httpDuration *vec
+	httpBytes    *vec
+	dbQueries    *vec
+	dbDuration   *vec
 }
 
 // init constructs the metrics value that will be used to capture metrics.
@@ -33,6 +43,12 @@ func init() {
 		requests:   expvar.NewInt("requests"),
 		errors:     expvar.NewInt("errors"),
 		panics:     expvar.NewInt("panics"),
+
+		httpRequests: newCounter("http_requests_total", "Total number of requests handled.", "method", "route", "status"),
+		httpDuration: newHistogram("http_request_duration_seconds", "Time taken to handle requests.", DefaultBuckets, "method", "route", "status"),
+		httpBytes:    newCounter("http_response_size_bytes_total", "Total number of bytes written in responses.", "method", "route", "status"),
+		dbQueries:    newCounter("db_queries_total", "Total number of database queries executed.", "operation", "command", "result"),
+		dbDuration:   newHistogram("db_query_duration_seconds", "Time taken to execute database queries.", DefaultBuckets, "operation", "command"),
 	}
 }
 
@@ -57,19 +73,24 @@ func Set(ctx context.Context) context.Context {
 // different parts of the codebase. This will keep this package the
 // central authority for metrics and metrics won't get lost.
 
-// AddGoroutines increments the goroutines metric by 1.
+// AddGoroutines records the number of goroutines that currently exist.
 func AddGoroutines(ctx context.Context) {
 	if v, ok := ctx.Value(key).(*metrics); ok {
-		if v.requests.Value()%100 == 0 {
-			v.goroutines.Add(1)
-		}
+		v.goroutines.Set(int64(runtime.NumGoroutine()))
 	}
 }
 
-// AddRequests increments the request metric by 1.
-func AddRequests(ctx context.Context) {
+// AddRequests increments the request metric by 1 and records the size of the
+// response and how long the request took. The route is the pattern the
+// request matched rather than its path, so the number of series stays bounded.
+func AddRequests(ctx context.Context, method string, route string, status int, bytes int64, since time.Duration) {
 	if v, ok := ctx.Value(key).(*metrics); ok {
 		v.requests.Add(1)
+
+		code := strconv.Itoa(status)
+		v.httpRequests.add(1, method, route, code)
+		v.httpDuration.observe(since.Seconds(), method, route, code)
+		v.httpBytes.add(float64(bytes), method, route, code)
 	}
 }
 
@@ -86,3 +107,16 @@ func AddPanics(ctx context.Context) {
 		v.panics.Add(1)
 	}
 }
+
+// AddQuery records how long a database query took. The operation is the
+// helper that ran it and the command the SQL statement, like SELECT. Queries
+// run outside of requests are recorded too, so the context is not required.
+func AddQuery(operation string, command string, since time.Duration, failed bool) {
+	result := "success"
+	if failed {
+		result = "error"
+	}
+
+	m.dbQueries.add(1, operation, command, result)
+	m.dbDuration.observe(since.Seconds(), operation, command)
+}
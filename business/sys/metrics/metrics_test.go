@@ -0,0 +1,95 @@
+package metrics_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/business/sys/metrics"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestPrometheus(t *testing.T) {
+	t.Log("Given the need to expose metrics in the Prometheus text format.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen requests and queries are recorded.", testID)
+		{
+			ctx := metrics.Set(context.Background())
+			metrics.AddRequests(ctx, "GET", "/v1/users/:id", 200, 100, 500*time.Millisecond)
+			metrics.AddRequests(ctx, "GET", "/v1/users/:id", 200, 20, 2*time.Second)
+			metrics.AddRequests(ctx, "POST", `/v1/"quoted"`, 400, 0, time.Millisecond)
+			metrics.AddQuery("NamedQueryStruct", "SELECT", 20*time.Millisecond, false)
+			metrics.AddQuery("NamedExecContext", "INSERT", time.Millisecond, true)
+
+			// Requests recorded without the metrics in the context are ignored.
+			metrics.AddRequests(context.Background(), "GET", "/ignored", 200, 0, time.Millisecond)
+
+			var buf bytes.Buffer
+			if err := metrics.Write(&buf, nil); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to write the metrics : %s.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to write the metrics.", success, testID)
+			out := buf.String()
+
+			lines := []string{
+				"# TYPE http_requests_total counter",
+				`http_requests_total{method="GET",route="/v1/users/:id",status="200"} 2`,
+				`http_requests_total{method="POST",route="/v1/\"quoted\"",status="400"} 1`,
+				`http_response_size_bytes_total{method="GET",route="/v1/users/:id",status="200"} 120`,
+				"# TYPE http_request_duration_seconds histogram",
+				`http_request_duration_seconds_bucket{method="GET",route="/v1/users/:id",status="200",le="0.25"} 0`,
+				`http_request_duration_seconds_bucket{method="GET",route="/v1/users/:id",status="200",le="0.5"} 1`,
+				`http_request_duration_seconds_bucket{method="GET",route="/v1/users/:id",status="200",le="2.5"} 2`,
+				`http_request_duration_seconds_bucket{method="GET",route="/v1/users/:id",status="200",le="+Inf"} 2`,
+				`http_request_duration_seconds_sum{method="GET",route="/v1/users/:id",status="200"} 2.5`,
+				`http_request_duration_seconds_count{method="GET",route="/v1/users/:id",status="200"} 2`,
+				`db_queries_total{operation="NamedExecContext",command="INSERT",result="error"} 1`,
+				`db_queries_total{operation="NamedQueryStruct",command="SELECT",result="success"} 1`,
+				`db_query_duration_seconds_count{operation="NamedQueryStruct",command="SELECT"} 1`,
+				"# TYPE go_goroutines gauge",
+			}
+			for _, line := range lines {
+				if !strings.Contains(out, line+"\n") {
+					t.Fatalf("\t%s\tTest %d:\tShould contain %q : %s", failed, testID, line, out)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould contain the labeled counters and histograms.", success, testID)
+
+			if strings.Contains(out, "/ignored") {
+				t.Fatalf("\t%s\tTest %d:\tShould ignore requests without the metrics in the context.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould ignore requests without the metrics in the context.", success, testID)
+
+			if strings.Contains(out, "db_open_connections") {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT contain pool stats without a database.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT contain pool stats without a database.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the metrics are scraped over HTTP.", testID)
+		{
+			w := httptest.NewRecorder()
+			metrics.Handler(nil).ServeHTTP(w, httptest.NewRequest("GET", "/debug/metrics", nil))
+
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+				t.Fatalf("\t%s\tTest %d:\tShould use the text format content type : %q.", failed, testID, ct)
+			}
+			t.Logf("\t%s\tTest %d:\tShould use the text format content type.", success, testID)
+
+			if !strings.Contains(w.Body.String(), "# TYPE http_request_errors_total counter\n") {
+				t.Fatalf("\t%s\tTest %d:\tShould contain the error counter : %s", failed, testID, w.Body.String())
+			}
+			t.Logf("\t%s\tTest %d:\tShould contain the error counter.", success, testID)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the latency histograms.
+// They range from 5ms to 10s, which covers both queries and requests.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// =============================================================================
+
+// series represents the values recorded for one combination of label values.
+type series struct {
+	labels []string
+	value  float64
+	counts []uint64
+	sum    float64
+}
+
+// vec represents a counter or histogram partitioned by labels. Buckets are
+// only set for histograms.
+type vec struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// newCounter constructs a counter partitioned by the labels.
+func newCounter(name string, help string, labels ...string) *vec {
+	return &vec{
+		name:   name,
+		help:   help,
+		kind:   "counter",
+		labels: labels,
+		series: make(map[string]*series),
+	}
+}
+
+// newHistogram constructs a histogram with the buckets, partitioned by the
+// labels.
+func newHistogram(name string, help string, buckets []float64, labels ...string) *vec {
+	return &vec{
+		name:    name,
+		help:    help,
+		kind:    "histogram",
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+}
+
+// with returns the series for the label values, creating it on first use.
+// The caller must hold the lock.
+func (v *vec) with(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{
+			labels: values,
+			counts: make([]uint64, len(v.buckets)),
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+// add increments the counter for the label values by n.
+func (v *vec) add(n float64, values ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.with(values).value += n
+}
+
+// observe records a value in the histogram for the label values.
+func (v *vec) observe(value float64, values ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s := v.with(values)
+	for i, le := range v.buckets {
+		if value <= le {
+			s.counts[i]++
+		}
+	}
+	s.value++
+	s.sum += value
+}
+
+// write writes the metric in the Prometheus text format, with the series
+// sorted by their label values so the output is stable.
+func (v *vec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	writeHeader(w, v.name, v.help, v.kind)
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := v.series[key]
+
+		if v.kind == "counter" {
+			writeSample(w, v.name, v.labels, s.labels, s.value)
+			continue
+		}
+
+		names := append(append([]string{}, v.labels...), "le")
+		for i, le := range v.buckets {
+			writeSample(w, v.name+"_bucket", names, append(append([]string{}, s.labels...), formatFloat(le)), float64(s.counts[i]))
+		}
+		writeSample(w, v.name+"_bucket", names, append(append([]string{}, s.labels...), "+Inf"), s.value)
+		writeSample(w, v.name+"_sum", v.labels, s.labels, s.sum)
+		writeSample(w, v.name+"_count", v.labels, s.labels, s.value)
+	}
+}
+
+// =============================================================================
+
+// Handler returns a handler serving the metrics in the Prometheus text
+// format. The connection pool statistics of the database are included when
+// db is not nil.
+func Handler(db *sqlx.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w, db)
+	})
+}
+
+// Write writes the metrics in the Prometheus text format.
+func Write(w io.Writer, db *sqlx.DB) error {
+	bw := bufio.NewWriter(w)
+
+	writeGauge(bw, "go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+
+	m.httpRequests.write(bw)
+	m.httpDuration.write(bw)
+	m.httpBytes.write(bw)
+	m.dbQueries.write(bw)
+	m.dbDuration.write(bw)
+
+	writeHeader(bw, "http_request_errors_total", "Total number of requests that failed with an error.", "counter")
+	writeSample(bw, "http_request_errors_total", nil, nil, float64(m.errors.Value()))
+	writeHeader(bw, "http_request_panics_total", "Total number of requests that panicked.", "counter")
+	writeSample(bw, "http_request_panics_total", nil, nil, float64(m.panics.Value()))
+
+	if db != nil {
+		s := db.Stats()
+		writeGauge(bw, "db_max_open_connections", "Maximum number of open connections to the database.", float64(s.MaxOpenConnections))
+		writeGauge(bw, "db_open_connections", "Number of established connections both in use and idle.", float64(s.OpenConnections))
+		writeGauge(bw, "db_in_use_connections", "Number of connections currently in use.", float64(s.InUse))
+		writeGauge(bw, "db_idle_connections", "Number of idle connections.", float64(s.Idle))
+		writeCounter(bw, "db_wait_count_total", "Total number of connections waited for.", float64(s.WaitCount))
+		writeCounter(bw, "db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", s.WaitDuration.Seconds())
+		writeCounter(bw, "db_max_idle_closed_total", "Total number of connections closed due to SetMaxIdleConns.", float64(s.MaxIdleClosed))
+		writeCounter(bw, "db_max_idle_time_closed_total", "Total number of connections closed due to SetConnMaxIdleTime.", float64(s.MaxIdleTimeClosed))
+		writeCounter(bw, "db_max_lifetime_closed_total", "Total number of connections closed due to SetConnMaxLifetime.", float64(s.MaxLifetimeClosed))
+	}
+
+	return bw.Flush()
+}
+
+// =============================================================================
+
+// writeGauge writes a metric with a single unlabeled gauge sample.
+func writeGauge(w *bufio.Writer, name string, help string, value float64) {
+	writeHeader(w, name, help, "gauge")
+	writeSample(w, name, nil, nil, value)
+}
+
+// writeCounter writes a metric with a single unlabeled counter sample.
+func writeCounter(w *bufio.Writer, name string, help string, value float64) {
+	writeHeader(w, name, help, "counter")
+	writeSample(w, name, nil, nil, value)
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric.
+func writeHeader(w *bufio.Writer, name string, help string, kind string) {
+	w.WriteString("# HELP " + name + " " + strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help) + "\n")
+	w.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// writeSample writes one sample line with its labels.
+func writeSample(w *bufio.Writer, name string, labels []string, values []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label + `="` + escapeLabel(values[i]) + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// escapeLabel escapes a label value as the text format requires.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// formatFloat formats a sample value the way Prometheus expects.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,263 @@
+// Package paging provides support for keyset pagination of list queries with
+// opaque cursors.
+package paging
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Limits on the number of items in a page.
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// ErrInvalidCursor occurs when a cursor was not issued by the service or
+// belongs to a query with a different order.
+var ErrInvalidCursor = errors.New("cursor is not valid")
+
+// Order represents the field a list is sorted by and its direction. Rows with
+// the same value are always sorted by their id as well, so pages are stable.
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// String implements the fmt.Stringer interface, in the form accepted by
+// ParseOrder.
+func (o Order) String() string {
+	if o.Desc {
+		return o.Field + ",desc"
+	}
+	return o.Field + ",asc"
+}
+
+// ParseOrder parses an order of the form field, field,asc or field,desc. Only
+// the allowed fields are accepted since the field ends up in the SQL. The
+// default is returned when s is empty.
+func ParseOrder(s string, allowed []string, def Order) (Order, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	field, dir := s, "asc"
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		field, dir = s[:i], strings.ToLower(s[i+1:])
+	}
+
+	known := false
+	for _, a := range allowed {
+		if a == field {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return Order{}, fmt.Errorf("unknown order field %q", field)
+	}
+
+	switch dir {
+	case "asc":
+		return Order{Field: field}, nil
+	case "desc":
+		return Order{Field: field, Desc: true}, nil
+	}
+	return Order{}, fmt.Errorf("unknown order direction %q", dir)
+}
+
+// =============================================================================
+
+// Cursor represents the position of a row in a sorted list: the value of the
+// order field and the id of the row. Backward cursors point at the page
+// before the row rather than after it.
+type Cursor struct {
+	Order    string `json:"o"`
+	Value    string `json:"v"`
+	ID       string `json:"id"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// NewCursor constructs a cursor for a row in a list sorted by the order.
+func NewCursor(order Order, value string, id string) Cursor {
+	return Cursor{
+		Order: order.String(),
+		Value: value,
+		ID:    id,
+	}
+}
+
+// IsZero reports whether the cursor points at the first page.
+func (c Cursor) IsZero() bool {
+	return c.ID == ""
+}
+
+// Encode returns the opaque form of the cursor handed out to clients.
+func (c Cursor) Encode() string {
+	d, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(d)
+}
+
+// ParseCursor decodes a cursor which must belong to a list sorted by the
+// order. The zero cursor is returned when s is empty.
+func ParseCursor(s string, order Order) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+
+	d, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(d, &c); err != nil || c.ID == "" || c.Order != order.String() {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// =============================================================================
+
+// Request represents the page of a list a client asked for.
+type Request struct {
+	Order  Order
+	Cursor Cursor
+	Limit  int
+	Total  bool
+}
+
+// Parse reads the page from the query string parameters limit, cursor,
+// order_by and total.
+func Parse(qs url.Values, allowed []string, def Order) (Request, error) {
+	req := Request{
+		Limit: DefaultLimit,
+	}
+
+	if s := qs.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return Request{}, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		req.Limit = limit
+	}
+
+	order, err := ParseOrder(qs.Get("order_by"), allowed, def)
+	if err != nil {
+		return Request{}, err
+	}
+	req.Order = order
+
+	if req.Cursor, err = ParseCursor(qs.Get("cursor"), order); err != nil {
+		return Request{}, err
+	}
+
+	if s := qs.Get("total"); s != "" {
+		if req.Total, err = strconv.ParseBool(s); err != nil {
+			return Request{}, fmt.Errorf("invalid total format [%s]", s)
+		}
+	}
+
+	return req, nil
+}
+
+// Comparison returns the operator and direction rows are compared and sorted
+// with in SQL to fetch the page after, or before, the cursor.
+func (r Request) Comparison() (op string, dir string) {
+	if r.Order.Desc != r.Cursor.Backward {
+		return "<", "DESC"
+	}
+	return ">", "ASC"
+}
+
+// Trim drops the row fetched past the limit to tell whether there are more
+// rows, and puts the rows of a backward page back in the order of the list.
+// Rows must be a pointer to a slice. It reports whether there were more rows.
+func (r Request) Trim(rows interface{}) bool {
+	v := reflect.ValueOf(rows).Elem()
+
+	more := v.Len() > r.Limit
+	if more {
+		v.Set(v.Slice(0, r.Limit))
+	}
+
+	if r.Cursor.Backward {
+		swap := reflect.Swapper(v.Interface())
+		for i, j := 0, v.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	return more
+}
+
+// =============================================================================
+
+// Page represents where a page of items sits in the list. The cursors are
+// empty on the first and last page, Total is only set on request.
+type Page struct {
+	Next  string
+	Prev  string
+	Total *int
+}
+
+// NewPage constructs the page for a non-empty list of items fetched with the
+// request, given the cursors of the first and last item. More reports
+// whether items were found past the page in the direction it was fetched.
+func NewPage(req Request, first Cursor, last Cursor, more bool) Page {
+	hasNext, hasPrev := more, !req.Cursor.IsZero()
+	if req.Cursor.Backward {
+		hasNext, hasPrev = true, more
+	}
+
+	var p Page
+	if hasNext {
+		p.Next = last.Encode()
+	}
+	if hasPrev {
+		first.Backward = true
+		p.Prev = first.Encode()
+	}
+	return p
+}
+
+// Response is the document returned by list endpoints.
+type Response struct {
+	Items interface{} `json:"items"`
+	Total *int        `json:"total,omitempty"`
+	Next  string      `json:"next,omitempty"`
+	Prev  string      `json:"prev,omitempty"`
+}
+
+// NewResponse constructs the document for the items of the page, with links
+// to the next and previous pages that keep the query string of u.
+func NewResponse(u *url.URL, items interface{}, page Page) Response {
+	link := func(cursor string) string {
+		if cursor == "" {
+			return ""
+		}
+		qs := u.Query()
+		qs.Set("cursor", cursor)
+		return u.Path + "?" + qs.Encode()
+	}
+
+	return Response{
+		Items: items,
+		Total: page.Total,
+		Next:  link(page.Next),
+		Prev:  link(page.Prev),
+	}
+}
+
+// EscapeLike escapes the wildcards of a LIKE pattern so s is matched
+// literally.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,155 @@
+package paging_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/Fiiii/WT/business/sys/paging"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestParse(t *testing.T) {
+	allowed := []string{"product_id", "name", "cost"}
+	def := paging.Order{Field: "product_id"}
+
+	t.Log("Given the need to read the page of a list from the query string.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen no parameters are given.", testID)
+		{
+			req, err := paging.Parse(url.Values{}, allowed, def)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to parse : %s.", failed, testID, err)
+			}
+			if req.Limit != paging.DefaultLimit || req.Order != def || !req.Cursor.IsZero() || req.Total {
+				t.Fatalf("\t%s\tTest %d:\tShould use the defaults : %+v.", failed, testID, req)
+			}
+			t.Logf("\t%s\tTest %d:\tShould use the defaults.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen a cursor is given back with the same order.", testID)
+		{
+			order := paging.Order{Field: "cost", Desc: true}
+			cursor := paging.NewCursor(order, "150", "45b5fbd3-755f-4379-8f07-a58d4a30fa2f")
+
+			qs := url.Values{"order_by": {"cost,DESC"}, "cursor": {cursor.Encode()}, "limit": {"5"}, "total": {"true"}}
+			req, err := paging.Parse(qs, allowed, def)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to parse : %s.", failed, testID, err)
+			}
+			if req.Order != order || req.Cursor != cursor || req.Limit != 5 || !req.Total {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the page : %+v.", failed, testID, req)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get back the page.", success, testID)
+
+			if op, dir := req.Comparison(); op != "<" || dir != "DESC" {
+				t.Fatalf("\t%s\tTest %d:\tShould fetch descending rows after the cursor : %s %s.", failed, testID, op, dir)
+			}
+			t.Logf("\t%s\tTest %d:\tShould fetch descending rows after the cursor.", success, testID)
+
+			qs.Set("order_by", "name")
+			if _, err := paging.Parse(qs, allowed, def); !errors.Is(err, paging.ErrInvalidCursor) {
+				t.Fatalf("\t%s\tTest %d:\tShould reject the cursor with another order : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject the cursor with another order.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the parameters are not valid.", testID)
+		{
+			invalid := []url.Values{
+				{"order_by": {"password_hash"}},
+				{"order_by": {"name,sideways"}},
+				{"order_by": {"name; DROP TABLE products"}},
+				{"limit": {"0"}},
+				{"limit": {"1000"}},
+				{"cursor": {"not a cursor"}},
+				{"total": {"maybe"}},
+			}
+			for _, qs := range invalid {
+				if _, err := paging.Parse(qs, allowed, def); err == nil {
+					t.Fatalf("\t%s\tTest %d:\tShould reject %v.", failed, testID, qs)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject unknown fields, bad limits and foreign cursors.", success, testID)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	order := paging.Order{Field: "name"}
+	first := paging.NewCursor(order, "Comics", "a")
+	last := paging.NewCursor(order, "McDonalds Toys", "b")
+
+	t.Log("Given the need to link to the pages around a page.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the first page has more items after it.", testID)
+		{
+			page := paging.NewPage(paging.Request{Order: order, Limit: 2}, first, last, true)
+			if page.Next != last.Encode() || page.Prev != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould only link to the next page : %+v.", failed, testID, page)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only link to the next page.", success, testID)
+
+			u, _ := url.Parse("/v1/products?name=toys&limit=2")
+			resp := paging.NewResponse(u, []string{"Comics", "McDonalds Toys"}, page)
+			if want := "/v1/products?cursor=" + last.Encode() + "&limit=2&name=toys"; resp.Next != want {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the query string in the link : got %s, want %s.", failed, testID, resp.Next, want)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the query string in the link.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the first page is fetched backward.", testID)
+		{
+			cursor := paging.NewCursor(order, "Star Wars", "c")
+			cursor.Backward = true
+
+			page := paging.NewPage(paging.Request{Order: order, Cursor: cursor, Limit: 2}, first, last, false)
+			if page.Next != last.Encode() || page.Prev != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould only link to the next page : %+v.", failed, testID, page)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only link to the next page.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the last page is fetched forward.", testID)
+		{
+			cursor := paging.NewCursor(order, "Abacus", "z")
+
+			page := paging.NewPage(paging.Request{Order: order, Cursor: cursor, Limit: 2}, first, last, false)
+			prev, err := paging.ParseCursor(page.Prev, order)
+			if err != nil || page.Next != "" || !prev.Backward || prev.ID != first.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould only link backward to the previous page : %+v %v.", failed, testID, page, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only link backward to the previous page.", success, testID)
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	t.Log("Given the need to trim the rows fetched for a page.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a backward page has more rows.", testID)
+		{
+			cursor := paging.NewCursor(paging.Order{Field: "name"}, "d", "4")
+			cursor.Backward = true
+			req := paging.Request{Order: paging.Order{Field: "name"}, Cursor: cursor, Limit: 2}
+
+			rows := []string{"c", "b", "a"}
+			if more := req.Trim(&rows); !more || len(rows) != 2 || rows[0] != "b" || rows[1] != "c" {
+				t.Fatalf("\t%s\tTest %d:\tShould drop the extra row and restore the order : %v %v.", failed, testID, rows, more)
+			}
+			t.Logf("\t%s\tTest %d:\tShould drop the extra row and restore the order.", success, testID)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Package password provides support for hashing passwords and upgrading the
+// hashes once the configured target gets stronger.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrMismatch occurs when a password does not match its hash.
+var ErrMismatch = errors.New("password does not match")
+
+// Supported algorithms. Hashes in an algorithm other than the target one are
+// still verified but reported by NeedsRehash.
+const (
+	AlgBcrypt   = "bcrypt"
+	AlgArgon2id = "argon2id"
+)
+
+// Parameters of argon2id hashes other than the number of passes, which is
+// the cost of the target.
+const (
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 2
+	argonSaltLen = 16
+	argonKeyLen  = 32
+
+	argonMinPasses = 1
+	argonMaxPasses = 16
+)
+
+// Target represents the algorithm and cost new hashes are generated with. The
+// cost is the bcrypt cost or the number of argon2id passes.
+type Target struct {
+	Algorithm string
+	Cost      int
+}
+
+// DefaultTarget is the target used unless another one is set.
+var DefaultTarget = Target{
+	Algorithm: AlgBcrypt,
+	Cost:      bcrypt.DefaultCost,
+}
+
+var (
+	mu     sync.RWMutex
+	target = DefaultTarget
+	dummy  []byte
+)
+
+// SetTarget replaces the algorithm and cost new hashes are generated with.
+func SetTarget(t Target) error {
+	switch t.Algorithm {
+	case AlgBcrypt:
+		if t.Cost < bcrypt.MinCost || t.Cost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost %d outside [%d, %d]", t.Cost, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case AlgArgon2id:
+		if t.Cost < argonMinPasses || t.Cost > argonMaxPasses {
+			return fmt.Errorf("argon2id passes %d outside [%d, %d]", t.Cost, argonMinPasses, argonMaxPasses)
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %q", t.Algorithm)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	target = t
+	dummy = nil
+	return nil
+}
+
+// Hash generates a hash of the password with the current target.
+func Hash(password string) ([]byte, error) {
+	mu.RLock()
+	t := target
+	mu.RUnlock()
+
+	return hashWith(t, password)
+}
+
+// Compare checks the password against a hash in any supported algorithm. It
+// returns ErrMismatch when they don't match.
+func Compare(hash []byte, password string) error {
+	alg, _ := identify(hash)
+
+	switch alg {
+	case AlgBcrypt:
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return ErrMismatch
+			}
+			return fmt.Errorf("comparing bcrypt hash: %w", err)
+		}
+		return nil
+	case AlgArgon2id:
+		h, err := parseArgon2id(hash)
+		if err != nil {
+			return fmt.Errorf("parsing argon2id hash: %w", err)
+		}
+		key := argon2.IDKey([]byte(password), h.salt, h.passes, h.memory, h.threads, uint32(len(h.key)))
+		if subtle.ConstantTimeCompare(key, h.key) != 1 {
+			return ErrMismatch
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported hash format")
+	}
+}
+
+// CompareDummy spends as much time as comparing a password against a hash
+// generated with the current target. It hides whether an account exists.
+func CompareDummy(password string) {
+	mu.Lock()
+	if dummy == nil {
+		dummy, _ = hashWith(target, "dummy password")
+	}
+	hash := dummy
+	mu.Unlock()
+
+	Compare(hash, password)
+}
+
+// NeedsRehash reports if the hash was generated with another algorithm or a
+// lower cost than the current target.
+func NeedsRehash(hash []byte) bool {
+	mu.RLock()
+	t := target
+	mu.RUnlock()
+
+	alg, cost := identify(hash)
+	return alg != t.Algorithm || cost < t.Cost
+}
+
+// =============================================================================
+
+// hashWith generates a hash of the password with the given target.
+func hashWith(t Target, password string) ([]byte, error) {
+	if t.Algorithm != AlgArgon2id {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), t.Cost)
+		if err != nil {
+			return nil, fmt.Errorf("generating bcrypt hash: %w", err)
+		}
+		return hash, nil
+	}
+
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generating argon2id salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, uint32(t.Cost), argonMemory, argonThreads, argonKeyLen)
+
+	// The PHC string format keeps the parameters next to the hash.
+	hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, t.Cost, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return []byte(hash), nil
+}
+
+// argon2idHash holds the parts of an argon2id hash.
+type argon2idHash struct {
+	memory  uint32
+	passes  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2id parses an argon2id hash in the PHC string format, like
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+func parseArgon2id(hash []byte) (argon2idHash, error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != AlgArgon2id {
+		return argon2idHash{}, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2idHash{}, fmt.Errorf("unsupported version %q", parts[2])
+	}
+
+	var h argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.passes, &h.threads); err != nil {
+		return argon2idHash{}, fmt.Errorf("parsing parameters: %w", err)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2idHash{}, fmt.Errorf("decoding salt: %w", err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return argon2idHash{}, fmt.Errorf("decoding key: %w", err)
+	}
+
+	return h, nil
+}
+
+// identify returns the algorithm and the cost of a hash.
+func identify(hash []byte) (string, int) {
+	s := string(hash)
+
+	// argon2id hashes with less memory than the target count as a lower cost.
+	if strings.HasPrefix(s, "$argon2id$") {
+		if h, err := parseArgon2id(hash); err == nil {
+			if h.memory < argonMemory {
+				return AlgArgon2id, 0
+			}
+			return AlgArgon2id, int(h.passes)
+		}
+	}
+
+	// bcrypt hashes look like $2a$10$<salt and checksum>.
+	if strings.HasPrefix(s, "$2") {
+		parts := strings.SplitN(s, "$", 4)
+		if len(parts) == 4 {
+			if cost, err := strconv.Atoi(parts[2]); err == nil {
+				return AlgBcrypt, cost
+			}
+		}
+	}
+
+	return "", 0
+}
@@ -0,0 +1,111 @@
+package password_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Fiiii/WT/business/sys/password"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestRehash(t *testing.T) {
+	t.Cleanup(func() { password.SetTarget(password.DefaultTarget) })
+
+	t.Log("Given the need to upgrade password hashes.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the target cost is raised.", testID)
+		{
+			if err := password.SetTarget(password.Target{Algorithm: password.AlgBcrypt, Cost: bcrypt.MinCost}); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to set the target : %s.", failed, testID, err)
+			}
+
+			hash, err := password.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to hash : %s.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to hash.", success, testID)
+
+			if err := password.Compare(hash, "correct horse"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould match the password : %s.", failed, testID, err)
+			}
+			if err := password.Compare(hash, "wrong horse"); !errors.Is(err, password.ErrMismatch) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT match another password : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only match the password.", success, testID)
+
+			if password.NeedsRehash(hash) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT need a rehash at the target.", failed, testID)
+			}
+
+			if err := password.SetTarget(password.Target{Algorithm: password.AlgBcrypt, Cost: bcrypt.MinCost + 1}); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to raise the target : %s.", failed, testID, err)
+			}
+			if !password.NeedsRehash(hash) {
+				t.Fatalf("\t%s\tTest %d:\tShould need a rehash below the target.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould need a rehash below the target only.", success, testID)
+
+			if !password.NeedsRehash([]byte("plain text")) {
+				t.Fatalf("\t%s\tTest %d:\tShould need a rehash for unknown formats.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould need a rehash for unknown formats.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the target algorithm changes to argon2id.", testID)
+		{
+			if err := password.SetTarget(password.Target{Algorithm: password.AlgBcrypt, Cost: bcrypt.MinCost}); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to set the target : %s.", failed, testID, err)
+			}
+
+			old, err := password.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to hash : %s.", failed, testID, err)
+			}
+
+			if err := password.SetTarget(password.Target{Algorithm: password.AlgArgon2id, Cost: 1}); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to set the target : %s.", failed, testID, err)
+			}
+			if !password.NeedsRehash(old) {
+				t.Fatalf("\t%s\tTest %d:\tShould need a rehash of the bcrypt hash.", failed, testID)
+			}
+			if err := password.Compare(old, "correct horse"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould still match the bcrypt hash : %s.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould still match but rehash the bcrypt hash.", success, testID)
+
+			hash, err := password.Hash("correct horse")
+			if err != nil || !strings.HasPrefix(string(hash), "$argon2id$v=19$m=65536,t=1,p=2$") {
+				t.Fatalf("\t%s\tTest %d:\tShould hash with argon2id : %s %v.", failed, testID, hash, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould hash with argon2id.", success, testID)
+
+			if err := password.Compare(hash, "correct horse"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould match the password : %s.", failed, testID, err)
+			}
+			if err := password.Compare(hash, "wrong horse"); !errors.Is(err, password.ErrMismatch) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT match another password : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only match the password.", success, testID)
+
+			if password.NeedsRehash(hash) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT need a rehash at the target.", failed, testID)
+			}
+			if err := password.SetTarget(password.Target{Algorithm: password.AlgArgon2id, Cost: 2}); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to raise the target : %s.", failed, testID, err)
+			}
+			if !password.NeedsRehash(hash) {
+				t.Fatalf("\t%s\tTest %d:\tShould need a rehash with fewer passes.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould need a rehash below the target only.", success, testID)
+		}
+	}
+}
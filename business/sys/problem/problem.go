@@ -0,0 +1,188 @@
+// Package problem provides the errors handlers return for expected failures
+// and their representation as RFC 7807 problem details.
+package problem
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Fiiii/WT/business/sys/validate"
+)
+
+// ContentType is the media type of problem details documents.
+const ContentType = "application/problem+json"
+
+// Code is a stable, machine-readable identifier of a kind of failure.
+// Clients should branch on the code rather than on the title or detail.
+type Code string
+
+// Set of codes the API responds with.
+const (
+	CodeInvalidRequest    Code = "invalid_request"
+	CodeValidation        Code = "validation_failed"
+	CodeInvalidID         Code = "invalid_id"
+	CodeUnauthorized      Code = "unauthorized"
+	CodeForbidden         Code = "forbidden"
+	CodeNotFound          Code = "not_found"
+	CodeConflict          Code = "conflict"
+	CodeDuplicate         Code = "duplicate_entry"
+	CodeEmailTaken        Code = "email_taken"
+	CodeConstraint        Code = "constraint_violation"
+	CodeInsufficientStock Code = "insufficient_stock"
+	CodeRateLimited       Code = "rate_limited"
+	CodeInternal          Code = "internal_error"
+)
+
+// entry represents the status code and title responded with for a code.
+type entry struct {
+	status int
+	title  string
+}
+
+// catalog holds every code with its status code and title. Codes must not
+// change once released since clients depend on them.
+var catalog = map[Code]entry{
+	CodeInvalidRequest:    {http.StatusBadRequest, "Invalid request"},
+	CodeValidation:        {http.StatusBadRequest, "Data validation error"},
+	CodeInvalidID:         {http.StatusBadRequest, "Invalid ID"},
+	CodeUnauthorized:      {http.StatusUnauthorized, "Unauthorized"},
+	CodeForbidden:         {http.StatusForbidden, "Forbidden"},
+	CodeNotFound:          {http.StatusNotFound, "Resource not found"},
+	CodeConflict:          {http.StatusConflict, "Conflict"},
+	CodeDuplicate:         {http.StatusConflict, "Duplicated entry"},
+	CodeEmailTaken:        {http.StatusConflict, "Email already in use"},
+	CodeConstraint:        {http.StatusUnprocessableEntity, "Constraint violation"},
+	CodeInsufficientStock: {http.StatusConflict, "Insufficient stock"},
+	CodeRateLimited:       {http.StatusTooManyRequests, "Rate limit exceeded"},
+	CodeInternal:          {http.StatusInternalServerError, "Internal server error"},
+}
+
+// byStatus holds the generic code used for errors created with a status code.
+var byStatus = map[int]Code{
+	http.StatusBadRequest:          CodeInvalidRequest,
+	http.StatusUnauthorized:        CodeUnauthorized,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeConflict,
+	http.StatusUnprocessableEntity: CodeConstraint,
+	http.StatusTooManyRequests:     CodeRateLimited,
+	http.StatusInternalServerError: CodeInternal,
+}
+
+// Status returns the status code responded with for the code.
+func (c Code) Status() int {
+	if e, ok := catalog[c]; ok {
+		return e.status
+	}
+	return http.StatusInternalServerError
+}
+
+// Title returns the short, human-readable summary of the code.
+func (c Code) Title() string {
+	if e, ok := catalog[c]; ok {
+		return e.title
+	}
+	return http.StatusText(c.Status())
+}
+
+// Type returns the URI identifying the code in problem details.
+func (c Code) Type() string {
+	return "urn:wt:problem:" + string(c)
+}
+
+// =============================================================================
+
+// RequestError is used to pass an error during the request through the
+// application with web specific context.
+type RequestError struct {
+	Err    error
+	Code   Code
+	Status int
+}
+
+// New wraps a provided error with a code from the catalog. This function
+// should be used when handlers encounter expected errors.
+func New(code Code, err error) error {
+	return &RequestError{
+		Err:    err,
+		Code:   code,
+		Status: code.Status(),
+	}
+}
+
+// NewRequestError wraps a provided error with an HTTP status code and the
+// generic code for that status.
+func NewRequestError(err error, status int) error {
+	code, ok := byStatus[status]
+	if !ok {
+		code = CodeInvalidRequest
+		if status >= http.StatusInternalServerError {
+			code = CodeInternal
+		}
+	}
+
+	return &RequestError{
+		Err:    err,
+		Code:   code,
+		Status: status,
+	}
+}
+
+// Error implements the error interface. It uses the default message of the
+// wrapped error. This is what will be shown in the services' logs.
+func (err *RequestError) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (err *RequestError) Unwrap() error {
+	return err.Err
+}
+
+// =============================================================================
+
+// Details is the form used for API responses from failures in the API, as
+// described by RFC 7807. Instance holds the trace ID of the request so a
+// failure reported by a client can be found in the logs.
+type Details struct {
+	Type     string                `json:"type"`
+	Title    string                `json:"title"`
+	Status   int                   `json:"status"`
+	Detail   string                `json:"detail,omitempty"`
+	Instance string                `json:"instance,omitempty"`
+	Code     Code                  `json:"code"`
+	Errors   []validate.FieldError `json:"errors,omitempty"`
+}
+
+// NewDetails constructs the problem details for the code.
+func NewDetails(code Code, detail string, instance string) Details {
+	return Details{
+		Type:     code.Type(),
+		Title:    code.Title(),
+		Status:   code.Status(),
+		Detail:   detail,
+		Instance: instance,
+		Code:     code,
+	}
+}
+
+// FromError constructs the problem details for an error carrying its own
+// status, either a RequestError or field errors from validation. It returns
+// false for any other error.
+func FromError(err error, instance string) (Details, bool) {
+	var fe validate.FieldErrors
+	if errors.As(err, &fe) {
+		d := NewDetails(CodeValidation, "One or more fields are invalid.", instance)
+		d.Errors = fe
+		return d, true
+	}
+
+	var re *RequestError
+	if errors.As(err, &re) {
+		d := NewDetails(re.Code, re.Error(), instance)
+		d.Status = re.Status
+		return d, true
+	}
+
+	return Details{}, false
+}
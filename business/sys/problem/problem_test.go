@@ -0,0 +1,91 @@
+package problem_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Fiiii/WT/business/sys/problem"
+	"github.com/Fiiii/WT/business/sys/validate"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestDetails(t *testing.T) {
+	t.Log("Given the need to respond with problem details.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a handler returns a request error.", testID)
+		{
+			err := fmt.Errorf("query: %w", problem.NewRequestError(errors.New("invalid page format [x]"), http.StatusBadRequest))
+
+			pd, ok := problem.FromError(err, "4bf92f3577b34da6a3ce929d0e0e4736")
+			if !ok {
+				t.Fatalf("\t%s\tTest %d:\tShould recognize the request error.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould recognize the request error.", success, testID)
+
+			want := problem.Details{
+				Type:     "urn:wt:problem:invalid_request",
+				Title:    "Invalid request",
+				Status:   http.StatusBadRequest,
+				Detail:   "invalid page format [x]",
+				Instance: "4bf92f3577b34da6a3ce929d0e0e4736",
+				Code:     problem.CodeInvalidRequest,
+			}
+			if fmt.Sprint(pd) != fmt.Sprint(want) {
+				t.Fatalf("\t%s\tTest %d:\tShould use the generic code of the status : got %+v.", failed, testID, pd)
+			}
+			t.Logf("\t%s\tTest %d:\tShould use the generic code of the status.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen a handler returns an error with a specific code.", testID)
+		{
+			err := problem.New(problem.CodeInsufficientStock, errors.New("only 2 left"))
+
+			pd, _ := problem.FromError(err, "")
+			if pd.Code != problem.CodeInsufficientStock || pd.Status != http.StatusConflict || pd.Title != "Insufficient stock" {
+				t.Fatalf("\t%s\tTest %d:\tShould use the code from the catalog : got %+v.", failed, testID, pd)
+			}
+			t.Logf("\t%s\tTest %d:\tShould use the code from the catalog.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen validation fails.", testID)
+		{
+			err := fmt.Errorf("decode: %w", validate.FieldErrors{{Field: "email", Error: "email is a required field"}})
+
+			pd, ok := problem.FromError(err, "")
+			if !ok || pd.Code != problem.CodeValidation || pd.Status != http.StatusBadRequest {
+				t.Fatalf("\t%s\tTest %d:\tShould respond with a validation problem : got %+v.", failed, testID, pd)
+			}
+			t.Logf("\t%s\tTest %d:\tShould respond with a validation problem.", success, testID)
+
+			d, err := json.Marshal(pd)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to marshal the details : %s.", failed, testID, err)
+			}
+			const doc = `{"type":"urn:wt:problem:validation_failed","title":"Data validation error","status":400,"detail":"One or more fields are invalid.","code":"validation_failed","errors":[{"field":"email","error":"email is a required field"}]}`
+			if string(d) != doc {
+				t.Fatalf("\t%s\tTest %d:\tShould structure the field errors : got %s.", failed, testID, d)
+			}
+			t.Logf("\t%s\tTest %d:\tShould structure the field errors.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the error is not expected.", testID)
+		{
+			if _, ok := problem.FromError(errors.New("connection refused"), ""); ok {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT recognize the error.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT recognize the error.", success, testID)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Package ratelimit provides token bucket rate limiting with pluggable
+// storage for the buckets.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrLimitExceeded occurs when a bucket has no tokens left.
+var ErrLimitExceeded = errors.New("rate limit exceeded")
+
+// Limit represents a token bucket which allows Requests per Period on
+// average and bursts of up to Burst requests. Burst defaults to Requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// Result represents the state of a bucket after taking a token from it.
+// Reset is when the bucket is full again, RetryAfter when the next token is
+// available if none was left.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Store is the behavior required to keep the buckets. Implementations shared
+// by several replicas make the limits apply to the service as a whole.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// =============================================================================
+
+// bucket represents the tokens left in a bucket at the time of the last
+// update, and the limit it was last updated with.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// take refills the bucket for the time passed and takes a token if one is
+// left.
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	burst := float64(limit.burst())
+	rate := limit.rate()
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+	}
+	b.last = now
+	b.limit = limit
+
+	res := Result{
+		Limit: limit.burst(),
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((burst - b.tokens) / rate)
+
+	return res
+}
+
+// burst returns the capacity of the bucket.
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// rate returns how many tokens are added per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// seconds converts a number of seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// =============================================================================
+
+// Memory keeps the buckets in memory, so every replica of the service limits
+// on its own.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemory constructs an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Take takes a token from the bucket of the key. It implements the Store
+// interface.
+func (m *Memory) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+
+	b, exists := m.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(limit.burst()), last: now}
+		m.buckets[key] = b
+	}
+
+	return b.take(limit, now), nil
+}
+
+// sweepInterval is how often buckets which have been idle long enough to be
+// full again are dropped.
+const sweepInterval = time.Minute
+
+// sweep drops the buckets which are full again, since a new bucket starts
+// out full anyway.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for key, b := range m.buckets {
+		refilled := b.tokens + now.Sub(b.last).Seconds()*b.limit.rate()
+		if refilled >= float64(b.limit.burst()) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/business/sys/ratelimit"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestMemory(t *testing.T) {
+	t.Log("Given the need to limit requests with token buckets.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a client bursts past its limit.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+			store := ratelimit.NewMemory()
+			limit := ratelimit.Limit{Requests: 2, Period: time.Second, Burst: 3}
+
+			for i := 0; i < 3; i++ {
+				res, err := store.Take(ctx, "ip:10.0.0.1", limit, now)
+				if err != nil || !res.Allowed || res.Remaining != 2-i {
+					t.Fatalf("\t%s\tTest %d:\tShould allow the burst : %+v %v.", failed, testID, res, err)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould allow the burst.", success, testID)
+
+			res, err := store.Take(ctx, "ip:10.0.0.1", limit, now)
+			if err != nil || res.Allowed || res.RetryAfter != 500*time.Millisecond {
+				t.Fatalf("\t%s\tTest %d:\tShould deny past the burst until the next token : %+v %v.", failed, testID, res, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould deny past the burst until the next token.", success, testID)
+
+			if res, _ := store.Take(ctx, "ip:10.0.0.2", limit, now); !res.Allowed {
+				t.Fatalf("\t%s\tTest %d:\tShould keep a bucket per key : %+v.", failed, testID, res)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep a bucket per key.", success, testID)
+
+			res, _ = store.Take(ctx, "ip:10.0.0.1", limit, now.Add(500*time.Millisecond))
+			if !res.Allowed || res.Remaining != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould refill at the rate : %+v.", failed, testID, res)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refill at the rate.", success, testID)
+
+			res, _ = store.Take(ctx, "ip:10.0.0.1", limit, now.Add(time.Hour))
+			if !res.Allowed || res.Remaining != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT refill past the burst : %+v.", failed, testID, res)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT refill past the burst.", success, testID)
+		}
+	}
+}
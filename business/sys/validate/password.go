@@ -0,0 +1,179 @@
+package validate
+
+import (
+	_ "embed"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+)
+
+// PasswordPolicy represents the rules the password tag enforces. MinClasses
+// is how many of the classes lower case, upper case, digits and symbols a
+// password must use. MaxLength is in bytes since bcrypt ignores anything
+// past 72 bytes.
+type PasswordPolicy struct {
+	MinLength  int
+	MaxLength  int
+	MinClasses int
+}
+
+// DefaultPasswordPolicy is the policy enforced unless another one is set.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:  8,
+	MaxLength:  72,
+	MinClasses: 2,
+}
+
+var (
+	policyMu sync.RWMutex
+	policy   = DefaultPasswordPolicy
+)
+
+// commonPasswordsList is the deny-list of passwords which are too common to
+// be allowed regardless of the policy, one per line.
+//
+//go:embed common_passwords.txt
+var commonPasswordsList string
+
+var commonPasswords = loadCommonPasswords(commonPasswordsList)
+
+// SetPasswordPolicy replaces the policy the password tag enforces.
+func SetPasswordPolicy(p PasswordPolicy) error {
+	if p.MinLength < 1 || p.MaxLength < p.MinLength || p.MinClasses < 0 || p.MinClasses > 4 {
+		return fmt.Errorf("invalid password policy %+v", p)
+	}
+
+	policyMu.Lock()
+	defer policyMu.Unlock()
+
+	policy = p
+	return nil
+}
+
+// CheckPassword reports why the password does not meet the policy. The email,
+// when not empty, is what the password must not resemble.
+func CheckPassword(password string, email string) error {
+	policyMu.RLock()
+	p := policy
+	policyMu.RUnlock()
+
+	switch {
+	case utf8.RuneCountInString(password) < p.MinLength:
+		return fmt.Errorf("must be at least %d characters", p.MinLength)
+	case len(password) > p.MaxLength:
+		return fmt.Errorf("must be at most %d bytes", p.MaxLength)
+	case passwordClasses(password) < p.MinClasses:
+		return fmt.Errorf("must use %d of lower case, upper case, digits and symbols", p.MinClasses)
+	}
+
+	lower := strings.ToLower(password)
+	if _, exists := commonPasswords[lower]; exists {
+		return fmt.Errorf("is too common")
+	}
+
+	if email != "" {
+		email = strings.ToLower(email)
+		local := strings.SplitN(email, "@", 2)[0]
+		if lower == email || lower == local {
+			return fmt.Errorf("must not match the email")
+		}
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// registerPassword registers the password tag. The optional parameter names
+// the field of the same struct holding the email, as in password=Email.
+func registerPassword(v *validator.Validate, trans ut.Translator) error {
+	fn := func(fl validator.FieldLevel) bool {
+		return CheckPassword(fl.Field().String(), siblingString(fl)) == nil
+	}
+	if err := v.RegisterValidation("password", fn); err != nil {
+		return err
+	}
+
+	register := func(ut ut.Translator) error {
+		return ut.Add("password", "{0} {1}", true)
+	}
+	translate := func(ut ut.Translator, fe validator.FieldError) string {
+
+		// Without the email the only reason left is matching the email.
+		reason := "must not match the email"
+		if s, ok := fe.Value().(string); ok {
+			if err := CheckPassword(s, ""); err != nil {
+				reason = err.Error()
+			}
+		}
+
+		t, _ := ut.T("password", fe.Field(), reason)
+		return t
+	}
+
+	return v.RegisterTranslation("password", trans, register, translate)
+}
+
+// siblingString returns the value of the field named by the tag parameter,
+// following a pointer if needed.
+func siblingString(fl validator.FieldLevel) string {
+	if fl.Param() == "" {
+		return ""
+	}
+
+	parent := fl.Parent()
+	for parent.Kind() == reflect.Ptr {
+		parent = parent.Elem()
+	}
+
+	f := parent.FieldByName(fl.Param())
+	for f.IsValid() && f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			return ""
+		}
+		f = f.Elem()
+	}
+
+	if !f.IsValid() || f.Kind() != reflect.String {
+		return ""
+	}
+
+	return f.String()
+}
+
+// passwordClasses counts the character classes the password uses.
+func passwordClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// loadCommonPasswords parses the deny-list. Empty lines and lines starting
+// with # are ignored.
+func loadCommonPasswords(list string) map[string]struct{} {
+	m := make(map[string]struct{})
+	for _, line := range strings.Split(list, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			m[strings.ToLower(line)] = struct{}{}
+		}
+	}
+
+	return m
+}
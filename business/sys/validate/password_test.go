@@ -0,0 +1,49 @@
+package validate_test
+
+import (
+	"testing"
+
+	"github.com/Fiiii/WT/business/sys/validate"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestPassword(t *testing.T) {
+	type account struct {
+		Email    string `json:"email"`
+		Password string `json:"password" validate:"password=Email"`
+	}
+
+	tt := []struct {
+		name     string
+		email    string
+		password string
+		valid    bool
+	}{
+		{"strong", "fii@example.com", "Correct horse", true},
+		{"too short", "fii@example.com", "Sh0rt", false},
+		{"single class", "fii@example.com", "correcthorse", false},
+		{"common", "fii@example.com", "Password1", false},
+		{"email", "fiiFII1@example.com", "FIIfii1@example.com", false},
+		{"local part", "Fiifii1@example.com", "fiifii1", false},
+		{"too long", "fii@example.com", string(make([]byte, 73)) + "aA", false},
+	}
+
+	t.Log("Given the need to enforce the password policy.")
+	{
+		for testID, tc := range tt {
+			t.Logf("\tTest %d:\tWhen checking a %s password.", testID, tc.name)
+			{
+				err := validate.Check(account{Email: tc.email, Password: tc.password})
+				if (err == nil) != tc.valid {
+					t.Fatalf("\t%s\tTest %d:\tShould get valid %v : %v.", failed, testID, tc.valid, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get valid %v.", success, testID, tc.valid)
+			}
+		}
+	}
+}
@@ -33,6 +33,11 @@ func init() {
 		return
 	}
 
+	// Register the password policy tag with its error message.
+	if err := registerPassword(validate, translator); err != nil {
+		return
+	}
+
 	// Use JSON tag names for errors instead of Go struct names.
 	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
 		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
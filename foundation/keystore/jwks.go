@@ -0,0 +1,230 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// JWK represents a single public JSON Web Key as defined by RFC 7517. RSA keys
+// use N and E, EC and OKP (RFC 8037) keys use Curve, X and, for EC, Y.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS represents a JSON Web Key Set document.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// curves maps the supported EC curves to their JWK name and JWT algorithm.
+var curves = []struct {
+	curve elliptic.Curve
+	name  string
+	alg   string
+}{
+	{elliptic.P256(), "P-256", "ES256"},
+	{elliptic.P384(), "P-384", "ES384"},
+	{elliptic.P521(), "P-521", "ES512"},
+}
+
+// NewJWK constructs the JWK describing an RSA, ECDSA or Ed25519 public key
+// used for signatures.
+func NewJWK(kid string, publicKey crypto.PublicKey) (JWK, error) {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk := JWK{
+			KeyType:   "RSA",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}
+		return jwk, nil
+
+	case *ecdsa.PublicKey:
+		for _, c := range curves {
+			if publicKey.Curve != c.curve {
+				continue
+			}
+
+			// X and Y are padded to the size of the curve as RFC 7518
+			// requires.
+			size := (c.curve.Params().BitSize + 7) / 8
+			x := publicKey.X.FillBytes(make([]byte, size))
+			y := publicKey.Y.FillBytes(make([]byte, size))
+
+			jwk := JWK{
+				KeyType:   "EC",
+				KeyID:     kid,
+				Use:       "sig",
+				Algorithm: c.alg,
+				Curve:     c.name,
+				X:         base64.RawURLEncoding.EncodeToString(x),
+				Y:         base64.RawURLEncoding.EncodeToString(y),
+			}
+			return jwk, nil
+		}
+		return JWK{}, errors.New("unsupported EC curve")
+
+	case ed25519.PublicKey:
+		jwk := JWK{
+			KeyType:   "OKP",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: "EdDSA",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(publicKey),
+		}
+		return jwk, nil
+	}
+
+	return JWK{}, fmt.Errorf("unsupported public key type %T", publicKey)
+}
+
+// PublicKey decodes the public key described by the JWK.
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		return jwk.rsaPublicKey()
+	case "EC":
+		return jwk.ecdsaPublicKey()
+	case "OKP":
+		return jwk.ed25519PublicKey()
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", jwk.KeyType)
+}
+
+// rsaPublicKey decodes the RSA public key described by the JWK.
+func (jwk JWK) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("decoding modulus: %w", err)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("decoding exponent: %w", err)
+	}
+
+	exp := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 2 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA key parameters")
+	}
+
+	publicKey := rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exp.Int64()),
+	}
+
+	return &publicKey, nil
+}
+
+// ecdsaPublicKey decodes the ECDSA public key described by the JWK.
+func (jwk JWK) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("decoding x: %w", err)
+	}
+
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("decoding y: %w", err)
+	}
+
+	for _, c := range curves {
+		if jwk.Curve != c.name {
+			continue
+		}
+
+		// Unmarshal checks the size of the coordinates and that the point
+		// is on the curve.
+		point := append([]byte{4}, x...)
+		point = append(point, y...)
+
+		px, py := elliptic.Unmarshal(c.curve, point)
+		if px == nil {
+			return nil, errors.New("invalid EC key parameters")
+		}
+
+		publicKey := ecdsa.PublicKey{
+			Curve: c.curve,
+			X:     px,
+			Y:     py,
+		}
+		return &publicKey, nil
+	}
+
+	return nil, fmt.Errorf("unsupported EC curve %q", jwk.Curve)
+}
+
+// ed25519PublicKey decodes the Ed25519 public key described by the JWK.
+func (jwk JWK) ed25519PublicKey() (ed25519.PublicKey, error) {
+	if jwk.Curve != "Ed25519" {
+		return nil, fmt.Errorf("unsupported OKP curve %q", jwk.Curve)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("decoding x: %w", err)
+	}
+
+	if len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 key parameters")
+	}
+
+	return ed25519.PublicKey(x), nil
+}
+
+// JWKS returns the public keys of the store, including retired keys within
+// their grace period, as a JSON Web Key Set ordered by key id. Keys of a type
+// JWK cannot describe are left out.
+func (ks *KeyStore) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	jwks := JWKS{
+		Keys: make([]JWK, 0, len(ks.store)+len(ks.retired)),
+	}
+	for kid, privateKey := range ks.store {
+		if jwk, err := NewJWK(kid, privateKey.Public()); err == nil {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+
+	// Keep publishing retired keys until their grace period ends, tokens
+	// signed with them are still valid.
+	now := time.Now()
+	for kid, rk := range ks.retired {
+		if !now.Before(rk.expires) {
+			continue
+		}
+		if jwk, err := NewJWK(kid, rk.publicKey); err == nil {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID
+	})
+
+	return jwks
+}
@@ -3,35 +3,51 @@
 package keystore
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
-	"github.com/golang-jwt/jwt/v4"
 	"io"
 	"io/fs"
 	"path"
 	"strings"
 	"sync"
+	"time"
 )
 
 // KeyStore represents an in memory store implementation of the
 // KeyStorer interface for use with the auth package.
 type KeyStore struct {
-	mu    sync.RWMutex
-	store map[string]*rsa.PrivateKey
+	mu      sync.RWMutex
+	store   map[string]crypto.Signer
+	retired map[string]retiredKey
+}
+
+// retiredKey is a key removed from the store by a reload. It keeps verifying
+// tokens until it expires but can no longer sign new ones.
+type retiredKey struct {
+	publicKey crypto.PublicKey
+	expires   time.Time
 }
 
 // New constructs an empty KeyStore ready for use.
 func New() *KeyStore {
 	return &KeyStore{
-		store: make(map[string]*rsa.PrivateKey),
+		store:   make(map[string]crypto.Signer),
+		retired: make(map[string]retiredKey),
 	}
 }
 
 // NewMap constructs a KeyStore with an initial set of keys.
-func NewMap(store map[string]*rsa.PrivateKey) *KeyStore {
+func NewMap(store map[string]crypto.Signer) *KeyStore {
 	return &KeyStore{
-		store: store,
+		store:   store,
+		retired: make(map[string]retiredKey),
 	}
 }
 
@@ -40,9 +56,74 @@ func NewMap(store map[string]*rsa.PrivateKey) *KeyStore {
 // Example: keystore.NewFS(os.DirFS("/zarf/keys/"))
 // Example: /zarf/keys/54bb2165-71e1-41a6-af3e-7da4a0e1e2c1.pem
 func NewFS(fsys fs.FS) (*KeyStore, error) {
-	ks := KeyStore{
-		store: make(map[string]*rsa.PrivateKey),
+	store, err := readFS(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMap(store), nil
+}
+
+// Reload replaces the keys of the store with the PEM files rooted inside of
+// the directory. Keys which are no longer present are retired: they keep
+// verifying tokens for the grace period but can no longer sign. The store is
+// left untouched if any of the files cannot be read.
+func (ks *KeyStore) Reload(fsys fs.FS, grace time.Duration) error {
+	store, err := readFS(fsys)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	now := time.Now()
+	for kid, privateKey := range ks.store {
+		if _, found := store[kid]; !found {
+			ks.retired[kid] = retiredKey{
+				publicKey: privateKey.Public(),
+				expires:   now.Add(grace),
+			}
+		}
+	}
+
+	for kid, rk := range ks.retired {
+		if _, found := store[kid]; found || !now.Before(rk.expires) {
+			delete(ks.retired, kid)
+		}
+	}
+
+	ks.store = store
+	return nil
+}
+
+// Watch polls the directory every interval and reloads the store until the
+// context is cancelled. After every poll fn, when not nil, is called with the
+// result of the reload. This supports rotating keys mounted from a Kubernetes
+// Secret without restarting the service.
+func (ks *KeyStore) Watch(ctx context.Context, fsys fs.FS, interval time.Duration, grace time.Duration, fn func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := ks.Reload(fsys, grace)
+			if fn != nil {
+				fn(err)
+			}
+		}
 	}
+}
+
+// readFS parses the PEM files rooted inside of a directory into a set of
+// keys. The name of each PEM file will be used as the key id. Hidden
+// directories are skipped, which ignores the timestamped copies Kubernetes
+// keeps next to the files of a mounted Secret.
+func readFS(fsys fs.FS) (map[string]crypto.Signer, error) {
+	store := make(map[string]crypto.Signer)
 
 	fn := func(fileName string, dirEntry fs.DirEntry, err error) error {
 		if err != nil {
@@ -50,6 +131,9 @@ func NewFS(fsys fs.FS) (*KeyStore, error) {
 		}
 
 		if dirEntry.IsDir() {
+			if fileName != "." && strings.HasPrefix(dirEntry.Name(), ".") {
+				return fs.SkipDir
+			}
 			return nil
 		}
 
@@ -71,12 +155,12 @@ func NewFS(fsys fs.FS) (*KeyStore, error) {
 			return fmt.Errorf("reading auth private key: %w", err)
 		}
 
-		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+		privateKey, err := parsePrivateKey(privatePEM)
 		if err != nil {
 			return fmt.Errorf("parsing auth private key: %w", err)
 		}
 
-		ks.store[strings.TrimSuffix(dirEntry.Name(), ".pem")] = privateKey
+		store[strings.TrimSuffix(dirEntry.Name(), ".pem")] = privateKey
 		return nil
 	}
 
@@ -84,11 +168,44 @@ func NewFS(fsys fs.FS) (*KeyStore, error) {
 		return nil, fmt.Errorf("walking directory: %w", err)
 	}
 
-	return &ks, nil
+	return store, nil
+}
+
+// parsePrivateKey decodes a PEM encoded RSA, ECDSA or Ed25519 private key in
+// PKCS #1, SEC 1 or PKCS #8 form.
+func parsePrivateKey(privatePEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(privatePEM)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+
+	if privateKey, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("unsupported private key encoding")
+	}
+
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return privateKey, nil
+	case *ecdsa.PrivateKey:
+		return privateKey, nil
+	case ed25519.PrivateKey:
+		return privateKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
 }
 
 // Add adds a private key and combination kid to the store.
-func (ks *KeyStore) Add(privateKey *rsa.PrivateKey, kid string) {
+func (ks *KeyStore) Add(privateKey crypto.Signer, kid string) {
 	ks.mu.Lock()
 	defer ks.mu.Unlock()
 
@@ -105,7 +222,7 @@ func (ks *KeyStore) Remove(kid string) {
 
 // PrivateKey searches the key store for a given kid and returns
 // the private key.
-func (ks *KeyStore) PrivateKey(kid string) (*rsa.PrivateKey, error) {
+func (ks *KeyStore) PrivateKey(kid string) (crypto.Signer, error) {
 	ks.mu.RLock()
 	defer ks.mu.RUnlock()
 
@@ -117,14 +234,19 @@ func (ks *KeyStore) PrivateKey(kid string) (*rsa.PrivateKey, error) {
 }
 
 // PublicKey searches the key store for a given kid and returns
-// the public key.
-func (ks *KeyStore) PublicKey(kid string) (*rsa.PublicKey, error) {
+// the public key. Retired keys are found until their grace period ends.
+func (ks *KeyStore) PublicKey(kid string) (crypto.PublicKey, error) {
 	ks.mu.RLock()
 	defer ks.mu.RUnlock()
 
 	privateKey, found := ks.store[kid]
-	if !found {
+	if found {
+		return privateKey.Public(), nil
+	}
+
+	rk, found := ks.retired[kid]
+	if !found || !time.Now().Before(rk.expires) {
 		return nil, errors.New("kid lookup failed")
 	}
-	return &privateKey.PublicKey, nil
+	return rk.publicKey, nil
 }
\ No newline at end of file
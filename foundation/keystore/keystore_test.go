@@ -0,0 +1,306 @@
+package keystore_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/Fiiii/WT/foundation/keystore"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestRemote(t *testing.T) {
+	t.Log("Given the need to verify tokens with keys published as JWKS.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen fetching the keys of a remote key store.", testID)
+		{
+			const (
+				kid1 = "54bb2165-71e1-41a6-af3e-7da4a0e1e2c1"
+				kid2 = "4754d86b-7a6d-4df5-9c65-224741361492"
+			)
+
+			ks := keystore.New()
+			ks.Add(newKey(t), kid1)
+
+			var fetches int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&fetches, 1)
+				json.NewEncoder(w).Encode(ks.JWKS())
+			}))
+			defer srv.Close()
+
+			remote := keystore.NewRemote(srv.URL, nil, time.Hour)
+
+			want, _ := ks.PublicKey(kid1)
+			got, err := remote.PublicKey(kid1)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to look up a published key: %v", failed, testID, err)
+			}
+			if !want.(*rsa.PublicKey).Equal(got) {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the same public key.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to look up a published key.", success, testID)
+
+			if _, err := remote.PrivateKey(kid1); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to look up a private key.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to look up a private key.", success, testID)
+
+			ks.Add(newKey(t), kid2)
+
+			if _, err := remote.PublicKey(kid2); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT fetch again within the minimum interval.", failed, testID)
+			}
+			if n := atomic.LoadInt32(&fetches); n != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould have fetched once: got %d", failed, testID, n)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT fetch again within the minimum interval.", success, testID)
+
+			remote = keystore.NewRemote(srv.URL, nil, 0)
+			if _, err := remote.PublicKey(kid1); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to look up a published key: %v", failed, testID, err)
+			}
+
+			ks.Remove(kid1)
+			ks.Add(newKey(t), "rotated")
+
+			if _, err := remote.PublicKey("rotated"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould fetch again for an unknown kid: %v", failed, testID, err)
+			}
+			if _, err := remote.PublicKey(kid1); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould drop keys no longer published.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould fetch again for an unknown kid.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen a key is removed upstream.", testID)
+		{
+			const kid = "54bb2165-71e1-41a6-af3e-7da4a0e1e2c1"
+
+			ks := keystore.New()
+			ks.Add(newKey(t), kid)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(ks.JWKS())
+			}))
+			defer srv.Close()
+
+			remote := keystore.NewRemote(srv.URL, nil, time.Hour)
+			if _, err := remote.PublicKey(kid); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to look up a published key: %v", failed, testID, err)
+			}
+
+			ks.Remove(kid)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			fetched := make(chan error, 1)
+			go remote.Watch(ctx, 10*time.Millisecond, func(err error) {
+				select {
+				case fetched <- err:
+				default:
+				}
+			})
+
+			if err := <-fetched; err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to fetch while watching: %v", failed, testID, err)
+			}
+			if _, err := remote.PublicKey(kid); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould drop the removed key once fetched again.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould drop the removed key once fetched again.", success, testID)
+		}
+	}
+}
+
+func TestKeyTypes(t *testing.T) {
+	rsaKey := newKey(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to create an EC key: %v", failed, err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to create an Ed25519 key: %v", failed, err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to encode an EC key: %v", failed, err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to encode an Ed25519 key: %v", failed, err)
+	}
+
+	tt := []struct {
+		kid   string
+		block pem.Block
+		key   crypto.Signer
+	}{
+		{"rsa", pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, rsaKey},
+		{"ec", pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}, ecKey},
+		{"ed", pem.Block{Type: "PRIVATE KEY", Bytes: edDER}, edKey},
+	}
+
+	fsys := fstest.MapFS{}
+	for _, tc := range tt {
+		fsys[tc.kid+".pem"] = &fstest.MapFile{Data: pem.EncodeToMemory(&tc.block)}
+	}
+
+	t.Log("Given the need to support RSA, ECDSA and Ed25519 keys.")
+	{
+		ks, err := keystore.NewFS(fsys)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to load the keys: %v", failed, err)
+		}
+
+		data, err := json.Marshal(ks.JWKS())
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to encode the JWKS: %v", failed, err)
+		}
+
+		var jwks keystore.JWKS
+		if err := json.Unmarshal(data, &jwks); err != nil {
+			t.Fatalf("\t%s\tShould be able to decode the JWKS: %v", failed, err)
+		}
+
+		published := make(map[string]keystore.JWK)
+		for _, jwk := range jwks.Keys {
+			published[jwk.KeyID] = jwk
+		}
+
+		for testID, tc := range tt {
+			t.Logf("\tTest %d:\tWhen loading a %s key.", testID, tc.kid)
+			{
+				privateKey, err := ks.PrivateKey(tc.kid)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to look up the private key: %v", failed, testID, err)
+				}
+				want := tc.key.Public().(interface{ Equal(crypto.PublicKey) bool })
+				if !want.Equal(privateKey.Public()) {
+					t.Fatalf("\t%s\tTest %d:\tShould get back the same key.", failed, testID)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get back the same key.", success, testID)
+
+				publicKey, err := published[tc.kid].PublicKey()
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to decode the JWK: %v", failed, testID, err)
+				}
+				if !want.Equal(publicKey) {
+					t.Fatalf("\t%s\tTest %d:\tShould publish the same public key.", failed, testID)
+				}
+				t.Logf("\t%s\tTest %d:\tShould publish the same public key.", success, testID)
+			}
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Log("Given the need to rotate keys without restarting the service.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the key directory changes.", testID)
+		{
+			const (
+				kid1 = "54bb2165-71e1-41a6-af3e-7da4a0e1e2c1"
+				kid2 = "4754d86b-7a6d-4df5-9c65-224741361492"
+			)
+
+			fsys := fstest.MapFS{
+				kid1 + ".pem": &fstest.MapFile{Data: pemKey(t)},
+			}
+
+			ks, err := keystore.NewFS(fsys)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to load the keys: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to load the keys.", success, testID)
+
+			delete(fsys, kid1+".pem")
+			fsys[kid2+".pem"] = &fstest.MapFile{Data: pemKey(t)}
+			fsys["..2022_01_01/"+kid1+".pem"] = &fstest.MapFile{Data: []byte("stale copy")}
+
+			if err := ks.Reload(fsys, time.Hour); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reload the keys: %v", failed, testID, err)
+			}
+			if _, err := ks.PrivateKey(kid2); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to sign with the new key: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to sign with the new key.", success, testID)
+
+			if _, err := ks.PrivateKey(kid1); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to sign with a retired key.", failed, testID)
+			}
+			if _, err := ks.PublicKey(kid1); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould verify with a retired key within the grace period: %v", failed, testID, err)
+			}
+			if n := len(ks.JWKS().Keys); n != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould publish the retired key: got %d keys", failed, testID, n)
+			}
+			t.Logf("\t%s\tTest %d:\tShould verify with a retired key within the grace period.", success, testID)
+
+			fsys["broken.pem"] = &fstest.MapFile{Data: []byte("not a key")}
+			if err := ks.Reload(fsys, 0); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould fail to reload a broken key.", failed, testID)
+			}
+			if _, err := ks.PublicKey(kid1); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the current keys on a failed reload: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the current keys on a failed reload.", success, testID)
+
+			delete(fsys, "broken.pem")
+			if err := ks.Reload(fsys, 0); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reload the keys: %v", failed, testID, err)
+			}
+			if _, err := ks.PublicKey(kid1); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould verify with a retired key within the grace period: %v", failed, testID, err)
+			}
+
+			ks, _ = keystore.NewFS(fstest.MapFS{kid1 + ".pem": &fstest.MapFile{Data: pemKey(t)}})
+			if err := ks.Reload(fsys, 0); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reload the keys: %v", failed, testID, err)
+			}
+			if _, err := ks.PublicKey(kid1); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT verify with a retired key after the grace period.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT verify with a retired key after the grace period.", success, testID)
+		}
+	}
+}
+
+func pemKey(t *testing.T) []byte {
+	block := pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(newKey(t)),
+	}
+	return pem.EncodeToMemory(&block)
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to create a private key: %v", failed, err)
+	}
+	return privateKey
+}
@@ -0,0 +1,176 @@
+package keystore
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrNoPrivateKey is returned by stores which only hold public keys.
+var ErrNoPrivateKey = errors.New("private keys are not available")
+
+// Remote represents a verifier only implementation of the KeyStorer interface
+// backed by a JWKS document served by another service. Keys are cached and
+// the document is fetched again when an unknown kid is requested, but never
+// more often than the configured minimum interval. Keys removed upstream are
+// only dropped by a new fetch, see Watch.
+type Remote struct {
+	url         string
+	client      *http.Client
+	minInterval time.Duration
+
+	fetchMu sync.Mutex
+	mu      sync.RWMutex
+	store   map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewRemote constructs a Remote fetching the JWKS document from url. A nil
+// client uses a client with a 5 second timeout.
+// Example: keystore.NewRemote("http://wt-api:3000/.well-known/jwks.json", nil, time.Minute)
+func NewRemote(url string, client *http.Client, minInterval time.Duration) *Remote {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	return &Remote{
+		url:         url,
+		client:      client,
+		minInterval: minInterval,
+		store:       make(map[string]crypto.PublicKey),
+	}
+}
+
+// Refresh fetches the JWKS document and replaces the cached keys with it.
+func (r *Remote) Refresh(ctx context.Context) error {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	return r.fetch(ctx)
+}
+
+// Watch fetches the JWKS document every interval until the context is
+// cancelled, so keys removed upstream stop being trusted. After every fetch
+// fn, when not nil, is called with its result. A failed fetch keeps the keys
+// of the last one.
+func (r *Remote) Watch(ctx context.Context, interval time.Duration, fn func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := r.Refresh(ctx)
+			if fn != nil {
+				fn(err)
+			}
+		}
+	}
+}
+
+// PrivateKey always fails since a Remote only knows public keys.
+func (r *Remote) PrivateKey(kid string) (crypto.Signer, error) {
+	return nil, ErrNoPrivateKey
+}
+
+// PublicKey searches the cached keys for a given kid and returns the public
+// key. An unknown kid triggers a new fetch of the JWKS document unless the
+// last fetch happened less than the minimum interval ago.
+func (r *Remote) PublicKey(kid string) (crypto.PublicKey, error) {
+	if publicKey, found := r.lookup(kid); found {
+		return publicKey, nil
+	}
+
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	// Another goroutine may have fetched the document while we waited.
+	if publicKey, found := r.lookup(kid); found {
+		return publicKey, nil
+	}
+
+	r.mu.RLock()
+	fetched := r.fetched
+	r.mu.RUnlock()
+
+	if time.Since(fetched) < r.minInterval {
+		return nil, errors.New("kid lookup failed")
+	}
+
+	if err := r.fetch(context.Background()); err != nil {
+		return nil, fmt.Errorf("kid lookup failed: %w", err)
+	}
+
+	publicKey, found := r.lookup(kid)
+	if !found {
+		return nil, errors.New("kid lookup failed")
+	}
+	return publicKey, nil
+}
+
+// lookup returns the cached public key for a given kid.
+func (r *Remote) lookup(kid string) (crypto.PublicKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	publicKey, found := r.store[kid]
+	return publicKey, found
+}
+
+// fetch downloads and decodes the JWKS document. The caller must hold fetchMu.
+func (r *Remote) fetch(ctx context.Context) error {
+
+	// Record the attempt even if it fails, so a failing endpoint is not
+	// hammered by requests carrying unknown kids.
+	r.mu.Lock()
+	r.fetched = time.Now()
+	r.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching jwks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	// limit the document size to 1 megabyte, the same as for PEM files.
+	var jwks JWKS
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&jwks); err != nil {
+		return fmt.Errorf("decoding jwks: %w", err)
+	}
+
+	store := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		publicKey, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		store[jwk.KeyID] = publicKey
+	}
+
+	r.mu.Lock()
+	r.store = store
+	r.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,51 @@
+// Package mailer provides support for sending plain text email through
+// interchangeable transports.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Message represents a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is the behavior required to deliver a message.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Bytes renders the message as RFC 5322 text sent from the given address.
+func (m Message) Bytes(from string, now time.Time) ([]byte, error) {
+	if _, err := mail.ParseAddress(m.To); err != nil {
+		return nil, fmt.Errorf("parsing recipient: %w", err)
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return nil, fmt.Errorf("subject must be a single line")
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+
+	// SMTP requires CRLF line endings in the body as well.
+	body := strings.ReplaceAll(m.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,162 @@
+package mailer_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/foundation/mailer"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestMessage(t *testing.T) {
+	t.Log("Given the need to render messages.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling a plain text message.", testID)
+		{
+			msg := mailer.Message{
+				To:      "fii@example.com",
+				Subject: "Reset your password",
+				Body:    "line one\nline two",
+			}
+			now := time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			data, err := msg.Bytes("WT <noreply@example.com>", now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to render the message : %s.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to render the message.", success, testID)
+
+			s := string(data)
+			for _, want := range []string{"To: fii@example.com\r\n", "Subject: Reset your password\r\n", "\r\n\r\nline one\r\nline two\r\n"} {
+				if !strings.Contains(s, want) {
+					t.Fatalf("\t%s\tTest %d:\tShould contain %q : %q.", failed, testID, want, s)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould use CRLF line endings.", success, testID)
+
+			msg.Subject = "hi\r\nBcc: victim@example.com"
+			if _, err := msg.Bytes("noreply@example.com", now); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to inject headers.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to inject headers.", success, testID)
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go serveSMTP(l, received)
+
+	t.Log("Given the need to deliver messages through a relay.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the relay accepts the message.", testID)
+		{
+			host, port, _ := net.SplitHostPort(l.Addr().String())
+			p, _ := strconv.Atoi(port)
+
+			m, err := mailer.NewSMTP(mailer.SMTPConfig{Host: host, Port: p, From: "noreply@example.com"})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to construct the mailer : %s.", failed, testID, err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			msg := mailer.Message{To: "fii@example.com", Subject: "Hello", Body: "hello there"}
+			if err := m.Send(ctx, msg); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to send : %s.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to send.", success, testID)
+
+			if data := <-received; !strings.Contains(data, "hello there") {
+				t.Fatalf("\t%s\tTest %d:\tShould deliver the body : %q.", failed, testID, data)
+			}
+			t.Logf("\t%s\tTest %d:\tShould deliver the body.", success, testID)
+		}
+	}
+}
+
+// serveSMTP accepts a single connection and plays the part of a relay
+// without extensions, sending the received data on the channel.
+func serveSMTP(l net.Listener, received chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			received <- data.String()
+			reply("250 queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	t.Log("Given the need to capture messages in tests.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen sending to several recipients.", testID)
+		{
+			m := mailer.NewMemory()
+			m.Send(context.Background(), mailer.Message{To: "a@example.com", Body: "first"})
+			m.Send(context.Background(), mailer.Message{To: "b@example.com", Body: "other"})
+			m.Send(context.Background(), mailer.Message{To: "a@example.com", Body: "second"})
+
+			if got := len(m.Messages()); got != 3 {
+				t.Fatalf("\t%s\tTest %d:\tShould record every message : got %d.", failed, testID, got)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record every message.", success, testID)
+
+			if msg, ok := m.Last("a@example.com"); !ok || msg.Body != "second" {
+				t.Fatalf("\t%s\tTest %d:\tShould find the last message of a recipient : %+v.", failed, testID, msg)
+			}
+			t.Logf("\t%s\tTest %d:\tShould find the last message of a recipient.", success, testID)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory keeps messages in memory instead of delivering them. It is meant
+// for tests only since messages are never released.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemory constructs an empty in-memory mailer.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Send records the message. It implements the Mailer interface.
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of every message sent so far.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+// Last returns the most recent message sent to the recipient.
+func (m *Memory) Last(to string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i], true
+		}
+	}
+
+	return Message{}, false
+}
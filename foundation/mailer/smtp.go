@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTPConfig represents the relay messages are delivered through. The
+// connection is upgraded with STARTTLS when the relay supports it and
+// credentials are only sent over TLS.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTP delivers messages through an SMTP relay.
+type SMTP struct {
+	cfg SMTPConfig
+}
+
+// NewSMTP constructs a mailer for the specified relay.
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("smtp host is required")
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("parsing from address: %w", err)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+
+	return &SMTP{cfg: cfg}, nil
+}
+
+// Send delivers the message to the relay. It implements the Mailer interface.
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := msg.Bytes(s.cfg.From, time.Now())
+	if err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(s.cfg.From)
+	if err != nil {
+		return fmt.Errorf("parsing from address: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("parsing recipient: %w", err)
+	}
+
+	addr := net.JoinHostPort(s.cfg.Host, fmt.Sprint(s.cfg.Port))
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("dialing %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	// net/smtp does not take a context, so bound the whole exchange by the
+	// deadline of the context instead.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		return fmt.Errorf("greeting: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.cfg.Host}); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+
+	if s.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("rcpt: %w", err)
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("data: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("writing message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("closing message: %w", err)
+	}
+
+	return c.Quit()
+}
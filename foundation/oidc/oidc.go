@@ -0,0 +1,248 @@
+// Package oidc provides an OpenID Connect relying party supporting the
+// authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Fiiii/WT/foundation/keystore"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Config represents the registration of the service with a provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	Client       *http.Client
+}
+
+// IDToken represents the verified claims of an ID token.
+type IDToken struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Nonce         string
+}
+
+// idClaims represents the claims of an ID token as transmitted via a JWT.
+type idClaims struct {
+	jwt.RegisteredClaims
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+}
+
+// metadata represents the parts of the provider discovery document we use.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to an OpenID Connect provider on behalf of the service.
+type Provider struct {
+	cfg      Config
+	authURL  string
+	tokenURL string
+	keys     *keystore.Remote
+	parser   *jwt.Parser
+}
+
+// NewProvider constructs a Provider from the discovery document of the
+// issuer. A nil client uses a client with a 5 second timeout.
+func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 5 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching discovery document: unexpected status %d", resp.StatusCode)
+	}
+
+	var md metadata
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&md); err != nil {
+		return nil, fmt.Errorf("decoding discovery document: %w", err)
+	}
+
+	if strings.TrimSuffix(md.Issuer, "/") != cfg.Issuer {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q", md.Issuer, cfg.Issuer)
+	}
+
+	p := Provider{
+		cfg:      cfg,
+		authURL:  md.AuthorizationEndpoint,
+		tokenURL: md.TokenEndpoint,
+		keys:     keystore.NewRemote(md.JWKSURI, cfg.Client, time.Minute),
+
+		// The algorithm used to sign the ID token must be validated, the
+		// same as for our own tokens.
+		parser: jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "ES256", "ES384", "ES512", "EdDSA"})),
+	}
+
+	return &p, nil
+}
+
+// AuthCodeURL returns the URL of the provider's consent page the user agent
+// is sent to. The challenge is the S256 PKCE challenge of the verifier later
+// passed to Exchange.
+func (p *Provider) AuthCodeURL(state string, nonce string, challenge string) string {
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return p.authURL + sep + v.Encode()
+}
+
+// Exchange trades an authorization code for the ID token of the user and
+// verifies it. The nonce of the token is returned for the caller to check.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string) (IDToken, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return IDToken{}, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return IDToken{}, fmt.Errorf("exchanging code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tkn struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&tkn); err != nil {
+		return IDToken{}, fmt.Errorf("decoding token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return IDToken{}, fmt.Errorf("exchanging code: %s: %s", tkn.Error, tkn.ErrorDescription)
+	}
+
+	if tkn.IDToken == "" {
+		return IDToken{}, errors.New("token response is missing the id_token")
+	}
+
+	return p.Verify(tkn.IDToken)
+}
+
+// Verify checks the signature, issuer, audience and expiration of an ID
+// token and returns its claims.
+func (p *Provider) Verify(rawIDToken string) (IDToken, error) {
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		kid, ok := t.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("missing key id (kid) in token header")
+		}
+		return p.keys.PublicKey(kid)
+	}
+
+	var claims idClaims
+	token, err := p.parser.ParseWithClaims(rawIDToken, &claims, keyFunc)
+	if err != nil {
+		return IDToken{}, fmt.Errorf("parsing id token: %w", err)
+	}
+
+	if !token.Valid {
+		return IDToken{}, errors.New("invalid id token")
+	}
+
+	switch {
+	case claims.Issuer != p.cfg.Issuer:
+		return IDToken{}, fmt.Errorf("id token issuer %q is not trusted", claims.Issuer)
+	case !claims.VerifyAudience(p.cfg.ClientID, true):
+		return IDToken{}, errors.New("id token was not issued for this client")
+	case !claims.VerifyExpiresAt(time.Now(), true):
+		return IDToken{}, errors.New("id token is expired")
+	case claims.Subject == "":
+		return IDToken{}, errors.New("id token is missing the subject")
+	}
+
+	idToken := IDToken{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		Nonce:         claims.Nonce,
+	}
+
+	return idToken, nil
+}
+
+// =============================================================================
+
+// RandomValue returns a random URL safe value suitable for the state and
+// nonce parameters.
+func RandomValue() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE challenge of a code verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,77 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fiiii/WT/foundation/totp"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestCode(t *testing.T) {
+
+	// Test vectors from RFC 6238 appendix B, truncated to 6 digits.
+	secret := strings.TrimRight(base32.StdEncoding.EncodeToString([]byte("12345678901234567890")), "=")
+
+	tt := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	t.Log("Given the need to generate one-time passwords.")
+	{
+		for testID, tc := range tt {
+			t.Logf("\tTest %d:\tWhen generating the code at %d.", testID, tc.unix)
+			{
+				code, err := totp.Code(secret, totp.Step(time.Unix(tc.unix, 0)))
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to generate a code: %v", failed, testID, err)
+				}
+				if code != tc.code {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected code: got %s want %s", failed, testID, code, tc.code)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected code.", success, testID)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Log("Given the need to validate one-time passwords.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen validating codes around the current time.", testID)
+		{
+			secret, err := totp.GenerateSecret()
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to generate a secret: %v", failed, testID, err)
+			}
+
+			now := time.Now()
+			previous, _ := totp.Code(secret, totp.Step(now)-1)
+			if step, ok := totp.Validate(secret, previous, now); !ok || step != totp.Step(now)-1 {
+				t.Fatalf("\t%s\tTest %d:\tShould accept the code of the previous step.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould accept the code of the previous step.", success, testID)
+
+			stale, _ := totp.Code(secret, totp.Step(now)-2)
+			if _, ok := totp.Validate(secret, stale, now); ok {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT accept the code of an older step.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT accept the code of an older step.", success, testID)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// OTLPWriter exports spans as OTLP JSON, one ExportTraceServiceRequest per
+// line, like the OpenTelemetry collector file exporter. It works offline and
+// the output can be replayed into a collector later.
+type OTLPWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	service string
+}
+
+// NewOTLPWriter constructs an exporter writing to w, such as os.Stdout or a
+// file, for the named service.
+func NewOTLPWriter(w io.Writer, service string) *OTLPWriter {
+	return &OTLPWriter{
+		w:       w,
+		service: service,
+	}
+}
+
+// Export writes the spans. It implements the Exporter interface.
+func (e *OTLPWriter) Export(ctx context.Context, spans []SpanData) error {
+	otlpSpans := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		otlpSpans[i] = toOTLPSpan(s)
+	}
+
+	req := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource: otlpResource{
+					Attributes: []otlpKeyValue{{Key: "service.name", Value: otlpValue{StringValue: &e.service}}},
+				},
+				ScopeSpans: []otlpScopeSpans{
+					{
+						Scope: otlpScope{Name: "github.com/Fiiii/WT/foundation/trace"},
+						Spans: otlpSpans,
+					},
+				},
+			},
+		},
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshaling spans: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := e.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing spans: %w", err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// These types follow the JSON mapping of the OTLP protobuf messages.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// toOTLPSpan converts a finished span. Attributes are sorted by key so the
+// output is stable.
+func toOTLPSpan(s SpanData) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.SpanContext.TraceID.String(),
+		SpanID:            s.SpanContext.SpanID.String(),
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+	}
+
+	if s.ParentSpanID != (SpanID{}) {
+		span.ParentSpanID = s.ParentSpanID.String()
+	}
+
+	// Status codes are 1 for ok and 2 for error.
+	span.Status.Code = 1
+	if s.Err != "" {
+		span.Status = otlpStatus{Code: 2, Message: s.Err}
+	}
+
+	keys := make([]string, 0, len(s.Attributes))
+	for k := range s.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		span.Attributes = append(span.Attributes, otlpKeyValue{Key: k, Value: toOTLPValue(s.Attributes[k])})
+	}
+
+	return span
+}
+
+// toOTLPValue converts an attribute value, formatting unknown types as
+// strings.
+func toOTLPValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
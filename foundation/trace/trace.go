@@ -0,0 +1,338 @@
+// Package trace provides request scoped tracing compatible with OpenTelemetry.
+// Trace context is propagated with the W3C traceparent header and finished
+// spans are handed to an exporter.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace across every service it passes through.
+type TraceID [16]byte
+
+// String returns the trace id as lower case hex.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns the span id as lower case hex.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext represents the part of a span propagated to other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports if the trace and span ids are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(h string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, errors.New("malformed traceparent")
+	}
+
+	// Version 00 has exactly four fields, later versions may append more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, errors.New("malformed traceparent")
+	}
+
+	var sc SpanContext
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return SpanContext{}, fmt.Errorf("trace id: %w", err)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return SpanContext{}, fmt.Errorf("span id: %w", err)
+	}
+
+	var flags [1]byte
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return SpanContext{}, fmt.Errorf("flags: %w", err)
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return SpanContext{}, errors.New("all zero trace or span id")
+	}
+
+	return sc, nil
+}
+
+// Kind describes the relationship of a span to its remote peers. The values
+// match the OTLP span kinds.
+type Kind int
+
+// Set of span kinds.
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// SpanData represents a finished span.
+type SpanData struct {
+	Name         string
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Kind         Kind
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Err          string
+}
+
+// Exporter is the behavior required to ship finished spans.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Tracer starts root spans and hands finished sampled spans to its exporter.
+type Tracer struct {
+	exporter Exporter
+	onError  func(error)
+}
+
+// New constructs a tracer. Spans are created but not exported when the
+// exporter is nil. The onError function, when not nil, is told about failed
+// exports.
+func New(exporter Exporter, onError func(error)) *Tracer {
+	return &Tracer{
+		exporter: exporter,
+		onError:  onError,
+	}
+}
+
+// Start starts a span of the specified kind as a child of the remote span
+// context, or as the root of a new trace when it isn't valid. A trace started
+// here is always sampled.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind, remote SpanContext) (context.Context, *Span) {
+	sc := SpanContext{
+		TraceID: remote.TraceID,
+		SpanID:  newSpanID(),
+		Sampled: remote.Sampled,
+	}
+
+	var parent SpanID
+	switch remote.IsValid() {
+	case true:
+		parent = remote.SpanID
+	default:
+		sc.TraceID = newTraceID()
+		sc.Sampled = true
+	}
+
+	return t.start(ctx, name, kind, sc, parent)
+}
+
+// Start starts a span as a child of the span in the context. Without a span
+// in the context it returns a nil span, which is safe to use and does
+// nothing.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+
+	sc := SpanContext{
+		TraceID: parent.data.SpanContext.TraceID,
+		SpanID:  newSpanID(),
+		Sampled: parent.data.SpanContext.Sampled,
+	}
+
+	return parent.tracer.start(ctx, name, kind, sc, parent.data.SpanContext.SpanID)
+}
+
+// start constructs the span and stores it in the context.
+func (t *Tracer) start(ctx context.Context, name string, kind Kind, sc SpanContext, parent SpanID) (context.Context, *Span) {
+	s := Span{
+		tracer: t,
+		data: SpanData{
+			Name:         name,
+			SpanContext:  sc,
+			ParentSpanID: parent,
+			Kind:         kind,
+			Start:        time.Now(),
+			Attributes:   make(map[string]interface{}),
+		},
+	}
+
+	return context.WithValue(ctx, key, &s), &s
+}
+
+// =============================================================================
+
+// Span represents a single operation within a trace. All methods are safe to
+// call on a nil span.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SpanContext returns the propagated part of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute records a key value pair describing the operation. Values
+// should be strings, integers, floats or booleans.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks the span as failed with the error.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Err = err.Error()
+}
+
+// End finishes the span and exports it when it is sampled. Calling End more
+// than once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if !data.SpanContext.Sampled || s.tracer.exporter == nil {
+		return
+	}
+
+	if err := s.tracer.exporter.Export(context.Background(), []SpanData{data}); err != nil && s.tracer.onError != nil {
+		s.tracer.onError(err)
+	}
+}
+
+// =============================================================================
+
+// ctxKey represents the type of value for the context key.
+type ctxKey int
+
+// key is how the current span is stored/retrieved.
+const key ctxKey = 1
+
+// FromContext returns the current span from the context, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(key).(*Span)
+	return s
+}
+
+// Inject sets the traceparent header for the span in the context, so the
+// receiving service continues the trace.
+func Inject(ctx context.Context, h http.Header) {
+	if sc := FromContext(ctx).SpanContext(); sc.IsValid() {
+		h.Set("traceparent", sc.Traceparent())
+	}
+}
+
+// Transport is an http.RoundTripper which records a client span for every
+// request and propagates the trace to the server.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx, span := Start(r.Context(), "HTTP "+r.Method, KindClient)
+	defer span.End()
+
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.url", r.URL.Redacted())
+
+	if span != nil {
+		r = r.Clone(ctx)
+		Inject(ctx, r.Header)
+	}
+
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	return resp, nil
+}
+
+// =============================================================================
+
+// newTraceID generates a random trace id.
+func newTraceID() TraceID {
+	var t TraceID
+	rand.Read(t[:])
+	return t
+}
+
+// newSpanID generates a random span id.
+func newSpanID() SpanID {
+	var s SpanID
+	rand.Read(s[:])
+	return s
+}
+
+// decodeHex decodes lower case hex of exactly the length of dst.
+func decodeHex(dst []byte, s string) error {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return errors.New("invalid length or case")
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
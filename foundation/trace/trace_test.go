@@ -0,0 +1,143 @@
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Fiiii/WT/foundation/trace"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestTraceparent(t *testing.T) {
+	tt := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"extra field", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xx", false},
+		{"short span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", false},
+	}
+
+	t.Log("Given the need to parse W3C traceparent headers.")
+	{
+		for testID, tc := range tt {
+			t.Logf("\tTest %d:\tWhen handling a %s header.", testID, tc.name)
+			{
+				sc, err := trace.ParseTraceparent(tc.value)
+				if (err == nil) != tc.valid {
+					t.Fatalf("\t%s\tTest %d:\tShould get valid %v : %v.", failed, testID, tc.valid, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get valid %v.", success, testID, tc.valid)
+
+				if tc.valid && sc.Traceparent() != tc.value {
+					t.Fatalf("\t%s\tTest %d:\tShould format the same header : got %s.", failed, testID, sc.Traceparent())
+				}
+			}
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	t.Log("Given the need to trace requests across services.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen continuing a remote trace.", testID)
+		{
+			var buf bytes.Buffer
+			tracer := trace.New(trace.NewOTLPWriter(&buf, "wt-api"), nil)
+
+			remote, _ := trace.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			ctx, root := tracer.Start(context.Background(), "GET /v1/users", trace.KindServer, remote)
+
+			// The downstream service must see the same trace with our span
+			// as its parent.
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sc, err := trace.ParseTraceparent(r.Header.Get("traceparent"))
+				if err != nil || sc.TraceID != remote.TraceID {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			}))
+			defer srv.Close()
+
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+			client := http.Client{Transport: trace.Transport{}}
+			resp, err := client.Do(req)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("\t%s\tTest %d:\tShould propagate the trace : %v.", failed, testID, err)
+			}
+			resp.Body.Close()
+			t.Logf("\t%s\tTest %d:\tShould propagate the trace.", success, testID)
+
+			_, child := trace.Start(ctx, "database.NamedQueryStruct", trace.KindClient)
+			child.RecordError(errors.New("boom"))
+			child.End()
+			root.End()
+			root.End()
+
+			var spans []map[string]interface{}
+			dec := json.NewDecoder(&buf)
+			for dec.More() {
+				var req struct {
+					ResourceSpans []struct {
+						ScopeSpans []struct {
+							Spans []map[string]interface{}
+						}
+					}
+				}
+				if err := dec.Decode(&req); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould export OTLP JSON : %s.", failed, testID, err)
+				}
+				spans = append(spans, req.ResourceSpans[0].ScopeSpans[0].Spans...)
+			}
+
+			if len(spans) != 3 {
+				t.Fatalf("\t%s\tTest %d:\tShould export every span once : got %d.", failed, testID, len(spans))
+			}
+			t.Logf("\t%s\tTest %d:\tShould export every span once.", success, testID)
+
+			rootID := root.SpanContext().SpanID.String()
+			for _, s := range spans[:2] {
+				if s["traceId"] != remote.TraceID.String() || s["parentSpanId"] != rootID {
+					t.Fatalf("\t%s\tTest %d:\tShould export children of the root : %v.", failed, testID, s)
+				}
+			}
+			if spans[2]["parentSpanId"] != remote.SpanID.String() {
+				t.Fatalf("\t%s\tTest %d:\tShould export the root as child of the remote span : %v.", failed, testID, spans[2])
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the parent relationships.", success, testID)
+
+			if status := spans[1]["status"].(map[string]interface{}); status["code"] != float64(2) {
+				t.Fatalf("\t%s\tTest %d:\tShould mark the failed span : %v.", failed, testID, status)
+			}
+			t.Logf("\t%s\tTest %d:\tShould mark the failed span.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen there is no trace in the context.", testID)
+		{
+			ctx, span := trace.Start(context.Background(), "orphan", trace.KindInternal)
+			span.SetAttribute("key", "value")
+			span.End()
+
+			if span != nil || trace.FromContext(ctx) != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould get a no-op span.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get a no-op span.", success, testID)
+		}
+	}
+}
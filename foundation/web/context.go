@@ -12,11 +12,14 @@ type ctxKey int
 // key is how request values are stored/retrieved.
 const key ctxKey = 1
 
-// Values represents the values from the context.
+// Values represents the values from the context. The status code, bytes
+// written and time to first byte are recorded as the response is written.
 type Values struct {
-	TraceID    string
-	Now        time.Time
-	StatusCode int
+	TraceID         string
+	Now             time.Time
+	StatusCode      int
+	BytesWritten    int64
+	TimeToFirstByte time.Duration
 }
 
 // GetValues returns the values from the context.
@@ -29,16 +32,19 @@ func GetValues(ctx context.Context) (*Values, error) {
 	return v, nil
 }
 
-// GetTraceID returns the trace id from the context.
+// GetTraceID returns the trace id from the context as 32 hex characters,
+// like in the W3C traceparent header.
 func GetTraceID(ctx context.Context) string {
 	v, ok := ctx.Value(key).(*Values)
 	if !ok {
-		return "00000000-0000-0000-0000-000000000000"
+		return "00000000000000000000000000000000"
 	}
 	return v.TraceID
 }
 
-// SetStatusCode sets the status code back into the context.
+// SetStatusCode sets the status code back into the context. This is only
+// needed by handlers that do not write their response through the
+// http.ResponseWriter passed by the App, which records it already.
 func SetStatusCode(ctx context.Context, statusCode int) error {
 	v, ok := ctx.Value(key).(*Values)
 	if !ok {
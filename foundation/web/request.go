@@ -3,6 +3,7 @@ package web
 import (
 	"encoding/json"
 	"github.com/dimfeld/httptreemux/v5"
+	"net"
 	"net/http"
 )
 
@@ -12,6 +13,22 @@ func Param(r *http.Request, key string) string {
 	 return pm[key]
 }
 
+// Route returns the pattern of the route which matched the request, such as
+// /v1/users/:id.
+func Route(r *http.Request) string {
+	return httptreemux.ContextRoute(r.Context())
+}
+
+// RemoteIP returns the address of the client connected to the service.
+// Forwarding headers are ignored since clients can set them freely.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // Decode reads the body of an HTTP request for a JSON document.
 // If val is a struct - then it is checked for validation tags.
 func Decode(r *http.Request, val interface{}) error {
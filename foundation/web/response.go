@@ -19,8 +19,11 @@ func Respond(ctx context.Context, w http.ResponseWriter, data interface{}, statu
 		return err
 	}
 
-	// Set the content type and headers after marshalling success.
-	w.Header().Set("Content-Type", "application/json")
+	// Set the content type and headers after marshalling success, unless
+	// the caller picked a more specific JSON media type.
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 
 	// Write the status code to the response.
 	w.WriteHeader(statusCode)
@@ -3,12 +3,12 @@ package web
 import (
 	"context"
 	"fmt"
-	"github.com/google/uuid"
 	"net/http"
 	"os"
 	"syscall"
 	"time"
 
+	"github.com/Fiiii/WT/foundation/trace"
 	"github.com/dimfeld/httptreemux/v5"
 )
 
@@ -19,15 +19,23 @@ type Handler func(ctx context.Context, w http.ResponseWriter, r *http.Request) e
 type App struct {
 	shutdown   chan os.Signal
 	ContextMux *httptreemux.ContextMux
+	tracer     *trace.Tracer
 	mw         []Middleware
 }
 
-// NewApp created new application instance.
-func NewApp(shutdown chan os.Signal, mw ...Middleware) *App {
+// NewApp created new application instance. Every request gets a span from
+// the tracer, continuing the trace of the caller when it sent a traceparent
+// header. Spans are not exported when the tracer is nil.
+func NewApp(shutdown chan os.Signal, tracer *trace.Tracer, mw ...Middleware) *App {
+	if tracer == nil {
+		tracer = trace.New(nil, nil)
+	}
+
 	mux := httptreemux.NewContextMux()
 	return &App{
 		shutdown:   shutdown,
 		ContextMux: mux,
+		tracer:     tracer,
 		mw:         mw,
 	}
 }
@@ -53,35 +61,51 @@ func (a *App) Handle(method, group, path string, handler Handler, mw ...Middlewa
 	// Secondly wrap by application's general middleware.
 	handler = wrapMiddleware(a.mw, handler)
 
+	// Creates end path based on provided group
+	finalPath := path
+	if group != "" {
+		finalPath = fmt.Sprintf("/%s%s", group, path)
+	}
+
 	// The function to execute for each request.
 	h := func(w http.ResponseWriter, r *http.Request) {
-		// Pull the context
-		ctx := r.Context()
 
-		// Capture the parent request span from the context.
-		//span := trace.SpanFromContext(ctx)
+		// Continue the trace of the caller, or start a new one when the
+		// traceparent header is missing or malformed.
+		remote, _ := trace.ParseTraceparent(r.Header.Get("traceparent"))
+		ctx, span := a.tracer.Start(r.Context(), method+" "+finalPath, trace.KindServer, remote)
+		defer span.End()
+
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", finalPath)
 
 		// Set the context with the required values to
 		// process the request.
 		v := Values{
-			TraceID: uuid.New().String(),
+			TraceID: span.SpanContext().TraceID.String(),
 			Now:     time.Now(),
 		}
 
 		ctx = context.WithValue(ctx, key, &v)
 
-		if err := handler(ctx, w, r); err != nil {
+		// Record the status code and size of the response as it is written.
+		err := handler(ctx, newResponseWriter(w, &v), r)
+		span.SetAttribute("http.status_code", v.StatusCode)
+		span.SetAttribute("http.response_size", v.BytesWritten)
+
+		// Errors are usually turned into responses by the middleware before
+		// they get here, so failed requests are seen by their status code.
+		if v.StatusCode >= http.StatusBadRequest {
+			span.RecordError(fmt.Errorf("%d %s", v.StatusCode, http.StatusText(v.StatusCode)))
+		}
+
+		if err != nil {
+			span.RecordError(err)
 			a.SignalShutdown()
 			return
 		}
 	}
 
-	// Creates end path based on provided group
-	finalPath := path
-	if group != "" {
-		finalPath = fmt.Sprintf("/%s%s", group, path)
-	}
-
 	// Final handle by using httptreemux
 	a.ContextMux.Handle(method, finalPath, h)
 }
@@ -0,0 +1,60 @@
+package web_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/Fiiii/WT/foundation/trace"
+	"github.com/Fiiii/WT/foundation/web"
+)
+
+// exporter keeps the spans it is given.
+type exporter struct {
+	spans []trace.SpanData
+}
+
+func (e *exporter) Export(ctx context.Context, spans []trace.SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestSpanStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		failed bool
+	}{
+		{"ok", http.StatusOK, false},
+		{"client error", http.StatusBadRequest, true},
+		{"server error", http.StatusInternalServerError, true},
+	}
+
+	t.Log("Given the need to mark the spans of failed requests.")
+	{
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen handling a %s response.", testID, tt.name)
+			{
+				var exp exporter
+				h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+					return web.Respond(ctx, w, nil, tt.status)
+				}
+
+				app := web.NewApp(make(chan os.Signal, 1), trace.New(&exp, nil))
+				app.Handle(http.MethodGet, "", "/test", h)
+				app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+				if len(exp.spans) != 1 {
+					t.Fatalf("\t%s\tTest %d:\tShould export the span : got %d.", failed, testID, len(exp.spans))
+				}
+
+				if got := exp.spans[0].Err != ""; got != tt.failed {
+					t.Fatalf("\t%s\tTest %d:\tShould mark the span as failed %t : got %q.", failed, testID, tt.failed, exp.spans[0].Err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould mark the span as failed %t.", success, testID, tt.failed)
+			}
+		}
+	}
+}
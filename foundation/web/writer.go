@@ -0,0 +1,110 @@
+package web
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// responseWriter wraps the http.ResponseWriter of a request to record the
+// status code, bytes written and time to first byte in the request values.
+// Flush, Hijack and ReadFrom are passed through to the wrapped writer when
+// it supports them.
+type responseWriter struct {
+	http.ResponseWriter
+	v           *Values
+	wroteHeader bool
+}
+
+// newResponseWriter constructs a writer recording into the values.
+func newResponseWriter(w http.ResponseWriter, v *Values) *responseWriter {
+	return &responseWriter{
+		ResponseWriter: w,
+		v:              v,
+	}
+}
+
+// WriteHeader records the status code before sending it to the client. Like
+// the standard library, only the first call has any effect.
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.record(statusCode)
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write records the bytes written, with an implicit 200 when no status code
+// was written first.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.v.BytesWritten += int64(n)
+	return n, err
+}
+
+// ReadFrom lets the wrapped writer use sendfile when it can. The implicit
+// 200 is only recorded, so the wrapped writer can still sniff the content
+// type from the first bytes.
+func (w *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := w.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(writerOnly{w}, src)
+	}
+
+	if !w.wroteHeader {
+		w.record(http.StatusOK)
+	}
+	n, err := rf.ReadFrom(src)
+	w.v.BytesWritten += n
+	return n, err
+}
+
+// Flush sends any buffered data to the client. It does nothing when the
+// wrapped writer does not buffer.
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection, which is recorded as
+// switching protocols when no status code was written.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	conn, rw, err := h.Hijack()
+	if err == nil && !w.wroteHeader {
+		w.record(http.StatusSwitchingProtocols)
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the wrapped writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// record stores the status code and how long it took to start responding.
+func (w *responseWriter) record(statusCode int) {
+	w.wroteHeader = true
+	w.v.StatusCode = statusCode
+	w.v.TimeToFirstByte = time.Since(w.v.Now)
+}
+
+// writerOnly hides the ReadFrom method of the writer so io.Copy does not
+// call it again.
+type writerOnly struct {
+	io.Writer
+}
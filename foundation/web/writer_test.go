@@ -0,0 +1,113 @@
+package web_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Fiiii/WT/foundation/web"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestResponseWriter(t *testing.T) {
+	t.Log("Given the need to record the responses written by handlers.")
+	{
+		tests := []struct {
+			name   string
+			status int
+			bytes  int64
+			h      web.Handler
+		}{
+			{"respond", http.StatusCreated, 11, func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				return web.Respond(ctx, w, map[string]int{"id": 1000}, http.StatusCreated)
+			}},
+			{"no content", http.StatusNoContent, 0, func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				return web.Respond(ctx, w, nil, http.StatusNoContent)
+			}},
+			{"implicit ok", http.StatusOK, 5, func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				w.Write([]byte("hello"))
+				w.WriteHeader(http.StatusTeapot)
+				return nil
+			}},
+			{"read from", http.StatusOK, 11, func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				_, err := io.Copy(w, strings.NewReader("hello world"))
+				return err
+			}},
+		}
+
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen handling a %s response.", testID, tt.name)
+			{
+				var got web.Values
+				record := func(handler web.Handler) web.Handler {
+					return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+						err := handler(ctx, w, r)
+						v, _ := web.GetValues(ctx)
+						got = *v
+						return err
+					}
+				}
+
+				app := web.NewApp(make(chan os.Signal, 1), nil, record)
+				app.Handle(http.MethodGet, "", "/test", tt.h)
+
+				w := httptest.NewRecorder()
+				app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+				if got.StatusCode != tt.status || w.Code != tt.status {
+					t.Fatalf("\t%s\tTest %d:\tShould record status %d : got %d, sent %d.", failed, testID, tt.status, got.StatusCode, w.Code)
+				}
+				t.Logf("\t%s\tTest %d:\tShould record status %d.", success, testID, tt.status)
+
+				if got.BytesWritten != tt.bytes || int64(w.Body.Len()) != tt.bytes {
+					t.Fatalf("\t%s\tTest %d:\tShould record %d bytes : got %d, sent %d.", failed, testID, tt.bytes, got.BytesWritten, w.Body.Len())
+				}
+				t.Logf("\t%s\tTest %d:\tShould record %d bytes.", success, testID, tt.bytes)
+
+				if got.TimeToFirstByte <= 0 {
+					t.Fatalf("\t%s\tTest %d:\tShould record the time to first byte : %v.", failed, testID, got.TimeToFirstByte)
+				}
+				t.Logf("\t%s\tTest %d:\tShould record the time to first byte.", success, testID)
+			}
+		}
+
+		testID := len(tests)
+		t.Logf("\tTest %d:\tWhen the handler needs the optional interfaces.", testID)
+		{
+			var flushed, hijackable bool
+			h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				if f, ok := w.(http.Flusher); ok {
+					f.Flush()
+					flushed = true
+				}
+				_, hijackable = w.(http.Hijacker)
+				return nil
+			}
+
+			app := web.NewApp(make(chan os.Signal, 1), nil)
+			app.Handle(http.MethodGet, "", "/test", h)
+
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+			if !flushed || !w.Flushed {
+				t.Fatalf("\t%s\tTest %d:\tShould flush the wrapped writer.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould flush the wrapped writer.", success, testID)
+
+			if !hijackable {
+				t.Fatalf("\t%s\tTest %d:\tShould expose hijacking.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould expose hijacking.", success, testID)
+		}
+	}
+}